@@ -0,0 +1,111 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/experiment"
+)
+
+// diff compares the recorded executions of two transactions, aligning their
+// call frames in execution order up to the first one that differs.
+func diff(path string, a, b common.Hash) ([]string, [][]string, error) {
+	r, err := experiment.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	txA, err := r.TxByHash(a)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%x: %v", a, err)
+	}
+	txB, err := r.TxByHash(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%x: %v", b, err)
+	}
+	rows := [][]string{
+		{"tx", txA.Hash.Hex(), txB.Hash.Hex()},
+		{"block", fmt.Sprint(txA.Block), fmt.Sprint(txB.Block)},
+	}
+	if txA.Receipt != nil && txB.Receipt != nil {
+		rows = append(rows,
+			[]string{"failed", fmt.Sprint(txA.Receipt.Failed), fmt.Sprint(txB.Receipt.Failed)},
+			[]string{"gas used", fmt.Sprint(txA.Receipt.GasUsed), fmt.Sprint(txB.Receipt.GasUsed)},
+		)
+	}
+	rows = append(rows,
+		[]string{"frames", fmt.Sprint(len(txA.Frames)), fmt.Sprint(len(txB.Frames))},
+		[]string{"depth", fmt.Sprint(txA.Depth()), fmt.Sprint(txB.Depth())},
+	)
+	// Find the first frame the executions diverged at
+	i := 0
+	for ; i < len(txA.Frames) && i < len(txB.Frames); i++ {
+		if !sameFrame(txA.Frames[i], txB.Frames[i]) {
+			break
+		}
+	}
+	if i == len(txA.Frames) && i == len(txB.Frames) {
+		rows = append(rows, []string{"diverged at", "-", "-"})
+		return []string{"", "a", "b"}, rows, nil
+	}
+	rows = append(rows, []string{"diverged at", fmt.Sprintf("frame %d", i), fmt.Sprintf("frame %d", i)})
+	for _, field := range []struct {
+		name   string
+		format func(*experiment.Frame) string
+	}{
+		{"method", func(f *experiment.Frame) string { return f.Method }},
+		{"parent", func(f *experiment.Frame) string { return fmt.Sprint(f.Parent) }},
+		{"to", func(f *experiment.Frame) string {
+			if f.To == nil {
+				return "-"
+			}
+			return f.To.Hex()
+		}},
+		{"value", func(f *experiment.Frame) string { return fmt.Sprint(f.Value) }},
+		{"input", func(f *experiment.Frame) string { return f.Data.String() }},
+		{"error", func(f *experiment.Frame) string { return f.Error }},
+	} {
+		row := []string{field.name, "-", "-"}
+		if i < len(txA.Frames) {
+			row[1] = field.format(txA.Frames[i])
+		}
+		if i < len(txB.Frames) {
+			row[2] = field.format(txB.Frames[i])
+		}
+		rows = append(rows, row)
+	}
+	return []string{"", "a", "b"}, rows, nil
+}
+
+// sameFrame reports whether two call frames were entered the same way and
+// ended the same way.
+func sameFrame(a, b *experiment.Frame) bool {
+	if a.Method != b.Method || a.Parent != b.Parent || a.Error != b.Error || a.CalleeExecuted != b.CalleeExecuted {
+		return false
+	}
+	if (a.To == nil) != (b.To == nil) || (a.To != nil && *a.To != *b.To) {
+		return false
+	}
+	if (a.Value == nil) != (b.Value == nil) || (a.Value != nil && a.Value.Cmp(b.Value) != 0) {
+		return false
+	}
+	return bytes.Equal(a.Data, b.Data)
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/experiment"
+)
+
+// locate lists the blocks of a sparse record file which may have transactions
+// failed calling the given contract and function with the given exception
+// kind, each of them left out if "-" or empty.
+func locate(path, contract, selector, kind string) ([]string, [][]string, error) {
+	r, err := experiment.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	var (
+		addr *common.Address
+		sel  []byte
+	)
+	if contract != "" && contract != "-" {
+		if !common.IsHexAddress(contract) {
+			return nil, nil, fmt.Errorf("invalid contract %q", contract)
+		}
+		a := common.HexToAddress(contract)
+		addr = &a
+	}
+	if selector != "" && selector != "-" {
+		if sel, err = hexutil.Decode(selector); err != nil || len(sel) != 4 {
+			return nil, nil, fmt.Errorf("invalid selector %q", selector)
+		}
+	}
+	switch kind {
+	case "", "-":
+		kind = ""
+	case experiment.KindRevert, experiment.KindOutOfGas, experiment.KindInvalidJump, experiment.KindInvalidOpcode, experiment.KindStack, experiment.KindOther:
+	default:
+		return nil, nil, fmt.Errorf("invalid exception kind %q", kind)
+	}
+	blooms, err := r.Locate(addr, sel, kind)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := []string{"block", "hash", "failed"}
+	rows := make([][]string, 0, len(blooms))
+	for _, bloom := range blooms {
+		rows = append(rows, []string{strconv.FormatUint(bloom.Number, 10), bloom.Hash.Hex(), strconv.Itoa(bloom.Failed)})
+	}
+	return header, rows, nil
+}
@@ -0,0 +1,477 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+// experiment-query runs common analyses over recorded transaction data.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/experiment"
+)
+
+var (
+	csvMode = flag.Bool("csv", false, "print results as CSV instead of a table")
+	limit   = flag.Int("n", 20, "number of rows printed by the top-N queries")
+	window  = flag.Uint64("window", 10000, "number of blocks per row of the failrate query")
+
+	weightSpec = flag.String("weights", "value=1,gas=1,popularity=1,depth=0", "severity weights of the triage query")
+	sigPath    = flag.String("signatures", "", "CSV file of selectors and signatures resolving the functions query")
+)
+
+// queries are the available analyses, each aggregating transactions into rows.
+var queries = map[string]func() query{
+	"failures":  newFailuresQuery,
+	"depth":     newDepthQuery,
+	"failrate":  newFailRateQuery,
+	"triage":    newTriageQuery,
+	"functions": newFunctionsQuery,
+	"opcodes":   newOpcodesQuery,
+	"outofgas":  newOutOfGasQuery,
+}
+
+// query aggregates the transactions of the canonical chain into a result table.
+type query interface {
+	add(tx *experiment.Transaction)
+	result() (header []string, rows [][]string)
+}
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[-csv] [-n <rows>] [-window <blocks>] [-weights <spec>] [-signatures <csv>] <query> <filename>")
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], "[-csv] diff <filename> <tx> <tx>")
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], "[-csv] verify <filename> <chaindata> <from> <to>")
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], "trace <filename> <tx> [callTracer|structLogger]")
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], "[-csv] locate <filename> <contract|-> [<selector|-> [<kind>]]")
+		flag.PrintDefaults()
+		fmt.Fprintln(os.Stderr, `
+Runs a query over the transaction data recorded into the given file:
+
+  failures   contracts called by the most failed transactions
+  depth      transactions with the deepest call trees
+  failrate   failed transaction rate per window of blocks
+  triage     failed transactions ordered by severity: the ether they sent
+             (value), the ether they spent on gas (gas), the popularity of
+             the called contract (popularity, log10 of its transactions) and
+             the depth their failure originated at (depth), weighted by
+             -weights
+  functions  functions with the most failed calls, by contract and selector,
+             resolved through the -signatures database if given
+  opcodes    operations frames faulted at, by exception kind, of files
+             recorded with fault context
+  outofgas   out-of-gas transaction rate per window of blocks
+
+Records of orphaned blocks are ignored. The diff command compares two
+transactions (e.g. a failed one and its successful retry), showing the
+first call frame their executions diverged at. The verify command
+cross-checks the transactions recorded for a range of blocks against
+the receipts of a chain database not in use by a node. The trace command
+prints a transaction in the JSON format of a debug_trace* tracer, the
+callTracer by default. The locate command lists the blocks of a file
+recorded in sparse mode which may have transactions failed calling the
+given contract and function with the given exception kind (revert,
+outofgas, invalidjump, invalidopcode, stack or other), to be recorded in
+full.`)
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *limit <= 0 || *window == 0 {
+		fmt.Fprintln(os.Stderr, "Error: -n and -window must be positive")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if flag.Arg(0) == "diff" && flag.NArg() == 4 {
+		header, rows, err := diff(flag.Arg(1), common.HexToHash(flag.Arg(2)), common.HexToHash(flag.Arg(3)))
+		if err == nil {
+			err = printTable(os.Stdout, header, rows)
+		}
+		if err != nil {
+			die(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "trace" && (flag.NArg() == 3 || flag.NArg() == 4) {
+		tracer := "callTracer"
+		if flag.NArg() == 4 {
+			tracer = flag.Arg(3)
+		}
+		if err := trace(os.Stdout, flag.Arg(1), common.HexToHash(flag.Arg(2)), tracer); err != nil {
+			die(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "locate" && flag.NArg() >= 3 && flag.NArg() <= 5 {
+		header, rows, err := locate(flag.Arg(1), flag.Arg(2), flag.Arg(3), flag.Arg(4))
+		if err == nil {
+			err = printTable(os.Stdout, header, rows)
+		}
+		if err != nil {
+			die(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "verify" && flag.NArg() == 5 {
+		from, err1 := strconv.ParseUint(flag.Arg(3), 10, 64)
+		to, err2 := strconv.ParseUint(flag.Arg(4), 10, 64)
+		if err1 != nil || err2 != nil {
+			flag.Usage()
+			os.Exit(2)
+		}
+		header, rows, err := verify(flag.Arg(1), flag.Arg(2), from, to)
+		if err == nil {
+			err = printTable(os.Stdout, header, rows)
+		}
+		if err != nil {
+			die(err)
+		}
+		if len(rows) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	newQuery, ok := queries[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error: unknown query", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+	q := newQuery()
+	r, err := experiment.Open(flag.Arg(1))
+	if err != nil {
+		die(err)
+	}
+	defer r.Close()
+
+	// Gather the orphaned blocks first, as they're only marked after their records
+	orphaned := make(map[common.Hash]bool)
+	if err := r.Iterate(func(tx *experiment.Transaction) error {
+		orphaned[tx.BlockHash] = false
+		return nil
+	}); err != nil {
+		die(err)
+	}
+	for hash := range orphaned {
+		orphaned[hash] = r.Orphaned(hash)
+	}
+	if err := r.Iterate(func(tx *experiment.Transaction) error {
+		if !orphaned[tx.BlockHash] && tx.Receipt != nil {
+			q.add(tx)
+		}
+		return nil
+	}); err != nil {
+		die(err)
+	}
+	header, rows := q.result()
+	if err := printTable(os.Stdout, header, rows); err != nil {
+		die(err)
+	}
+}
+
+// printTable writes the result table either aligned or as CSV.
+func printTable(w io.Writer, header []string, rows [][]string) error {
+	if *csvMode {
+		out := csv.NewWriter(w)
+		out.Write(header)
+		out.WriteAll(rows)
+		return out.Error()
+	}
+	out := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(out, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(out, strings.Join(row, "\t"))
+	}
+	return out.Flush()
+}
+
+// target returns the contract a transaction called or created.
+func target(tx *experiment.Transaction) common.Address {
+	for _, frame := range tx.Frames {
+		if frame.Index == 0 && frame.To != nil {
+			return *frame.To
+		}
+	}
+	return tx.Receipt.ContractAddress
+}
+
+// rate formats the ratio of failed transactions.
+func rate(failed, total int) string {
+	return fmt.Sprintf("%.4f", float64(failed)/float64(total))
+}
+
+type failuresQuery struct {
+	txs, failed map[common.Address]int
+}
+
+func newFailuresQuery() query {
+	return &failuresQuery{txs: make(map[common.Address]int), failed: make(map[common.Address]int)}
+}
+
+func (q *failuresQuery) add(tx *experiment.Transaction) {
+	addr := target(tx)
+	q.txs[addr]++
+	if tx.Receipt.Failed {
+		q.failed[addr]++
+	}
+}
+
+func (q *failuresQuery) result() ([]string, [][]string) {
+	addrs := make([]common.Address, 0, len(q.failed))
+	for addr := range q.failed {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		if q.failed[addrs[i]] != q.failed[addrs[j]] {
+			return q.failed[addrs[i]] > q.failed[addrs[j]]
+		}
+		return addrs[i].Hex() < addrs[j].Hex()
+	})
+	if len(addrs) > *limit {
+		addrs = addrs[:*limit]
+	}
+	rows := make([][]string, 0, len(addrs))
+	for _, addr := range addrs {
+		rows = append(rows, []string{addr.Hex(), fmt.Sprint(q.txs[addr]), fmt.Sprint(q.failed[addr]), rate(q.failed[addr], q.txs[addr])})
+	}
+	return []string{"contract", "txs", "failed", "rate"}, rows
+}
+
+type depthQuery struct {
+	txs []*experiment.Transaction // deepest transactions, sorted by depth
+}
+
+func newDepthQuery() query {
+	return new(depthQuery)
+}
+
+func (q *depthQuery) add(tx *experiment.Transaction) {
+	depth := tx.Depth()
+	if len(q.txs) == *limit && depth <= q.txs[len(q.txs)-1].Depth() {
+		return
+	}
+	i := sort.Search(len(q.txs), func(i int) bool { return q.txs[i].Depth() < depth })
+	q.txs = append(q.txs[:i], append([]*experiment.Transaction{tx}, q.txs[i:]...)...)
+	if len(q.txs) > *limit {
+		q.txs = q.txs[:*limit]
+	}
+}
+
+func (q *depthQuery) result() ([]string, [][]string) {
+	rows := make([][]string, 0, len(q.txs))
+	for _, tx := range q.txs {
+		rows = append(rows, []string{tx.Hash.Hex(), fmt.Sprint(tx.Block), fmt.Sprint(tx.Depth()), fmt.Sprint(len(tx.Frames)), fmt.Sprint(tx.Receipt.Failed)})
+	}
+	return []string{"tx", "block", "depth", "frames", "failed"}, rows
+}
+
+type failRateQuery struct {
+	txs, failed map[uint64]int // counters by window
+}
+
+func newFailRateQuery() query {
+	return &failRateQuery{txs: make(map[uint64]int), failed: make(map[uint64]int)}
+}
+
+func (q *failRateQuery) add(tx *experiment.Transaction) {
+	bucket := tx.Block / *window
+	q.txs[bucket]++
+	if tx.Receipt.Failed {
+		q.failed[bucket]++
+	}
+}
+
+func (q *failRateQuery) result() ([]string, [][]string) {
+	buckets := make([]uint64, 0, len(q.txs))
+	for bucket := range q.txs {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	rows := make([][]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		from := bucket * *window
+		rows = append(rows, []string{fmt.Sprint(from), fmt.Sprint(from + *window - 1), fmt.Sprint(q.txs[bucket]), fmt.Sprint(q.failed[bucket]), rate(q.failed[bucket], q.txs[bucket])})
+	}
+	return []string{"from", "to", "txs", "failed", "rate"}, rows
+}
+
+// function is a function of a contract, identified by its selector.
+type function struct {
+	contract common.Address
+	selector string
+}
+
+type functionsQuery struct {
+	sigs          experiment.Signatures
+	calls, failed map[function]int
+}
+
+func newFunctionsQuery() query {
+	q := &functionsQuery{calls: make(map[function]int), failed: make(map[function]int)}
+	if *sigPath != "" {
+		sigs, err := experiment.LoadSignatures(*sigPath)
+		if err != nil {
+			die(err)
+		}
+		q.sigs = sigs
+	}
+	return q
+}
+
+func (q *functionsQuery) add(tx *experiment.Transaction) {
+	for _, frame := range tx.Frames {
+		if frame.To == nil || frame.Selector == nil {
+			continue
+		}
+		fn := function{*frame.To, frame.Selector.String()}
+		q.calls[fn]++
+		if frame.Error != "" {
+			q.failed[fn]++
+		}
+	}
+}
+
+func (q *functionsQuery) result() ([]string, [][]string) {
+	fns := make([]function, 0, len(q.failed))
+	for fn := range q.failed {
+		fns = append(fns, fn)
+	}
+	sort.Slice(fns, func(i, j int) bool {
+		if q.failed[fns[i]] != q.failed[fns[j]] {
+			return q.failed[fns[i]] > q.failed[fns[j]]
+		}
+		if fns[i].contract != fns[j].contract {
+			return fns[i].contract.Hex() < fns[j].contract.Hex()
+		}
+		return fns[i].selector < fns[j].selector
+	})
+	if len(fns) > *limit {
+		fns = fns[:*limit]
+	}
+	rows := make([][]string, 0, len(fns))
+	for _, fn := range fns {
+		rows = append(rows, []string{fn.contract.Hex(), fn.selector, q.sigs.Lookup(common.FromHex(fn.selector)), fmt.Sprint(q.calls[fn]), fmt.Sprint(q.failed[fn]), rate(q.failed[fn], q.calls[fn])})
+	}
+	return []string{"contract", "selector", "signature", "calls", "failed", "rate"}, rows
+}
+
+// outerError returns the error of the outermost frame of a transaction.
+func outerError(tx *experiment.Transaction) string {
+	for _, frame := range tx.Frames {
+		if frame.Index == 0 {
+			return frame.Error
+		}
+	}
+	return ""
+}
+
+// fault is an operation frames faulted at, along with the kind of their error.
+type fault struct {
+	op, kind string
+}
+
+type opcodesQuery struct {
+	faults map[fault]int
+}
+
+func newOpcodesQuery() query {
+	return &opcodesQuery{faults: make(map[fault]int)}
+}
+
+func (q *opcodesQuery) add(tx *experiment.Transaction) {
+	for _, frame := range tx.Frames {
+		if frame.Fault != nil {
+			q.faults[fault{frame.Fault.Op, experiment.ExceptionKind(frame.Error)}]++
+		}
+	}
+}
+
+func (q *opcodesQuery) result() ([]string, [][]string) {
+	faults := make([]fault, 0, len(q.faults))
+	for f := range q.faults {
+		faults = append(faults, f)
+	}
+	sort.Slice(faults, func(i, j int) bool {
+		if q.faults[faults[i]] != q.faults[faults[j]] {
+			return q.faults[faults[i]] > q.faults[faults[j]]
+		}
+		if faults[i].op != faults[j].op {
+			return faults[i].op < faults[j].op
+		}
+		return faults[i].kind < faults[j].kind
+	})
+	if len(faults) > *limit {
+		faults = faults[:*limit]
+	}
+	rows := make([][]string, 0, len(faults))
+	for _, f := range faults {
+		rows = append(rows, []string{f.op, f.kind, fmt.Sprint(q.faults[f])})
+	}
+	return []string{"op", "kind", "faults"}, rows
+}
+
+type outOfGasQuery struct {
+	txs, failed, outOfGas map[uint64]int // counters by window
+}
+
+func newOutOfGasQuery() query {
+	return &outOfGasQuery{txs: make(map[uint64]int), failed: make(map[uint64]int), outOfGas: make(map[uint64]int)}
+}
+
+func (q *outOfGasQuery) add(tx *experiment.Transaction) {
+	bucket := tx.Block / *window
+	q.txs[bucket]++
+	if tx.Receipt.Failed {
+		q.failed[bucket]++
+		if experiment.ExceptionKind(outerError(tx)) == experiment.KindOutOfGas {
+			q.outOfGas[bucket]++
+		}
+	}
+}
+
+func (q *outOfGasQuery) result() ([]string, [][]string) {
+	buckets := make([]uint64, 0, len(q.txs))
+	for bucket := range q.txs {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	rows := make([][]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		from := bucket * *window
+		rows = append(rows, []string{fmt.Sprint(from), fmt.Sprint(from + *window - 1), fmt.Sprint(q.txs[bucket]), fmt.Sprint(q.failed[bucket]), fmt.Sprint(q.outOfGas[bucket]), rate(q.outOfGas[bucket], q.txs[bucket])})
+	}
+	return []string{"from", "to", "txs", "failed", "outofgas", "rate"}, rows
+}
+
+func die(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, args...)
+	os.Exit(1)
+}
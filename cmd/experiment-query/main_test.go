@@ -0,0 +1,99 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/experiment"
+)
+
+// queryTxs returns a few transactions calling b and c: a revert, an out-of-gas
+// failure with a nested invalid jump, a successful call and an out-of-gas
+// failure faulting at a storage write.
+func queryTxs() []*experiment.Transaction {
+	b, c, d := common.Address{0x0b}, common.Address{0x0c}, common.Address{0x0d}
+	return []*experiment.Transaction{
+		{Hash: common.Hash{0x0a}, Block: 1, Receipt: &experiment.Receipt{Failed: true}, Frames: []*experiment.Frame{
+			{Index: 0, Parent: -1, To: &b, Error: "evm: execution reverted"},
+		}},
+		{Hash: common.Hash{0x0b}, Block: 2, Receipt: &experiment.Receipt{Failed: true}, Frames: []*experiment.Frame{
+			{Index: 0, Parent: -1, To: &b, Error: "out of gas"},
+			{Index: 1, Parent: 0, To: &c},
+			{Index: 2, Parent: 1, To: &d, Error: "invalid jump destination (PUSH1) 5", Fault: &experiment.Fault{Op: "JUMP"}},
+		}},
+		{Hash: common.Hash{0x0c}, Block: 12, Receipt: &experiment.Receipt{}, Frames: []*experiment.Frame{
+			{Index: 0, Parent: -1, To: &c},
+			{Index: 1, Parent: 0, To: &b},
+		}},
+		{Hash: common.Hash{0x0d}, Block: 13, Receipt: &experiment.Receipt{Failed: true}, Frames: []*experiment.Frame{
+			{Index: 0, Parent: -1, To: &c, Error: "out of gas", Fault: &experiment.Fault{Op: "SSTORE"}},
+		}},
+	}
+}
+
+func TestQueries(t *testing.T) {
+	defer func(n int, blocks uint64) { *limit, *window = n, blocks }(*limit, *window)
+	*window = 10
+
+	b, c := common.Address{0x0b}.Hex(), common.Address{0x0c}.Hex()
+	tests := []struct {
+		query  string
+		limit  int
+		header []string
+		rows   [][]string
+	}{
+		{"failures", 2, []string{"contract", "txs", "failed", "rate"}, [][]string{
+			{b, "2", "2", "1.0000"},
+			{c, "2", "1", "0.5000"},
+		}},
+		{"failures", 1, []string{"contract", "txs", "failed", "rate"}, [][]string{
+			{b, "2", "2", "1.0000"},
+		}},
+		{"outofgas", 2, []string{"from", "to", "txs", "failed", "outofgas", "rate"}, [][]string{
+			{"0", "9", "2", "2", "1", "0.5000"},
+			{"10", "19", "2", "1", "1", "0.5000"},
+		}},
+		{"depth", 2, []string{"tx", "block", "depth", "frames", "failed"}, [][]string{
+			{common.Hash{0x0b}.Hex(), "2", "3", "3", "true"},
+			{common.Hash{0x0c}.Hex(), "12", "2", "2", "false"},
+		}},
+		{"depth", 1, []string{"tx", "block", "depth", "frames", "failed"}, [][]string{
+			{common.Hash{0x0b}.Hex(), "2", "3", "3", "true"},
+		}},
+		{"opcodes", 2, []string{"op", "kind", "faults"}, [][]string{
+			{"JUMP", experiment.KindInvalidJump, "1"},
+			{"SSTORE", experiment.KindOutOfGas, "1"},
+		}},
+	}
+	for i, tt := range tests {
+		*limit = tt.limit
+		q := queries[tt.query]()
+		for _, tx := range queryTxs() {
+			q.add(tx)
+		}
+		header, rows := q.result()
+		if !reflect.DeepEqual(header, tt.header) {
+			t.Errorf("test %d (%s): header mismatch: have %v, want %v", i, tt.query, header, tt.header)
+		}
+		if !reflect.DeepEqual(rows, tt.rows) {
+			t.Errorf("test %d (%s): rows mismatch: have %v, want %v", i, tt.query, rows, tt.rows)
+		}
+	}
+}
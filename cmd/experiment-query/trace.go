@@ -0,0 +1,53 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/experiment"
+)
+
+// trace writes a recorded transaction in the output format of the given
+// debug_trace* tracer.
+func trace(w io.Writer, path string, hash common.Hash, tracer string) error {
+	r, err := experiment.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tx, err := r.TxByHash(hash)
+	if err != nil {
+		return fmt.Errorf("%x: %v", hash, err)
+	}
+	var result interface{}
+	switch tracer {
+	case "callTracer":
+		result = tx.CallTrace()
+	case "structLogger":
+		result = tx.StructLogs()
+	default:
+		return fmt.Errorf("unknown tracer %q", tracer)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
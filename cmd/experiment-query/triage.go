@@ -0,0 +1,143 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/experiment"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// severityComponent scores one aspect of a failure's severity. Components are
+// evaluated once all transactions were seen, so they may use the statistics
+// gathered by the query.
+type severityComponent func(q *triageQuery, f *failure) float64
+
+// severityComponents are the available components of a failure's severity, by
+// the name they are weighted with.
+var severityComponents = map[string]severityComponent{
+	// ether sent by the failed transaction
+	"value": func(q *triageQuery, f *failure) float64 { return f.value },
+	// ether spent on the gas of the failed transaction
+	"gas": func(q *triageQuery, f *failure) float64 { return f.gasWasted },
+	// order of magnitude of transactions to the contract
+	"popularity": func(q *triageQuery, f *failure) float64 { return math.Log10(float64(q.txs[f.target])) },
+	// depth of the call frame the failure originated at
+	"depth": func(q *triageQuery, f *failure) float64 { return float64(f.depth) },
+}
+
+// severityWeights are the weights of the components of a failure's severity,
+// by component name.
+type severityWeights map[string]float64
+
+// parseWeights parses a comma separated list of name=weight pairs.
+func parseWeights(spec string) (severityWeights, error) {
+	weights := make(severityWeights)
+	for _, pair := range strings.Split(spec, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return weights, fmt.Errorf("invalid weight %q", pair)
+		}
+		weight, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return weights, fmt.Errorf("invalid weight %q: %v", pair, err)
+		}
+		if _, ok := severityComponents[kv[0]]; !ok {
+			return weights, fmt.Errorf("unknown weight %q", kv[0])
+		}
+		weights[kv[0]] = weight
+	}
+	return weights, nil
+}
+
+// failure is a failed transaction waiting to be scored.
+type failure struct {
+	tx        common.Hash
+	block     uint64
+	target    common.Address
+	value     float64 // in ether
+	gasWasted float64 // in ether
+	depth     int     // depth of the frame the failure originated at, 0 if unknown
+	score     float64
+}
+
+type triageQuery struct {
+	weights  severityWeights
+	txs      map[common.Address]int
+	failures []*failure
+}
+
+func newTriageQuery() query {
+	weights, err := parseWeights(*weightSpec)
+	if err != nil {
+		die(err)
+	}
+	return &triageQuery{weights: weights, txs: make(map[common.Address]int)}
+}
+
+func (q *triageQuery) add(tx *experiment.Transaction) {
+	addr := target(tx)
+	q.txs[addr]++
+	if !tx.Receipt.Failed {
+		return
+	}
+	f := &failure{tx: tx.Hash, block: tx.Block, target: addr}
+	if len(tx.Frames) > 0 && tx.Frames[0].Value != nil {
+		f.value = ether(tx.Frames[0].Value)
+	}
+	if tx.Receipt.GasPrice != nil {
+		f.gasWasted = ether(new(big.Int).Mul(tx.Receipt.GasPrice, new(big.Int).SetUint64(tx.Receipt.GasUsed)))
+	}
+	if len(tx.Frames) > 0 && tx.Frames[0].Error != "" {
+		f.depth = tx.Frames[0].OriginDepth
+	}
+	q.failures = append(q.failures, f)
+}
+
+func (q *triageQuery) result() ([]string, [][]string) {
+	// Popularity is only known once all transactions were seen
+	for _, f := range q.failures {
+		for name, weight := range q.weights {
+			f.score += weight * severityComponents[name](q, f)
+		}
+	}
+	sort.SliceStable(q.failures, func(i, j int) bool { return q.failures[i].score > q.failures[j].score })
+	if len(q.failures) > *limit {
+		q.failures = q.failures[:*limit]
+	}
+	rows := make([][]string, 0, len(q.failures))
+	for _, f := range q.failures {
+		rows = append(rows, []string{
+			fmt.Sprintf("%.6f", f.score), f.tx.Hex(), fmt.Sprint(f.block), f.target.Hex(),
+			fmt.Sprintf("%.6f", f.value), fmt.Sprintf("%.6f", f.gasWasted), fmt.Sprint(q.txs[f.target]), fmt.Sprint(f.depth),
+		})
+	}
+	return []string{"score", "tx", "block", "contract", "value", "gas wasted", "contract txs", "depth"}, rows
+}
+
+// ether converts an amount of wei into ether.
+func ether(wei *big.Int) float64 {
+	f, _ := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(params.Ether)).Float64()
+	return f
+}
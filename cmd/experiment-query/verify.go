@@ -0,0 +1,112 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/experiment"
+)
+
+// verify cross-checks the transactions recorded for the given canonical blocks
+// against the receipts stored in the chain database, listing every mismatch.
+// The database must not be in use by a running node.
+func verify(path, chaindata string, from, to uint64) ([]string, [][]string, error) {
+	r, err := experiment.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	txs, err := r.TxsByBlockRange(from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	recorded := make(map[common.Hash]*experiment.Transaction, len(txs))
+	for _, tx := range txs {
+		recorded[tx.Hash] = tx
+	}
+	db, err := ethdb.NewLDBDatabase(chaindata, 16, 16)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	var rows [][]string
+	mismatch := func(tx common.Hash, number uint64, field string, have, want interface{}) {
+		rows = append(rows, []string{tx.Hex(), fmt.Sprint(number), field, fmt.Sprint(have), fmt.Sprint(want)})
+	}
+	for number := from; number <= to; number++ {
+		hash := rawdb.ReadCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			break // beyond the chain head
+		}
+		body := rawdb.ReadBody(db, hash, number)
+		receipts := rawdb.ReadReceipts(db, hash, number)
+		if body == nil || len(receipts) != len(body.Transactions) {
+			return nil, nil, fmt.Errorf("block %d: missing body or receipts", number)
+		}
+		for i, tx := range body.Transactions {
+			rec, ok := recorded[tx.Hash()]
+			if !ok {
+				mismatch(tx.Hash(), number, "recorded", false, true)
+				continue
+			}
+			delete(recorded, tx.Hash())
+			if rec.BlockHash != hash {
+				mismatch(tx.Hash(), number, "block hash", rec.BlockHash.Hex(), hash.Hex())
+			}
+			if rec.Receipt == nil {
+				mismatch(tx.Hash(), number, "receipt", "missing", "present")
+				continue
+			}
+			receipt := receipts[i]
+			if rec.Receipt.GasUsed != receipt.GasUsed {
+				mismatch(tx.Hash(), number, "gas used", rec.Receipt.GasUsed, receipt.GasUsed)
+			}
+			if rec.Receipt.CumulativeGasUsed != receipt.CumulativeGasUsed {
+				mismatch(tx.Hash(), number, "cumulative gas used", rec.Receipt.CumulativeGasUsed, receipt.CumulativeGasUsed)
+			}
+			// Receipts before Byzantium store the state root instead of the status
+			if failed := receipt.Status == types.ReceiptStatusFailed; len(receipt.PostState) == 0 && rec.Receipt.Failed != failed {
+				mismatch(tx.Hash(), number, "failed", rec.Receipt.Failed, failed)
+			}
+			// Logs are compared by count if recorded, by presence otherwise
+			if rec.Receipt.Logs != nil {
+				if len(rec.Receipt.Logs) != len(receipt.Logs) {
+					mismatch(tx.Hash(), number, "logs", len(rec.Receipt.Logs), len(receipt.Logs))
+				}
+			} else if hasLogs := len(receipt.Logs) > 0; rec.Receipt.HasLogs != hasLogs {
+				mismatch(tx.Hash(), number, "has logs", rec.Receipt.HasLogs, hasLogs)
+			}
+			if rec.Receipt.ContractAddress != receipt.ContractAddress {
+				mismatch(tx.Hash(), number, "contract address", rec.Receipt.ContractAddress.Hex(), receipt.ContractAddress.Hex())
+			}
+		}
+	}
+	// Anything left was recorded but is not part of the canonical chain
+	for _, tx := range txs {
+		if _, ok := recorded[tx.Hash]; ok {
+			mismatch(tx.Hash, tx.Block, "recorded", true, false)
+		}
+	}
+	return []string{"tx", "block", "field", "recorded", "chain"}, rows, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/experiment"
+)
+
+// Tests that the number of logs of a transaction is verified if its logs were
+// recorded, and only their presence otherwise.
+func TestVerifyLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		block = common.Hash{1}
+		to    = common.Address{0x0b}
+		txs   = []*types.Transaction{
+			types.NewTransaction(0, to, new(big.Int), 21000, big.NewInt(1), nil),
+			types.NewTransaction(1, to, new(big.Int), 21000, big.NewInt(1), nil),
+		}
+		logs = []*types.Log{{Address: to}, {Address: to}}
+	)
+	// Both transactions emitted two logs, only those of the first are recorded
+	c, err := experiment.New(experiment.WithPath(filepath.Join(dir, "records")))
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	for i, tx := range txs {
+		c.Record(map[string]interface{}{"method": "Call", "tx": tx.Hash(), "block": big.NewInt(1), "blockHash": block, "index": 0, "parent": -1, "to": to, "calleeExecuted": true})
+		receipt := map[string]interface{}{"method": "Receipt", "tx": tx.Hash(), "block": big.NewInt(1), "blockHash": block, "failed": false, "gasUsed": uint64(21000), "cumulativeGasUsed": uint64(21000 * (i + 1)), "contractAddress": common.Address{}, "hasLogs": true}
+		if i == 0 {
+			receipt["logs"] = []map[string]interface{}{{"address": to, "topics": []common.Hash{}, "data": hexutil.Bytes{}}}
+		}
+		c.Record(receipt)
+	}
+	c.Checkpoint(1, block)
+	c.Close()
+
+	db, err := ethdb.NewLDBDatabase(filepath.Join(dir, "chaindata"), 16, 16)
+	if err != nil {
+		t.Fatalf("failed to create chain database: %v", err)
+	}
+	receipts := make(types.Receipts, len(txs))
+	for i, tx := range txs {
+		receipts[i] = &types.Receipt{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: uint64(21000 * (i + 1)), GasUsed: 21000, TxHash: tx.Hash(), Logs: logs}
+	}
+	rawdb.WriteCanonicalHash(db, block, 1)
+	rawdb.WriteBody(db, block, 1, &types.Body{Transactions: txs})
+	rawdb.WriteReceipts(db, block, 1, receipts)
+	db.Close()
+
+	_, rows, err := verify(filepath.Join(dir, "records"), filepath.Join(dir, "chaindata"), 1, 1)
+	if err != nil {
+		t.Fatalf("failed to verify records: %v", err)
+	}
+	want := [][]string{{txs[0].Hash().Hex(), "1", "logs", "1", "2"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("mismatches differ: have %v, want %v", rows, want)
+	}
+}
@@ -82,6 +82,17 @@ var (
 		utils.TxPoolAccountQueueFlag,
 		utils.TxPoolGlobalQueueFlag,
 		utils.TxPoolLifetimeFlag,
+		utils.ExperimentStartFlag,
+		utils.ExperimentEndFlag,
+		utils.ExperimentForkFlag,
+		utils.ExperimentDryRunFlag,
+		utils.ExperimentSparseFlag,
+		utils.ExperimentViewsFlag,
+		utils.ExperimentPartitionsFlag,
+		utils.ExperimentAlertsFlag,
+		utils.ExperimentHotspotsFlag,
+		utils.ExperimentHotspotsIntervalFlag,
+		utils.ExperimentDropFieldsFlag,
 		utils.FastSyncFlag,
 		utils.LightModeFlag,
 		utils.SyncModeFlag,
@@ -126,6 +126,22 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.TxPoolLifetimeFlag,
 		},
 	},
+	{
+		Name: "TRANSACTION DATA",
+		Flags: []cli.Flag{
+			utils.ExperimentStartFlag,
+			utils.ExperimentEndFlag,
+			utils.ExperimentForkFlag,
+			utils.ExperimentDryRunFlag,
+			utils.ExperimentSparseFlag,
+			utils.ExperimentViewsFlag,
+			utils.ExperimentPartitionsFlag,
+			utils.ExperimentAlertsFlag,
+			utils.ExperimentHotspotsFlag,
+			utils.ExperimentHotspotsIntervalFlag,
+			utils.ExperimentDropFieldsFlag,
+		},
+	},
 	{
 		Name: "PERFORMANCE TUNING",
 		Flags: []cli.Flag{
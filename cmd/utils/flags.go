@@ -46,6 +46,7 @@ import (
 	"github.com/ethereum/go-ethereum/eth/gasprice"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/ethstats"
+	"github.com/ethereum/go-ethereum/experiment"
 	"github.com/ethereum/go-ethereum/les"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
@@ -291,6 +292,52 @@ var (
 		Usage: "Maximum amount of time non-executable transaction are queued",
 		Value: eth.DefaultConfig.TxPool.Lifetime,
 	}
+	// Transaction data recording settings
+	ExperimentStartFlag = cli.Uint64Flag{
+		Name:  "experiment.start",
+		Usage: "First block to record the transaction data of",
+	}
+	ExperimentEndFlag = cli.Uint64Flag{
+		Name:  "experiment.end",
+		Usage: "Last block to record the transaction data of (0 = no limit)",
+	}
+	ExperimentForkFlag = cli.StringFlag{
+		Name:  "experiment.fork",
+		Usage: "Fork to start recording transaction data at (e.g. byzantium), overriding --experiment.start",
+	}
+	ExperimentDryRunFlag = cli.BoolFlag{
+		Name:  "experiment.dryrun",
+		Usage: "Validate the transaction data records instead of writing them",
+	}
+	ExperimentSparseFlag = cli.BoolFlag{
+		Name:  "experiment.sparse",
+		Usage: "Only record blooms of the failed transactions of every block",
+	}
+	ExperimentViewsFlag = cli.BoolFlag{
+		Name:  "experiment.views",
+		Usage: "Maintain aggregated views of the recorded transactions",
+	}
+	ExperimentPartitionsFlag = cli.BoolFlag{
+		Name:  "experiment.partitions",
+		Usage: "Partition the transaction data records into a file per exception kind",
+	}
+	ExperimentAlertsFlag = cli.StringFlag{
+		Name:  "experiment.alerts",
+		Usage: "JSON file of the rules alerting on transaction failure rates",
+	}
+	ExperimentHotspotsFlag = cli.IntFlag{
+		Name:  "experiment.hotspots",
+		Usage: "Number of contracts and functions tracked as candidate hotspots of failed transactions (0 = disabled)",
+	}
+	ExperimentHotspotsIntervalFlag = cli.DurationFlag{
+		Name:  "experiment.hotspots.interval",
+		Usage: "Time interval to persist the hotspots of failed transactions",
+		Value: experiment.DefaultHotspotsInterval,
+	}
+	ExperimentDropFieldsFlag = cli.StringFlag{
+		Name:  "experiment.dropfields",
+		Usage: "Comma separated fields to drop from the transaction data records (e.g. error,value)",
+	}
 	// Performance tuning settings
 	CacheFlag = cli.IntFlag{
 		Name:  "cache",
@@ -977,6 +1024,42 @@ func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
 	}
 }
 
+func setExperiment(ctx *cli.Context, cfg *experiment.Config) {
+	if ctx.GlobalIsSet(ExperimentStartFlag.Name) {
+		cfg.Start = ctx.GlobalUint64(ExperimentStartFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExperimentEndFlag.Name) {
+		cfg.End = ctx.GlobalUint64(ExperimentEndFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExperimentForkFlag.Name) {
+		cfg.Fork = ctx.GlobalString(ExperimentForkFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExperimentDryRunFlag.Name) {
+		cfg.DryRun = ctx.GlobalBool(ExperimentDryRunFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExperimentSparseFlag.Name) {
+		cfg.Sparse = ctx.GlobalBool(ExperimentSparseFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExperimentViewsFlag.Name) {
+		cfg.Views = ctx.GlobalBool(ExperimentViewsFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExperimentPartitionsFlag.Name) {
+		cfg.Partitions = ctx.GlobalBool(ExperimentPartitionsFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExperimentAlertsFlag.Name) {
+		cfg.Alerts = ctx.GlobalString(ExperimentAlertsFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExperimentHotspotsFlag.Name) {
+		cfg.Hotspots = ctx.GlobalInt(ExperimentHotspotsFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExperimentHotspotsIntervalFlag.Name) {
+		cfg.HotspotsInterval = ctx.GlobalDuration(ExperimentHotspotsIntervalFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExperimentDropFieldsFlag.Name) {
+		cfg.DropFields = strings.Split(ctx.GlobalString(ExperimentDropFieldsFlag.Name), ",")
+	}
+}
+
 func setEthash(ctx *cli.Context, cfg *eth.Config) {
 	if ctx.GlobalIsSet(EthashCacheDirFlag.Name) {
 		cfg.Ethash.CacheDir = ctx.GlobalString(EthashCacheDirFlag.Name)
@@ -1059,6 +1142,7 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	setGPO(ctx, &cfg.GPO)
 	setTxPool(ctx, &cfg.TxPool)
 	setEthash(ctx, cfg)
+	setExperiment(ctx, &cfg.Experiment)
 
 	switch {
 	case ctx.GlobalIsSet(SyncModeFlag.Name):
@@ -1309,6 +1393,12 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		cache.TrieNodeLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
 	}
 	vmcfg := vm.Config{EnablePreimageRecording: ctx.GlobalBool(VMEnableDebugFlag.Name)}
+	var recording experiment.Config
+	setExperiment(ctx, &recording)
+	if _, err := recording.Attach(&vmcfg, config); err != nil {
+		Fatalf("Can't create transaction data collector: %v", err)
+	}
+
 	chain, err = core.NewBlockChain(chainDb, cache, config, engine, vmcfg)
 	if err != nil {
 		Fatalf("Can't create BlockChain: %v", err)
@@ -44,9 +44,6 @@ import (
 	"github.com/ethereum/go-ethereum/trie"
 	"github.com/hashicorp/golang-lru"
 	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
-	"os"
-	"encoding/json"
-	"bufio"
 )
 
 var (
@@ -134,10 +131,6 @@ type BlockChain struct {
 	badBlocks *lru.Cache // Bad block cache
 }
 
-var TxEnc *json.Encoder
-var TxFile *os.File
-var BufTxFile *bufio.Writer
-
 // NewBlockChain returns a fully initialised block chain using information
 // available in the database. It initialises the default Ethereum Validator and
 // Processor.
@@ -198,18 +191,6 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 		}
 	}
 
-	// Log transaction information into file
-	fmt.Println("Write to file tx_data")
-	TxFile, err = os.Create("tx_data")
-	if err != nil {
-		return nil, err
-	}
-	BufTxFile = bufio.NewWriter(TxFile)
-	TxEnc = json.NewEncoder(BufTxFile)
-
-	BufTxFile.WriteString("Recording Tx Information\n")
-	BufTxFile.Flush()
-
 	// Take ownership of this particular state
 	go bc.update()
 	return bc, nil
@@ -673,6 +654,11 @@ func (bc *BlockChain) Stop() {
 
 	bc.wg.Wait()
 
+	// No more records are written once the running insertions are done
+	if bc.vmConfig.Recorder != nil {
+		closeTxData(bc.vmConfig.Recorder)
+	}
+
 	// Ensure the state of a recent block is also stored to disk before exiting.
 	// We're writing three different states to catch different restart scenarios:
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
@@ -1166,12 +1152,18 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 		// Process block using the parent state as reference point.
 		receipts, logs, usedGas, err := bc.processor.Process(block, state, bc.vmConfig)
 		if err != nil {
+			if bc.vmConfig.Recorder != nil {
+				recordBlockStatus(bc.vmConfig.Recorder, types.Blocks{block}, true)
+			}
 			bc.reportBlock(block, receipts, err)
 			return i, events, coalescedLogs, err
 		}
 		// Validate the state using the default validator
 		err = bc.Validator().ValidateState(block, parent, state, receipts, usedGas)
 		if err != nil {
+			if bc.vmConfig.Recorder != nil {
+				recordBlockStatus(bc.vmConfig.Recorder, types.Blocks{block}, true)
+			}
 			bc.reportBlock(block, receipts, err)
 			return i, events, coalescedLogs, err
 		}
@@ -1201,6 +1193,13 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 
 			blockInsertTimer.UpdateSince(bstart)
 			events = append(events, ChainSideEvent{block})
+
+			if bc.vmConfig.Recorder != nil {
+				recordBlockStatus(bc.vmConfig.Recorder, types.Blocks{block}, true)
+			}
+		}
+		if bc.vmConfig.Recorder != nil {
+			checkpointTxData(bc.vmConfig.Recorder, block)
 		}
 		stats.processed++
 		stats.usedGas += usedGas
@@ -1347,6 +1346,11 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 	} else {
 		log.Error("Impossible reorg, please file an issue", "oldnum", oldBlock.Number(), "oldhash", oldBlock.Hash(), "newnum", newBlock.Number(), "newhash", newBlock.Hash())
 	}
+	// Mark the records of the dropped blocks as orphaned and the added ones as not
+	if bc.vmConfig.Recorder != nil {
+		recordBlockStatus(bc.vmConfig.Recorder, oldChain, true)
+		recordBlockStatus(bc.vmConfig.Recorder, newChain, false)
+	}
 	// Insert the new chain, taking care of the proper incremental order
 	var addedTxs types.Transactions
 	for i := len(newChain) - 1; i >= 0; i-- {
@@ -1410,7 +1414,6 @@ func (bc *BlockChain) update() {
 		case <-futureTimer.C:
 			bc.procFutureBlocks()
 		case <-bc.quit:
-			TxFile.Close()
 			return
 		}
 	}
@@ -17,6 +17,8 @@
 package core
 
 import (
+	"math/big"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/misc"
@@ -60,9 +62,17 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 		header   = block.Header()
 		allLogs  []*types.Log
 		gp       = new(GasPool).AddGas(block.GasLimit())
+		summary  *blockSummary
 	)
+	if cfg.Recorder != nil {
+		summary = &blockSummary{Recorder: cfg.Recorder, failures: make(map[string]int)}
+		cfg.Recorder = &tagRecorder{summary, map[string]interface{}{"block": block.Number(), "blockHash": block.Hash()}}
+	}
 	// Mutate the the block and state according to any hard-fork specs
 	if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		if cfg.Recorder != nil {
+			recordDAORefund(cfg.Recorder, block, statedb)
+		}
 		misc.ApplyDAOHardFork(statedb)
 	}
 	// Iterate over and process the individual transactions
@@ -76,7 +86,15 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 		allLogs = append(allLogs, receipt.Logs...)
 	}
 	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards)
+	var rewarded []*big.Int
+	if cfg.Recorder != nil {
+		rewarded = rewardBalances(block, statedb)
+	}
 	p.engine.Finalize(p.bc, header, statedb, block.Transactions(), block.Uncles(), receipts)
+	if cfg.Recorder != nil {
+		recordBlockRewards(cfg.Recorder, block, statedb, rewarded)
+		recordBlockSummary(cfg.Recorder, block, *usedGas, summary.failures)
+	}
 
 	return receipts, allLogs, *usedGas, nil
 }
@@ -88,24 +106,66 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, cfg vm.Config) (*types.Receipt, uint64, error) {
 	msg, err := tx.AsMessage(types.MakeSigner(config, header.Number))
 	if err != nil {
+		if cfg.Recorder != nil && cfg.RecordConfig.Rejections {
+			recordRejection(&tagRecorder{cfg.Recorder, map[string]interface{}{"tx": tx.Hash()}}, tx, err)
+		}
 		return nil, 0, err
 	}
+	return ApplyTransactionMessage(config, bc, author, gp, statedb, header, tx, msg, usedGas, cfg)
+}
 
-	//if err := TxEnc.Encode(map[string]interface{}{"from": msg.From(), "to": msg.To(), "value": msg.Value(), "data": hexutil.Bytes(msg.Data())}); err != nil {
-	//	return nil, 0, err
-	//}
-	//BufTxFile.Flush()
-
+// ApplyTransactionMessage is like ApplyTransaction, but executes the transaction
+// as the given message instead of the one it is signed as, e.g. with its gas
+// limit or gas price replaced to replay it counterfactually. The records still
+// carry the hash of the transaction.
+func ApplyTransactionMessage(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, msg types.Message, usedGas *uint64, cfg vm.Config) (*types.Receipt, uint64, error) {
+	// Tag everything recorded while executing the transaction with its hash
+	if cfg.Recorder != nil {
+		cfg.Recorder = &tagRecorder{cfg.Recorder, map[string]interface{}{"tx": tx.Hash()}}
+	}
+	// Execute the transaction under the alternative configuration first, if its
+	// outcomes are compared, tracking the outcome of the actual execution
+	var (
+		alt     *outcome
+		tracker *outcomeRecorder
+	)
+	if cfg.Recorder != nil && cfg.RecordConfig.Differential != nil {
+		alt = applyAlternative(cfg.RecordConfig.Differential, bc, author, gp, statedb, header, msg)
+		tracker = &outcomeRecorder{Recorder: cfg.Recorder}
+		cfg.Recorder = tracker
+	}
 	// Create a new context to be used in the EVM environment
 	context := NewEVMContext(msg, header, bc, author)
 	// Create a new environment which holds all relevant information
 	// about the transaction and calling mechanisms.
-	vmenv := vm.NewEVM(context, statedb, config, cfg, TxEnc, BufTxFile)
+	vmenv := vm.NewEVM(context, statedb, config, cfg)
+
+	// Gather the accounts involved in the transaction if their state is recorded
+	recordState := cfg.Recorder != nil && cfg.RecordConfig.AccountState
+
+	var involved []common.Address
+	if recordState {
+		involved = append(involved, msg.From())
+		if msg.To() != nil {
+			involved = append(involved, *msg.To())
+		} else {
+			involved = append(involved, crypto.CreateAddress(msg.From(), tx.Nonce()))
+		}
+		recordAccountState(cfg.Recorder, "pre", tx.Hash(), statedb, involved)
+	}
 	// Apply the transaction to the current state (included in the env)
 	_, gas, failed, err := ApplyMessage(vmenv, msg, gp)
 	if err != nil {
+		if cfg.Recorder != nil && cfg.RecordConfig.Rejections {
+			recordRejection(cfg.Recorder, tx, err)
+		}
 		return nil, 0, err
 	}
+	vmenv.RecordGasProfile()
+	vmenv.RecordAccessList()
+	if recordState {
+		recordAccountState(cfg.Recorder, "post", tx.Hash(), statedb, involved)
+	}
 	// Update the state with pending changes
 	var root []byte
 	if config.IsByzantium(header.Number) {
@@ -128,5 +188,11 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	receipt.Logs = statedb.GetLogs(tx.Hash())
 	receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
 
+	if cfg.Recorder != nil {
+		recordReceipt(cfg.Recorder, tx, msg, receipt, statedb, vmenv.RecordTruncated(), cfg.RecordConfig.Logs)
+	}
+	if alt != nil {
+		recordDivergence(cfg.Recorder, tx, &outcome{failed: failed, gasUsed: gas, err: tracker.err}, alt)
+	}
 	return receipt, gas, err
 }
@@ -233,6 +233,7 @@ func (st *StateTransition) refundGas() {
 	if refund > st.state.GetRefund() {
 		refund = st.state.GetRefund()
 	}
+	st.evm.RecordRefund(st.state.GetRefund(), st.gasUsed()/2)
 	st.gas += refund
 
 	// Return ETH for remaining gas, exchanged at the original rate.
@@ -0,0 +1,308 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"io"
+	"math/big"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// writeTxData persists a single record. If the recorder fails the node is
+// interrupted, same as the EVM does.
+func writeTxData(recorder vm.Recorder, record map[string]interface{}) {
+	if err := recorder.Record(record); err != nil {
+		log.Error("Unable to write to tx_data", "err", err)
+		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	}
+}
+
+// tagRecorder adds a set of fields to every record written through it, unless
+// the record sets them itself (e.g. tagging records with the block and the
+// transaction they were produced in).
+type tagRecorder struct {
+	vm.Recorder
+	tags map[string]interface{}
+}
+
+func (r *tagRecorder) Record(record map[string]interface{}) error {
+	for key, value := range r.tags {
+		if _, ok := record[key]; !ok {
+			record[key] = value
+		}
+	}
+	return r.Recorder.Record(record)
+}
+
+// blockSummary counts the failed transactions of a block by the kind of error
+// of their outermost frame (its message without the details of the faulting
+// operation), as their records are written through it. Transactions are
+// counted from their receipts, so those failing before any frame executed
+// (e.g. a creation colliding with an existing contract) are counted as well.
+type blockSummary struct {
+	vm.Recorder
+	failures map[string]int
+	err      string // error of the outermost frame of the current transaction
+}
+
+func (s *blockSummary) Record(record map[string]interface{}) error {
+	if err, ok := record["error"].(string); ok && record["index"] == 0 {
+		s.err = err
+	}
+	if record["method"] == "Receipt" {
+		if record["failed"] == true {
+			kind := "other"
+			if err := vm.ParseError(s.err); err != nil {
+				kind = err.Error()
+			}
+			s.failures[kind]++
+		}
+		s.err = ""
+	}
+	return s.Recorder.Record(record)
+}
+
+// recordBlockSummary writes the totals of a processed block, so that time
+// series can be queried without going through every transaction.
+func recordBlockSummary(recorder vm.Recorder, block *types.Block, usedGas uint64, failures map[string]int) {
+	failed := 0
+	for _, count := range failures {
+		failed += count
+	}
+	writeTxData(recorder, map[string]interface{}{
+		"method":    "BlockSummary",
+		"block":     block.Number(),
+		"blockHash": block.Hash(),
+		"miner":     block.Coinbase(),
+		"timestamp": block.Time(),
+		"gasUsed":   usedGas,
+		"txs":       len(block.Transactions()),
+		"failed":    failed,
+		"failures":  failures,
+	})
+}
+
+// recordBlockStatus marks the records of the given blocks as orphaned (the
+// blocks left or never joined the canonical chain) or not (they joined it).
+func recordBlockStatus(recorder vm.Recorder, blocks types.Blocks, orphaned bool) {
+	for _, block := range blocks {
+		writeTxData(recorder, map[string]interface{}{"method": "BlockStatus", "block": block.Number(), "blockHash": block.Hash(), "orphaned": orphaned})
+	}
+}
+
+// checkpointTxData notifies the recorder, if it tracks its progress, that all
+// records of the block have been written.
+func checkpointTxData(recorder vm.Recorder, block *types.Block) {
+	checkpointer, ok := recorder.(vm.Checkpointer)
+	if !ok {
+		return
+	}
+	if err := checkpointer.Checkpoint(block.NumberU64(), block.Hash()); err != nil {
+		log.Error("Unable to checkpoint tx_data", "number", block.Number(), "hash", block.Hash(), "err", err)
+		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	}
+}
+
+// closeTxData closes the recorder, if it needs to, once no more blocks are
+// being processed.
+func closeTxData(recorder vm.Recorder) {
+	closer, ok := recorder.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		log.Error("Unable to close tx_data", "err", err)
+	}
+}
+
+// recordAccountState writes a snapshot of the nonce, balance and code hash of
+// the given accounts, tagged with the transaction hash and the execution stage
+// ("pre" or "post").
+func recordAccountState(recorder vm.Recorder, stage string, txHash common.Hash, statedb *state.StateDB, addrs []common.Address) {
+	accounts := make([]map[string]interface{}, 0, len(addrs))
+	for _, addr := range addrs {
+		accounts = append(accounts, map[string]interface{}{
+			"address":  addr,
+			"nonce":    statedb.GetNonce(addr),
+			"balance":  statedb.GetBalance(addr),
+			"codeHash": statedb.GetCodeHash(addr),
+		})
+	}
+	writeTxData(recorder, map[string]interface{}{"method": "AccountState", "stage": stage, "tx": txHash, "accounts": accounts})
+}
+
+// recordReceipt writes the outcome of a transaction as derived from its receipt,
+// together with the balances of its sender and recipient (or created contract)
+// after its execution and whether its recorded call frames were truncated. The
+// logs of the receipt are included if requested.
+func recordReceipt(recorder vm.Recorder, tx *types.Transaction, msg types.Message, receipt *types.Receipt, statedb *state.StateDB, truncated, logs bool) {
+	to := receipt.ContractAddress
+	if msg.To() != nil {
+		to = *msg.To()
+	}
+	record := map[string]interface{}{
+		"method":            "Receipt",
+		"tx":                tx.Hash(),
+		"failed":            receipt.Status == types.ReceiptStatusFailed,
+		"gasUsed":           receipt.GasUsed,
+		"cumulativeGasUsed": receipt.CumulativeGasUsed,
+		"gasPrice":          msg.GasPrice(),
+		"contractAddress":   receipt.ContractAddress,
+		"hasLogs":           receipt.Bloom != types.Bloom{},
+		"fromBalance":       statedb.GetBalance(msg.From()),
+		"toBalance":         statedb.GetBalance(to),
+		"truncated":         truncated,
+	}
+	if logs {
+		entries := make([]map[string]interface{}, len(receipt.Logs))
+		for i, entry := range receipt.Logs {
+			entries[i] = map[string]interface{}{"address": entry.Address, "topics": entry.Topics, "data": hexutil.Bytes(entry.Data)}
+		}
+		record["logs"] = entries
+	}
+	writeTxData(recorder, record)
+}
+
+// recordRejection writes the reason a transaction was rejected before being
+// executed (e.g. nonce mismatch, intrinsic gas too low), which makes the block
+// including it invalid.
+func recordRejection(recorder vm.Recorder, tx *types.Transaction, err error) {
+	writeTxData(recorder, map[string]interface{}{"method": "Rejected", "tx": tx.Hash(), "error": err.Error()})
+}
+
+// outcome is how the execution of a transaction ended.
+type outcome struct {
+	failed   bool
+	gasUsed  uint64
+	err      string // error of the outermost frame, empty if unknown
+	rejected string // reason the transaction was rejected before its execution
+}
+
+// outcomeRecorder tracks the error of the outermost frame of a transaction from
+// the records written through it, forwarding them if it wraps a recorder.
+type outcomeRecorder struct {
+	vm.Recorder
+	err string
+}
+
+func (r *outcomeRecorder) Record(record map[string]interface{}) error {
+	if err, ok := record["error"].(string); ok && record["index"] == 0 {
+		r.err = err
+	}
+	if r.Recorder == nil {
+		return nil
+	}
+	return r.Recorder.Record(record)
+}
+
+// applyAlternative executes a transaction under an alternative chain
+// configuration on a copy of the state, returning its outcome.
+func applyAlternative(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, msg types.Message) *outcome {
+	var (
+		recorder = new(outcomeRecorder)
+		context  = NewEVMContext(msg, header, bc, author)
+		vmenv    = vm.NewEVM(context, statedb.Copy(), config, vm.Config{Recorder: recorder})
+	)
+	_, gas, failed, err := ApplyMessage(vmenv, msg, new(GasPool).AddGas(gp.Gas()))
+	if err != nil {
+		return &outcome{rejected: err.Error()}
+	}
+	return &outcome{failed: failed, gasUsed: gas, err: recorder.err}
+}
+
+// recordDivergence writes the outcomes of a transaction executed under the
+// actual and the alternative chain configuration, if they differ.
+func recordDivergence(recorder vm.Recorder, tx *types.Transaction, have, alt *outcome) {
+	if *have == *alt {
+		return
+	}
+	record := map[string]interface{}{
+		"method":     "Divergence",
+		"tx":         tx.Hash(),
+		"failed":     have.failed,
+		"gasUsed":    have.gasUsed,
+		"error":      have.err,
+		"altFailed":  alt.failed,
+		"altGasUsed": alt.gasUsed,
+		"altError":   alt.err,
+	}
+	if alt.rejected != "" {
+		record["altRejected"] = alt.rejected
+	}
+	writeTxData(recorder, record)
+}
+
+// recordSystemOp writes a block level state change that is not caused by any
+// transaction (irregular hard-fork changes, block rewards).
+func recordSystemOp(recorder vm.Recorder, block *types.Block, kind string, from *common.Address, to common.Address, value *big.Int) {
+	writeTxData(recorder, map[string]interface{}{"method": "SystemOp", "type": kind, "block": block.Number(), "blockHash": block.Hash(), "from": from, "to": to, "value": value})
+}
+
+// recordDAORefund records every balance drained into the refund contract by
+// the DAO hard-fork. It must be called before the fork is applied.
+func recordDAORefund(recorder vm.Recorder, block *types.Block, statedb *state.StateDB) {
+	for _, addr := range params.DAODrainList() {
+		if balance := statedb.GetBalance(addr); balance.Sign() > 0 {
+			from := addr
+			recordSystemOp(recorder, block, "DAORefund", &from, params.DAORefundContract, balance)
+		}
+	}
+}
+
+// rewardedAccounts returns the accounts that may receive a reward when the
+// block is finalized (miner and uncle miners), without duplicates.
+func rewardedAccounts(block *types.Block) []common.Address {
+	accounts := []common.Address{block.Coinbase()}
+	for _, uncle := range block.Uncles() {
+		seen := false
+		for _, addr := range accounts {
+			seen = seen || addr == uncle.Coinbase
+		}
+		if !seen {
+			accounts = append(accounts, uncle.Coinbase)
+		}
+	}
+	return accounts
+}
+
+// rewardBalances returns the current balances of all the accounts that may
+// receive a reward when the block is finalized.
+func rewardBalances(block *types.Block, statedb *state.StateDB) []*big.Int {
+	var balances []*big.Int
+	for _, addr := range rewardedAccounts(block) {
+		balances = append(balances, new(big.Int).Set(statedb.GetBalance(addr)))
+	}
+	return balances
+}
+
+// recordBlockRewards records the balance increases of the rewarded accounts
+// caused by finalizing the block, given their balances from before.
+func recordBlockRewards(recorder vm.Recorder, block *types.Block, statedb *state.StateDB, before []*big.Int) {
+	for i, addr := range rewardedAccounts(block) {
+		if reward := new(big.Int).Sub(statedb.GetBalance(addr), before[i]); reward.Sign() > 0 {
+			recordSystemOp(recorder, block, "BlockReward", nil, addr, reward)
+		}
+	}
+}
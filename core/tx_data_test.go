@@ -0,0 +1,513 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// sliceRecorder is a vm.Recorder keeping all records in memory, round-tripped
+// through JSON the same way they are persisted, as well as the checkpointed
+// block numbers.
+type sliceRecorder struct {
+	records     []map[string]interface{}
+	checkpoints []uint64
+	closed      bool
+}
+
+func (r *sliceRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func (r *sliceRecorder) Checkpoint(number uint64, hash common.Hash) error {
+	r.checkpoints = append(r.checkpoints, number)
+	return nil
+}
+
+func (r *sliceRecorder) Record(record map[string]interface{}) error {
+	blob, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(blob, &decoded); err != nil {
+		return err
+	}
+	r.records = append(r.records, decoded)
+	return nil
+}
+
+// byMethod returns the records with the given method.
+func (r *sliceRecorder) byMethod(method string) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, record := range r.records {
+		if record["method"] == method {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// recordChain inserts a chain of the given length generated by gen into a new
+// blockchain, returning everything that was recorded while processing it.
+func recordChain(t *testing.T, gspec *Genesis, n int, gen func(int, *BlockGen)) *sliceRecorder {
+	var (
+		db       = ethdb.NewMemDatabase()
+		genesis  = gspec.MustCommit(db)
+		recorder = new(sliceRecorder)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{Recorder: recorder})
+	defer blockchain.Stop()
+
+	chain, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, n, gen)
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	return recorder
+}
+
+func TestRecordReceipt(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		// this code generates a log
+		code   = common.Hex2Bytes("60606040525b7f24ec1d3ff24c2f6ff210738839dbc339cd45a5294d85c79361016243157aae7b60405180905060405180910390a15b600a8060416000396000f360606040526008565b00")
+		gspec  = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{addr: {Balance: big.NewInt(10000000000000)}}}
+		signer = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	recorder := recordChain(t, gspec, 1, func(i int, gen *BlockGen) {
+		tx, err := types.SignTx(types.NewContractCreation(gen.TxNonce(addr), new(big.Int), 1000000, big.NewInt(2), code), signer, key)
+		if err != nil {
+			t.Fatalf("failed to create tx: %v", err)
+		}
+		gen.AddTx(tx)
+	})
+	receipts := recorder.byMethod("Receipt")
+	if len(receipts) != 1 {
+		t.Fatalf("receipt record count mismatch: have %d, want 1", len(receipts))
+	}
+	receipt := receipts[0]
+
+	for _, record := range recorder.records {
+		if record["method"] != "SystemOp" && record["method"] != "BlockSummary" && record["tx"] != receipt["tx"] {
+			t.Errorf("record not tagged with the transaction: %v", record)
+		}
+	}
+
+	contract := crypto.CreateAddress(addr, 0)
+	if have := common.HexToAddress(receipt["contractAddress"].(string)); have != contract {
+		t.Errorf("contract address mismatch: have %x, want %x", have, contract)
+	}
+	if receipt["failed"] != false || receipt["hasLogs"] != true || receipt["truncated"] != false {
+		t.Errorf("outcome mismatch: failed %v, hasLogs %v, truncated %v", receipt["failed"], receipt["hasLogs"], receipt["truncated"])
+	}
+	if receipt["gasPrice"] != float64(2) {
+		t.Errorf("gas price mismatch: have %v, want 2", receipt["gasPrice"])
+	}
+	gasUsed := receipt["gasUsed"].(float64)
+	if gasUsed == 0 || receipt["cumulativeGasUsed"] != gasUsed {
+		t.Errorf("gas mismatch: used %v, cumulative %v", gasUsed, receipt["cumulativeGasUsed"])
+	}
+	if have, want := receipt["fromBalance"], float64(10000000000000-2*gasUsed); have != want {
+		t.Errorf("sender balance mismatch: have %v, want %v", have, want)
+	}
+	if have := receipt["toBalance"]; have != float64(0) {
+		t.Errorf("contract balance mismatch: have %v, want 0", have)
+	}
+	if logs, ok := receipt["logs"]; ok {
+		t.Errorf("logs recorded without being enabled: %v", logs)
+	}
+}
+
+// Tests that the logs of every transaction are recorded along with its receipt
+// if enabled.
+func TestRecordLogs(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		// this code generates a log
+		code     = common.Hex2Bytes("60606040525b7f24ec1d3ff24c2f6ff210738839dbc339cd45a5294d85c79361016243157aae7b60405180905060405180910390a15b600a8060416000396000f360606040526008565b00")
+		db       = ethdb.NewMemDatabase()
+		gspec    = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{addr: {Balance: big.NewInt(10000000000000)}}}
+		genesis  = gspec.MustCommit(db)
+		signer   = types.NewEIP155Signer(gspec.Config.ChainID)
+		recorder = new(sliceRecorder)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{Recorder: recorder, RecordConfig: vm.RecordConfig{Logs: true}})
+	defer blockchain.Stop()
+
+	chain, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 1, func(i int, gen *BlockGen) {
+		tx, _ := types.SignTx(types.NewContractCreation(gen.TxNonce(addr), new(big.Int), 1000000, big.NewInt(2), code), signer, key)
+		gen.AddTx(tx)
+	})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	logs, _ := recorder.byMethod("Receipt")[0]["logs"].([]interface{})
+	if len(logs) != 1 {
+		t.Fatalf("log count mismatch: have %d, want 1", len(logs))
+	}
+	log := logs[0].(map[string]interface{})
+	if have, want := common.HexToAddress(log["address"].(string)), crypto.CreateAddress(addr, 0); have != want {
+		t.Errorf("log address mismatch: have %x, want %x", have, want)
+	}
+	topics := log["topics"].([]interface{})
+	if want := "0x24ec1d3ff24c2f6ff210738839dbc339cd45a5294d85c79361016243157aae7b"; len(topics) != 1 || topics[0] != want {
+		t.Errorf("log topics mismatch: have %v, want [%s]", topics, want)
+	}
+	if log["data"] != "0x" {
+		t.Errorf("log data mismatch: have %v, want 0x", log["data"])
+	}
+}
+
+// Tests that every processed block is summarized with the failures of its
+// transactions counted by error.
+func TestRecordBlockSummary(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		miner  = common.HexToAddress("0x0a")
+		// the contract the third transaction creates exists already
+		gspec = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{
+			addr:                          {Balance: big.NewInt(10000000000000)},
+			crypto.CreateAddress(addr, 2): {Balance: new(big.Int), Nonce: 1},
+		}}
+		signer = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	recorder := recordChain(t, gspec, 1, func(i int, gen *BlockGen) {
+		gen.SetCoinbase(miner)
+		transfer, _ := types.SignTx(types.NewTransaction(gen.TxNonce(addr), common.HexToAddress("0x0b"), big.NewInt(1), 21000, big.NewInt(1), nil), signer, key)
+		gen.AddTx(transfer)
+		invalid, _ := types.SignTx(types.NewContractCreation(gen.TxNonce(addr), new(big.Int), 100000, big.NewInt(1), []byte{0xfe}), signer, key)
+		gen.AddTx(invalid)
+		collision, _ := types.SignTx(types.NewContractCreation(gen.TxNonce(addr), new(big.Int), 100000, big.NewInt(1), nil), signer, key)
+		gen.AddTx(collision)
+	})
+	summaries := recorder.byMethod("BlockSummary")
+	if len(summaries) != 1 {
+		t.Fatalf("summary record count mismatch: have %d, want 1", len(summaries))
+	}
+	summary := summaries[0]
+
+	var gasUsed float64
+	for _, receipt := range recorder.byMethod("Receipt") {
+		gasUsed += receipt["gasUsed"].(float64)
+	}
+	if summary["block"] != float64(1) || common.HexToAddress(summary["miner"].(string)) != miner {
+		t.Errorf("block mismatch: number %v, miner %v", summary["block"], summary["miner"])
+	}
+	if summary["txs"] != float64(3) || summary["failed"] != float64(2) || summary["gasUsed"] != gasUsed {
+		t.Errorf("totals mismatch: txs %v, failed %v, gas used %v (want %v)", summary["txs"], summary["failed"], summary["gasUsed"], gasUsed)
+	}
+	// Failures are counted by kind, the creation colliding with an existing
+	// contract along with the rest although none of its frames executed
+	if failures := summary["failures"].(map[string]interface{}); len(failures) != 2 || failures["invalid opcode"] != float64(1) || failures["contract address collision"] != float64(1) {
+		t.Errorf("failures mismatch: %v", failures)
+	}
+}
+
+// Tests that transactions rejected before their execution are recorded if
+// enabled, and that the records of the invalid block are marked as orphaned.
+func TestRecordRejection(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr     = crypto.PubkeyToAddress(key.PublicKey)
+		db       = ethdb.NewMemDatabase()
+		gspec    = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{addr: {Balance: big.NewInt(10000000000000)}}}
+		genesis  = gspec.MustCommit(db)
+		signer   = types.NewEIP155Signer(gspec.Config.ChainID)
+		recorder = new(sliceRecorder)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{Recorder: recorder, RecordConfig: vm.RecordConfig{Rejections: true}})
+	defer blockchain.Stop()
+
+	// Include a transaction with a nonce from the future into an otherwise valid block
+	chain, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 1, func(int, *BlockGen) {})
+	tx, _ := types.SignTx(types.NewTransaction(5, common.Address{}, new(big.Int), 21000, big.NewInt(1), nil), signer, key)
+	block := types.NewBlock(chain[0].Header(), types.Transactions{tx}, nil, nil)
+
+	if _, err := blockchain.InsertChain(types.Blocks{block}); err != ErrNonceTooHigh {
+		t.Fatalf("insertion error mismatch: have %v, want %v", err, ErrNonceTooHigh)
+	}
+	rejections := recorder.byMethod("Rejected")
+	if len(rejections) != 1 || rejections[0]["tx"] != tx.Hash().Hex() || rejections[0]["error"] != ErrNonceTooHigh.Error() {
+		t.Fatalf("rejection mismatch: %v", rejections)
+	}
+	statuses := recorder.byMethod("BlockStatus")
+	if len(statuses) != 1 || statuses[0]["blockHash"] != block.Hash().Hex() || statuses[0]["orphaned"] != true {
+		t.Errorf("block status mismatch: %v", statuses)
+	}
+}
+
+// Tests that the balances drained by the DAO hard-fork and the block rewards are
+// recorded as system operations of their block.
+func TestRecordSystemOps(t *testing.T) {
+	var (
+		config = *params.TestChainConfig
+		drain  = params.DAODrainList()[0]
+		miner  = common.HexToAddress("0x0a")
+	)
+	config.DAOForkBlock, config.DAOForkSupport = big.NewInt(1), true
+
+	gspec := &Genesis{Config: &config, Alloc: GenesisAlloc{drain: {Balance: big.NewInt(1000)}}}
+	recorder := recordChain(t, gspec, 1, func(i int, gen *BlockGen) {
+		gen.SetCoinbase(miner)
+	})
+	ops := recorder.byMethod("SystemOp")
+	if len(ops) != 2 {
+		t.Fatalf("system operation count mismatch: have %d, want 2", len(ops))
+	}
+	refund, reward := ops[0], ops[1]
+	if refund["type"] != "DAORefund" || refund["block"] != float64(1) || common.HexToAddress(refund["from"].(string)) != drain || common.HexToAddress(refund["to"].(string)) != params.DAORefundContract || refund["value"] != float64(1000) {
+		t.Errorf("DAO refund mismatch: %v", refund)
+	}
+	if reward["type"] != "BlockReward" || reward["from"] != nil || common.HexToAddress(reward["to"].(string)) != miner || reward["value"] != float64(3e18) {
+		t.Errorf("block reward mismatch: %v", reward)
+	}
+	if refund["blockHash"] != reward["blockHash"] || refund["tx"] != nil || reward["tx"] != nil {
+		t.Errorf("system operations not tagged with their block only: %v, %v", refund, reward)
+	}
+}
+
+// Tests that a checkpointing recorder is notified of every inserted block.
+func TestRecordCheckpoints(t *testing.T) {
+	recorder := recordChain(t, &Genesis{Config: params.TestChainConfig}, 3, func(int, *BlockGen) {})
+	if want := []uint64{1, 2, 3}; fmt.Sprint(recorder.checkpoints) != fmt.Sprint(want) {
+		t.Errorf("checkpoint mismatch: have %v, want %v", recorder.checkpoints, want)
+	}
+	if !recorder.closed {
+		t.Errorf("recorder not closed with the chain")
+	}
+}
+
+// Tests that records are tagged with their block and that the records of
+// blocks dropped from or added to the canonical chain by a reorg are marked.
+func TestRecordReorg(t *testing.T) {
+	var (
+		db       = ethdb.NewMemDatabase()
+		gspec    = &Genesis{Config: params.TestChainConfig}
+		genesis  = gspec.MustCommit(db)
+		recorder = new(sliceRecorder)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{Recorder: recorder})
+	defer blockchain.Stop()
+
+	chain, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 2, func(int, *BlockGen) {})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// Insert a heavier fork, the first block of which is not enough to reorg
+	fork, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 2, func(i int, gen *BlockGen) {
+		gen.SetCoinbase(common.Address{1})
+		gen.OffsetTime(-9)
+	})
+	if _, err := blockchain.InsertChain(fork); err != nil {
+		t.Fatalf("failed to insert fork: %v", err)
+	}
+	for _, record := range recorder.records {
+		if record["blockHash"] == nil {
+			t.Errorf("record without block: %v", record)
+		}
+	}
+	want := []string{
+		fmt.Sprint(fork[0].Hash().Hex(), true),
+		fmt.Sprint(chain[1].Hash().Hex(), true),
+		fmt.Sprint(chain[0].Hash().Hex(), true),
+		fmt.Sprint(fork[1].Hash().Hex(), false),
+		fmt.Sprint(fork[0].Hash().Hex(), false),
+	}
+	var have []string
+	for _, record := range recorder.byMethod("BlockStatus") {
+		have = append(have, fmt.Sprint(record["blockHash"], record["orphaned"]))
+	}
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("block status mismatch:\nhave %v\nwant %v", have, want)
+	}
+}
+
+// Tests that transactions are executed under the alternative chain configuration
+// too if enabled, recording the outcomes only where they diverge.
+func TestRecordDivergence(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr     = crypto.PubkeyToAddress(key.PublicKey)
+		reverter = common.HexToAddress("0x0a")
+		db       = ethdb.NewMemDatabase()
+		gspec    = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{
+			addr:     {Balance: big.NewInt(10000000000000)},
+			reverter: {Balance: new(big.Int), Code: common.FromHex("0x60006000fd")}, // PUSH1 0 PUSH1 0 REVERT
+		}}
+		genesis  = gspec.MustCommit(db)
+		signer   = types.NewEIP155Signer(gspec.Config.ChainID)
+		recorder = new(sliceRecorder)
+	)
+	// Compare against the rules before Byzantium, lacking the REVERT opcode
+	alt := *gspec.Config
+	alt.ByzantiumBlock = nil
+
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{Recorder: recorder, RecordConfig: vm.RecordConfig{Differential: &alt}})
+	defer blockchain.Stop()
+
+	chain, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 1, func(i int, gen *BlockGen) {
+		transfer, _ := types.SignTx(types.NewTransaction(gen.TxNonce(addr), common.HexToAddress("0x0b"), big.NewInt(1), 21000, big.NewInt(1), nil), signer, key)
+		gen.AddTx(transfer)
+		call, _ := types.SignTx(types.NewTransaction(gen.TxNonce(addr), reverter, new(big.Int), 50000, big.NewInt(1), nil), signer, key)
+		gen.AddTx(call)
+	})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	divergences := recorder.byMethod("Divergence")
+	if len(divergences) != 1 {
+		t.Fatalf("divergence record count mismatch: have %d, want 1", len(divergences))
+	}
+	divergence := divergences[0]
+	if divergence["tx"] != chain[0].Transactions()[1].Hash().Hex() {
+		t.Errorf("divergence of the wrong transaction: %v", divergence["tx"])
+	}
+	if divergence["failed"] != true || divergence["error"] != "evm: execution reverted" || divergence["gasUsed"] != float64(21006) {
+		t.Errorf("actual outcome mismatch: %v", divergence)
+	}
+	if divergence["altFailed"] != true || divergence["altError"] != "invalid opcode 0xfd" || divergence["altGasUsed"] != float64(50000) {
+		t.Errorf("alternative outcome mismatch: %v", divergence)
+	}
+}
+
+func TestRecordRefund(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr    = crypto.PubkeyToAddress(key.PublicKey)
+		clearer = common.HexToAddress("0x0a")
+		db      = ethdb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{
+			addr: {Balance: big.NewInt(10000000000000)},
+			clearer: {
+				Balance: new(big.Int),
+				Code:    common.FromHex("0x600060005500"), // PUSH1 0 PUSH1 0 SSTORE STOP
+				Storage: map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(1))},
+			},
+		}}
+		genesis  = gspec.MustCommit(db)
+		signer   = types.NewEIP155Signer(gspec.Config.ChainID)
+		recorder = new(sliceRecorder)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{Recorder: recorder, RecordConfig: vm.RecordConfig{Refunds: true}})
+	defer blockchain.Stop()
+
+	chain, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 1, func(i int, gen *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(addr), clearer, new(big.Int), 50000, big.NewInt(1), nil), signer, key)
+		gen.AddTx(tx)
+	})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// Clearing the slot refunds 15000 gas, capped to half of the 26006 gas used
+	refunds := recorder.byMethod("Refund")
+	if len(refunds) != 1 {
+		t.Fatalf("refund record count mismatch: have %d, want 1", len(refunds))
+	}
+	if refund := refunds[0]; refund["counter"] != float64(15000) || refund["limit"] != float64(13003) || refund["applied"] != float64(13003) || refund["capped"] != true {
+		t.Errorf("refund mismatch: %v", refund)
+	}
+	if frame := recorder.byMethod("Call")[0]; frame["refund"] != float64(0) {
+		t.Errorf("refund counter at frame entry mismatch: %v", frame["refund"])
+	}
+	if ret := recorder.byMethod("Return")[0]; ret["refund"] != float64(15000) {
+		t.Errorf("refund counter at frame return mismatch: %v", ret["refund"])
+	}
+	if receipt := recorder.byMethod("Receipt")[0]; receipt["gasUsed"] != float64(13003) {
+		t.Errorf("gas used mismatch: %v", receipt["gasUsed"])
+	}
+}
+
+func TestRecordFault(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		jumper = common.HexToAddress("0x0a")
+		db     = ethdb.NewMemDatabase()
+		gspec  = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{
+			addr:   {Balance: big.NewInt(10000000000000)},
+			jumper: {Balance: new(big.Int), Code: common.FromHex("0x6001600556")}, // PUSH1 1 PUSH1 5 JUMP
+		}}
+		genesis  = gspec.MustCommit(db)
+		signer   = types.NewEIP155Signer(gspec.Config.ChainID)
+		recorder = new(sliceRecorder)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{Recorder: recorder, RecordConfig: vm.RecordConfig{FaultStack: 1}})
+	defer blockchain.Stop()
+
+	chain, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 1, func(i int, gen *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(addr), jumper, new(big.Int), 50000, big.NewInt(1), nil), signer, key)
+		gen.AddTx(tx)
+	})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// The jump destination was popped, but is recorded as the jump found it
+	ret := recorder.byMethod("Return")[0]
+	want := map[string]interface{}{"pc": float64(4), "op": "JUMP", "stack": []interface{}{"0x5"}, "code": "0x6001600556", "codeStart": float64(0),
+		"jump": map[string]interface{}{"destination": "0x5", "inPushData": false, "outOfCode": true}}
+	if !reflect.DeepEqual(ret["fault"], want) {
+		t.Errorf("fault mismatch: have %v, want %v", ret["fault"], want)
+	}
+}
+
+func TestRecordInvalidJump(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		jumper = common.HexToAddress("0x0a")
+		db     = ethdb.NewMemDatabase()
+		gspec  = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{
+			addr: {Balance: big.NewInt(10000000000000)},
+			// JUMPDEST PUSH1 5 JUMP PUSH1 0x5b JUMPDEST STOP, jumping into the pushed data
+			jumper: {Balance: new(big.Int), Code: common.FromHex("0x5b600556605b5b00")},
+		}}
+		genesis  = gspec.MustCommit(db)
+		signer   = types.NewEIP155Signer(gspec.Config.ChainID)
+		recorder = new(sliceRecorder)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{Recorder: recorder, RecordConfig: vm.RecordConfig{FaultStack: 1}})
+	defer blockchain.Stop()
+
+	chain, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 1, func(i int, gen *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(addr), jumper, new(big.Int), 50000, big.NewInt(1), nil), signer, key)
+		gen.AddTx(tx)
+	})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	fault, _ := recorder.byMethod("Return")[0]["fault"].(map[string]interface{})
+	want := map[string]interface{}{"destination": "0x5", "inPushData": true, "outOfCode": false, "prevJumpdest": float64(0), "nextJumpdest": float64(6)}
+	if !reflect.DeepEqual(fault["jump"], want) {
+		t.Errorf("invalid jump mismatch: have %v, want %v", fault["jump"], want)
+	}
+}
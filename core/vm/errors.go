@@ -16,7 +16,10 @@
 
 package vm
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 // List execution errors
 var (
@@ -26,4 +29,57 @@ var (
 	ErrTraceLimitReached        = errors.New("the number of logs reached the specified limit")
 	ErrInsufficientBalance      = errors.New("insufficient balance for transfer")
 	ErrContractAddressCollision = errors.New("contract address collision")
+	ErrExecutionReverted        = errors.New("evm: execution reverted")
+
+	// Errors reported with details (e.g. the offending operation) following
+	// their message
+	ErrInvalidJump    = errors.New("invalid jump destination")
+	ErrInvalidOpCode  = errors.New("invalid opcode")
+	ErrStackUnderflow = errors.New("stack underflow")
+	ErrStackOverflow  = errors.New("stack limit reached")
+)
+
+// executionErrors are the errors recorded messages are parsed back into, and
+// detailedErrors those of them reported with details.
+var (
+	executionErrors = []error{
+		ErrOutOfGas, ErrCodeStoreOutOfGas, ErrDepth, ErrInsufficientBalance, ErrContractAddressCollision,
+		ErrExecutionReverted, errWriteProtection, errReturnDataOutOfBounds, errMaxCodeSizeExceeded, errGasUintOverflow,
+	}
+	detailedErrors = []error{ErrInvalidJump, ErrInvalidOpCode, ErrStackUnderflow, ErrStackOverflow}
 )
+
+// detailedError is an execution error carrying details after its message.
+type detailedError struct {
+	err    error
+	detail string
+}
+
+func (e *detailedError) Error() string {
+	return e.err.Error() + " " + e.detail
+}
+
+// ErrorKind returns the execution error err is an instance of, err itself if
+// it carries no details.
+func ErrorKind(err error) error {
+	if detailed, ok := err.(*detailedError); ok {
+		return detailed.err
+	}
+	return err
+}
+
+// ParseError returns the execution error a message (e.g. one recorded as the
+// failure of a call frame) was produced by, or nil if it's unknown.
+func ParseError(msg string) error {
+	for _, err := range executionErrors {
+		if msg == err.Error() {
+			return err
+		}
+	}
+	for _, err := range detailedErrors {
+		if strings.HasPrefix(msg, err.Error()+" ") {
+			return err
+		}
+	}
+	return nil
+}
@@ -22,14 +22,11 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
 	"syscall"
-	"encoding/json"
-	"bufio"
-	"os"
 )
 
 // emptyCodeHash is used by create to ensure deployment is disallowed to already
@@ -54,7 +51,9 @@ func run(evm *EVM, contract *Contract, input []byte) ([]byte, error) {
 			precompiles = PrecompiledContractsByzantium
 		}
 		if p := precompiles[*contract.CodeAddr]; p != nil {
-			return RunPrecompiledContract(p, input, contract)
+			ret, err := RunPrecompiledContract(p, input, contract)
+			evm.recordPrecompile(*contract.CodeAddr, p, input, ret, err)
+			return ret, err
 		}
 	}
 	return evm.interpreter.Run(contract, input)
@@ -119,14 +118,18 @@ type EVM struct {
 	callGasTemp uint64
 
 	// for tx date export
-	txEnc *json.Encoder
-	bufTxFile *bufio.Writer
-	txFile *os.File
+	frameCount int                                 // number of call frames recorded for this transaction
+	frames     []recordedFrame                     // recorded frames currently executing
+	skipped    int                                 // number of unrecorded frames currently executing due to the limits
+	truncated  bool                                // whether any frame or operation was left out due to the limits
+	codes      map[common.Hash]bool                // hashes of the code recorded for this transaction
+	gasProfile *[256]opcodeGas                     // gas consumed per opcode by this transaction, if profiled
+	accessList map[common.Address]*accessedAccount // accounts accessed by this transaction, if recorded
 }
 
 // NewEVM returns a new EVM. The returned EVM is not thread safe and should
 // only ever be used *once*.
-func NewEVM(ctx Context, statedb StateDB, chainConfig *params.ChainConfig, vmConfig Config, opt ...interface{}) *EVM {
+func NewEVM(ctx Context, statedb StateDB, chainConfig *params.ChainConfig, vmConfig Config) *EVM {
 	evm := &EVM{
 		Context:     ctx,
 		StateDB:     statedb,
@@ -135,17 +138,6 @@ func NewEVM(ctx Context, statedb StateDB, chainConfig *params.ChainConfig, vmCon
 		chainRules:  chainConfig.Rules(ctx.BlockNumber),
 	}
 
-	if opt != nil {
-		for _, param := range opt {
-			switch concrete := param.(type) {
-			case *json.Encoder:
-				evm.txEnc = concrete
-			case *bufio.Writer:
-				evm.bufTxFile = concrete
-			}
-		}
-	}
-
 	evm.interpreter = NewInterpreter(evm, vmConfig)
 	return evm
 }
@@ -167,10 +159,12 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 
 	// Fail if we're trying to execute above the call depth limit
 	if evm.depth > int(params.CallCreateDepth) {
+		evm.recordRejected(map[string]interface{}{"method": evm.callMethod(addr, evm.StateDB.GetCode(addr), value, external != nil), "external": external != nil, "from": caller.Address(), "to": addr, "value": value, "data": hexutil.Bytes(input)}, ErrDepth)
 		return nil, gas, ErrDepth
 	}
 	// Fail if we're trying to transfer more than the available balance
 	if !evm.Context.CanTransfer(evm.StateDB, caller.Address(), value) {
+		evm.recordRejected(map[string]interface{}{"method": evm.callMethod(addr, evm.StateDB.GetCode(addr), value, external != nil), "external": external != nil, "from": caller.Address(), "to": addr, "value": value, "data": hexutil.Bytes(input)}, ErrInsufficientBalance)
 		return nil, gas, ErrInsufficientBalance
 	}
 
@@ -211,13 +205,13 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 		}()
 	}
 
-	if err := evm.txEnc.Encode(map[string]interface{}{"method": "Call",  "external": external != nil, "from": caller.Address(), "to": addr, "value": value, "data": hexutil.Bytes(input)}); err != nil {
+	if err := evm.recordFrame(map[string]interface{}{"method": evm.callMethod(addr, contract.Code, value, external != nil), "external": external != nil, "from": caller.Address(), "to": addr, "value": value, "data": hexutil.Bytes(input)}, contract); err != nil {
 		log.Error("Unable to write to tx_data")
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 		return nil, gas, nil
 	}
-	evm.bufTxFile.Flush()
 	ret, err = run(evm, contract, input)
+	evm.exitFrame(ret, err)
 
 	// When an error was returned by the EVM or when setting the creation code
 	// above we revert to the snapshot and consume any gas remaining. Additionally
@@ -245,10 +239,12 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 
 	// Fail if we're trying to execute above the call depth limit
 	if evm.depth > int(params.CallCreateDepth) {
+		evm.recordRejected(map[string]interface{}{"method": "CallCode", "external": false, "from": caller.Address(), "to": addr, "value": value, "data": hexutil.Bytes(input)}, ErrDepth)
 		return nil, gas, ErrDepth
 	}
 	// Fail if we're trying to transfer more than the available balance
 	if !evm.CanTransfer(evm.StateDB, caller.Address(), value) {
+		evm.recordRejected(map[string]interface{}{"method": "CallCode", "external": false, "from": caller.Address(), "to": addr, "value": value, "data": hexutil.Bytes(input)}, ErrInsufficientBalance)
 		return nil, gas, ErrInsufficientBalance
 	}
 
@@ -262,13 +258,13 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 	contract := NewContract(caller, to, value, gas)
 	contract.SetCallCode(&addr, evm.StateDB.GetCodeHash(addr), evm.StateDB.GetCode(addr))
 
-	if err := evm.txEnc.Encode(map[string]interface{}{"method": "CallCode",  "external": false, "from": caller.Address(), "to": addr, "value": value, "data": hexutil.Bytes(input)}); err != nil {
+	if err := evm.recordFrame(map[string]interface{}{"method": "CallCode", "external": false, "from": caller.Address(), "to": addr, "value": value, "data": hexutil.Bytes(input)}, contract); err != nil {
 		log.Error("Unable to write to tx_data")
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 		return nil, gas, nil
 	}
-	evm.bufTxFile.Flush()
 	ret, err = run(evm, contract, input)
+	evm.exitFrame(ret, err)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
 		if err != errExecutionReverted {
@@ -289,6 +285,7 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 	}
 	// Fail if we're trying to execute above the call depth limit
 	if evm.depth > int(params.CallCreateDepth) {
+		evm.recordRejected(map[string]interface{}{"method": "DelegateCall", "external": false, "from": caller.Address(), "to": addr, "value": 0, "data": hexutil.Bytes(input)}, ErrDepth)
 		return nil, gas, ErrDepth
 	}
 
@@ -301,13 +298,13 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 	contract := NewContract(caller, to, nil, gas).AsDelegate()
 	contract.SetCallCode(&addr, evm.StateDB.GetCodeHash(addr), evm.StateDB.GetCode(addr))
 
-	if err := evm.txEnc.Encode(map[string]interface{}{"method": "DelegateCall", "external": false,  "from": caller.Address(), "to": addr, "value": 0, "data": hexutil.Bytes(input)}); err != nil {
+	if err := evm.recordFrame(map[string]interface{}{"method": "DelegateCall", "external": false, "from": caller.Address(), "to": addr, "value": 0, "data": hexutil.Bytes(input)}, contract); err != nil {
 		log.Error("Unable to write to tx_data")
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 		return nil, gas, nil
 	}
-	evm.bufTxFile.Flush()
 	ret, err = run(evm, contract, input)
+	evm.exitFrame(ret, err)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
 		if err != errExecutionReverted {
@@ -327,6 +324,7 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 	}
 	// Fail if we're trying to execute above the call depth limit
 	if evm.depth > int(params.CallCreateDepth) {
+		evm.recordRejected(map[string]interface{}{"method": "StaticCall", "external": false, "from": caller.Address(), "to": addr, "value": 0, "data": hexutil.Bytes(input)}, ErrDepth)
 		return nil, gas, ErrDepth
 	}
 	// Make sure the readonly is only set if we aren't in readonly yet
@@ -347,16 +345,16 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 	contract := NewContract(caller, to, new(big.Int), gas)
 	contract.SetCallCode(&addr, evm.StateDB.GetCodeHash(addr), evm.StateDB.GetCode(addr))
 
-	if err := evm.txEnc.Encode(map[string]interface{}{"method": "StaticCall",  "external": false, "from": caller.Address(), "to": addr, "value": 0, "data": hexutil.Bytes(input)}); err != nil {
+	if err := evm.recordFrame(map[string]interface{}{"method": "StaticCall", "external": false, "from": caller.Address(), "to": addr, "value": 0, "data": hexutil.Bytes(input)}, contract); err != nil {
 		log.Error("Unable to write to tx_data")
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 		return nil, gas, nil
 	}
-	evm.bufTxFile.Flush()
 	// When an error was returned by the EVM or when setting the creation code
 	// above we revert to the snapshot and consume any gas remaining. Additionally
 	// when we're in Homestead this also counts for code storage gas errors.
 	ret, err = run(evm, contract, input)
+	evm.exitFrame(ret, err)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
 		if err != errExecutionReverted {
@@ -372,9 +370,11 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 	// Depth check execution. Fail if we're trying to execute above the
 	// limit.
 	if evm.depth > int(params.CallCreateDepth) {
+		evm.recordRejected(map[string]interface{}{"method": "Create", "external": external != nil, "from": caller.Address(), "to": nil, "value": value, "data": hexutil.Bytes(code)}, ErrDepth)
 		return nil, common.Address{}, gas, ErrDepth
 	}
 	if !evm.CanTransfer(evm.StateDB, caller.Address(), value) {
+		evm.recordRejected(map[string]interface{}{"method": "Create", "external": external != nil, "from": caller.Address(), "to": nil, "value": value, "data": hexutil.Bytes(code)}, ErrInsufficientBalance)
 		return nil, common.Address{}, gas, ErrInsufficientBalance
 	}
 	// Ensure there's no existing contract already at the designated address
@@ -384,6 +384,7 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 	contractAddr = crypto.CreateAddress(caller.Address(), nonce)
 	contractHash := evm.StateDB.GetCodeHash(contractAddr)
 	if evm.StateDB.GetNonce(contractAddr) != 0 || (contractHash != (common.Hash{}) && contractHash != emptyCodeHash) {
+		evm.recordRejected(map[string]interface{}{"method": "Create", "external": external != nil, "from": caller.Address(), "to": contractAddr, "value": value, "data": hexutil.Bytes(code)}, ErrContractAddressCollision)
 		return nil, common.Address{}, 0, ErrContractAddressCollision
 	}
 	// Create a new account on the state
@@ -409,12 +410,11 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 	}
 	start := time.Now()
 
-	if err := evm.txEnc.Encode(map[string]interface{}{"method": "Create", "external": external != nil, "from": caller.Address(), "to": nil, "value": value, "data": hexutil.Bytes(code)}); err != nil {
+	if err := evm.recordFrame(map[string]interface{}{"method": "Create", "external": external != nil, "from": caller.Address(), "to": nil, "value": value, "data": hexutil.Bytes(code)}, contract); err != nil {
 		log.Error("Unable to write to tx_data")
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 		return nil, common.Address{}, gas, ErrDepth
 	}
-	evm.bufTxFile.Flush()
 	ret, err = run(evm, contract, nil)
 
 	// check whether the max code size has been exceeded
@@ -445,6 +445,7 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 	if maxCodeSizeExceeded && err == nil {
 		err = errMaxCodeSizeExceeded
 	}
+	evm.exitFrame(ret, err)
 	if evm.vmConfig.Debug && evm.depth == 0 {
 		evm.vmConfig.Tracer.CaptureEnd(ret, gas-contract.Gas, time.Since(start), err)
 	}
@@ -33,7 +33,7 @@ var (
 	tt255                    = math.BigPow(2, 255)
 	errWriteProtection       = errors.New("evm: write protection")
 	errReturnDataOutOfBounds = errors.New("evm: return data out of bounds")
-	errExecutionReverted     = errors.New("evm: execution reverted")
+	errExecutionReverted     = ErrExecutionReverted
 	errMaxCodeSizeExceeded   = errors.New("evm: max code size exceeded")
 )
 
@@ -589,7 +589,7 @@ func opJump(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Sta
 	pos := stack.pop()
 	if !contract.jumpdests.has(contract.CodeHash, contract.Code, pos) {
 		nop := contract.GetOp(pos.Uint64())
-		return nil, fmt.Errorf("invalid jump destination (%v) %v", nop, pos)
+		return nil, &detailedError{ErrInvalidJump, fmt.Sprintf("(%v) %v", nop, pos)}
 	}
 	*pc = pos.Uint64()
 
@@ -602,7 +602,7 @@ func opJumpi(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *St
 	if cond.Sign() != 0 {
 		if !contract.jumpdests.has(contract.CodeHash, contract.Code, pos) {
 			nop := contract.GetOp(pos.Uint64())
-			return nil, fmt.Errorf("invalid jump destination (%v) %v", nop, pos)
+			return nil, &detailedError{ErrInvalidJump, fmt.Sprintf("(%v) %v", nop, pos)}
 		}
 		*pc = pos.Uint64()
 	} else {
@@ -795,7 +795,9 @@ func opStop(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Sta
 
 func opSuicide(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	balance := evm.StateDB.GetBalance(contract.Address())
-	evm.StateDB.AddBalance(common.BigToAddress(stack.pop()), balance)
+	beneficiary := common.BigToAddress(stack.pop())
+	evm.recordOp(map[string]interface{}{"method": "Selfdestruct", "from": contract.Address(), "to": beneficiary, "value": balance})
+	evm.StateDB.AddBalance(beneficiary, balance)
 
 	evm.StateDB.Suicide(contract.Address())
 	return nil, nil
@@ -35,6 +35,11 @@ type Config struct {
 	NoRecursion bool
 	// Enable recording of SHA3/keccak preimages
 	EnablePreimageRecording bool
+	// Recorder receives the transaction data records, recording is
+	// disabled if nil
+	Recorder Recorder
+	// RecordConfig are the options of the transaction data recording
+	RecordConfig RecordConfig
 	// JumpTable contains the EVM instruction table. This
 	// may be left uninitialised and will be set to the default
 	// table.
@@ -143,6 +148,30 @@ func (in *Interpreter) Run(contract *Contract, input []byte) (ret []byte, err er
 	// Reclaim the stack as an int pool when the execution stops
 	defer func() { in.intPool.put(stack.data...) }()
 
+	// Track the stack high-water mark, memory only grows during the execution
+	var (
+		resources = in.cfg.Recorder != nil && in.cfg.RecordConfig.Resources
+		maxStack  int
+	)
+	if resources {
+		defer func() { in.evm.recordResources(mem.Len(), maxStack) }()
+	}
+
+	// Note the context of the operation the frame faults at, if any. Operations
+	// failing as they execute have only popped their operands, which are still
+	// held past the end of the stack
+	var (
+		faults = in.cfg.Recorder != nil && in.cfg.RecordConfig.FaultStack > 0
+		depth  int // stack depth before the current operation
+	)
+	if faults {
+		defer func() {
+			if err != nil && err != errExecutionReverted {
+				in.evm.recordFault(pc, op, stack.data[:depth], contract, err)
+			}
+		}()
+	}
+
 	if in.cfg.Debug {
 		defer func() {
 			if err != nil {
@@ -164,12 +193,15 @@ func (in *Interpreter) Run(contract *Contract, input []byte) (ret []byte, err er
 			logged, pcCopy, gasCopy = false, pc, contract.Gas
 		}
 
+		if faults {
+			depth = stack.len()
+		}
 		// Get the operation from the jump table and validate the stack to ensure there are
 		// enough stack items available to perform the operation.
 		op = contract.GetOp(pc)
 		operation := in.cfg.JumpTable[op]
 		if !operation.valid {
-			return nil, fmt.Errorf("invalid opcode 0x%x", int(op))
+			return nil, &detailedError{ErrInvalidOpCode, fmt.Sprintf("0x%x", int(op))}
 		}
 		if err := operation.validateStack(stack); err != nil {
 			return nil, err
@@ -197,11 +229,29 @@ func (in *Interpreter) Run(contract *Contract, input []byte) (ret []byte, err er
 		// cost is explicitly set so that the capture state defer method can get the proper cost
 		cost, err = operation.gasCost(in.gasTable, in.evm, contract, stack, mem, memorySize)
 		if err != nil || !contract.UseGas(cost) {
+			// The remaining gas is burnt by the operation running out of it
+			if in.cfg.Recorder != nil && in.cfg.RecordConfig.GasProfile {
+				in.evm.profileOp(op, contract.Gas)
+			}
 			return nil, ErrOutOfGas
 		}
+		if in.cfg.Recorder != nil && in.cfg.RecordConfig.GasProfile {
+			// Gas passed on to a call is accounted to the operations of the callee
+			if op == CALL || op == CALLCODE || op == DELEGATECALL || op == STATICCALL {
+				in.evm.profileOp(op, cost-in.evm.callGasTemp)
+			} else {
+				in.evm.profileOp(op, cost)
+			}
+		}
 		if memorySize > 0 {
 			mem.Resize(memorySize)
 		}
+		if in.cfg.Recorder != nil && in.cfg.RecordConfig.AccessList {
+			in.evm.accessOp(op, stack, contract)
+		}
+		if in.cfg.Recorder != nil && len(in.cfg.RecordConfig.Watchlist) > 0 {
+			in.evm.recordStep(pc, op, contract.Gas+cost, cost, stack, contract)
+		}
 
 		if in.cfg.Debug {
 			in.cfg.Tracer.CaptureState(in.evm, pc, op, gasCopy, cost, mem, stack, contract, in.evm.depth, err)
@@ -215,6 +265,9 @@ func (in *Interpreter) Run(contract *Contract, input []byte) (ret []byte, err er
 		if verifyPool {
 			verifyIntegerPool(in.intPool)
 		}
+		if resources && stack.len() > maxStack {
+			maxStack = stack.len()
+		}
 		// if the operation clears the return data (e.g. it has returning data)
 		// set the last return to the result of the operation.
 		if operation.returns {
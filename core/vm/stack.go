@@ -76,7 +76,7 @@ func (st *Stack) Back(n int) *big.Int {
 
 func (st *Stack) require(n int) error {
 	if st.len() < n {
-		return fmt.Errorf("stack underflow (%d <=> %d)", len(st.data), n)
+		return &detailedError{ErrStackUnderflow, fmt.Sprintf("(%d <=> %d)", len(st.data), n)}
 	}
 	return nil
 }
@@ -29,7 +29,7 @@ func makeStackFunc(pop, push int) stackValidationFunc {
 		}
 
 		if stack.len()+push-pop > int(params.StackLimit) {
-			return fmt.Errorf("stack limit reached %d (%d)", stack.len(), params.StackLimit)
+			return &detailedError{ErrStackOverflow, fmt.Sprintf("%d (%d)", stack.len(), params.StackLimit)}
 		}
 		return nil
 	}
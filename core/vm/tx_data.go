@@ -0,0 +1,625 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Recorder receives the records describing the execution of transactions
+// (call frames, account states, system operations). Recorders implementing
+// io.Closer are closed when the blockchain they record is stopped.
+type Recorder interface {
+	// Record persists a single record.
+	Record(record map[string]interface{}) error
+}
+
+// Checkpointer is implemented by recorders that track the progress of the
+// recording. Checkpoint is called once a processed block has been written into
+// the chain, after all of its records. Side blocks are checkpointed as well,
+// after a BlockStatus record marking them as orphaned, so the progress must
+// only follow the blocks not marked.
+type Checkpointer interface {
+	Checkpoint(number uint64, hash common.Hash) error
+}
+
+// RecordConfig are the options of the transaction data recording.
+type RecordConfig struct {
+	MaxDataLength int  // maximum number of input/output bytes recorded per call frame, zero means unlimited
+	MaxFrames     int  // maximum number of call frames recorded per transaction, zero means unlimited
+	MaxDepth      int  // maximum nesting depth of the recorded call frames, zero means unlimited
+	Output        bool // record the return data of every call frame
+	AccountState  bool // record the involved accounts before and after every transaction
+	Code          bool `json:",omitempty"` // record the bytecode of every executed contract once
+	GasProfile    bool `json:",omitempty"` // record the gas consumed per opcode by every transaction
+	Resources     bool `json:",omitempty"` // record the memory and stack high-water marks of every call frame
+	Rejections    bool `json:",omitempty"` // record the transactions rejected before their execution
+	AccessList    bool `json:",omitempty"` // record the accounts and storage slots accessed by every transaction
+	CallState     bool `json:",omitempty"` // record the balances and nonces of the caller and callee of every call frame
+	Refunds       bool `json:",omitempty"` // record the refund counter of every call frame and the refund of every transaction
+	Logs          bool `json:",omitempty"` // record the logs emitted by every transaction along with its receipt
+	FaultStack    int  `json:",omitempty"` // number of top stack items recorded along with the operation a frame faulted at, zero means none
+
+	// Watchlist are the accounts whose frames are recorded in full detail: their
+	// data and output are never truncated and every executed step is recorded,
+	// along with the storage slots it writes. Frame limits still apply.
+	Watchlist []common.Address `json:",omitempty"`
+
+	// Differential is an alternative chain configuration (e.g. without a fork
+	// rule) every transaction is executed under too, on a copy of the state. The
+	// outcomes are recorded if they diverge.
+	Differential *params.ChainConfig `json:",omitempty"`
+}
+
+// opcodeGas is the gas consumed by the executions of an opcode.
+type opcodeGas struct {
+	Count uint64 `json:"count"`
+	Gas   uint64 `json:"gas"`
+}
+
+// recordedFrame is a recorded call frame which is currently executing.
+type recordedFrame struct {
+	index        int // index of the frame within the transaction
+	failedChild  int // index of the last child frame left if it failed, -1 otherwise
+	failedOrigin int // depth the failure of that child originated at
+
+	maxMemory int                    // largest memory size reached by the frame, in bytes
+	maxStack  int                    // largest data stack depth reached by the frame
+	watched   bool                   // whether the frame is recorded in full detail
+	refund    uint64                 // refund counter as the frame began
+	fault     map[string]interface{} // context of the operation the frame faulted at, if any
+}
+
+// truncateData cuts a payload to the configured maximum length, reporting
+// whether anything was dropped.
+func (evm *EVM) truncateData(data []byte) (hexutil.Bytes, bool) {
+	if limit := evm.vmConfig.RecordConfig.MaxDataLength; limit > 0 && len(data) > limit {
+		return data[:limit], true
+	}
+	return data, false
+}
+
+// RecordTruncated reports whether any call frame or operation of the current
+// transaction was left out of the records due to the configured limits.
+func (evm *EVM) RecordTruncated() bool {
+	return evm.truncated
+}
+
+// skipFrame reports whether a new call frame exceeds the configured limits. A
+// frame spawned by a skipped one is skipped as well, keeping the recorded call
+// tree connected.
+func (evm *EVM) skipFrame() bool {
+	config := evm.vmConfig.RecordConfig
+	return evm.skipped > 0 ||
+		(config.MaxFrames > 0 && evm.frameCount >= config.MaxFrames) ||
+		(config.MaxDepth > 0 && len(evm.frames) >= config.MaxDepth)
+}
+
+// watched reports whether any of the given accounts is on the watchlist.
+func (evm *EVM) watched(addrs ...common.Address) bool {
+	for _, watched := range evm.vmConfig.RecordConfig.Watchlist {
+		for _, addr := range addrs {
+			if addr == watched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordFrame writes a call frame record. Every frame is tagged with its index
+// within the transaction and the index of the frame that spawned it (-1 for
+// the outermost one), so the call tree can be rebuilt from the flat list of
+// records. On success the frame is entered and must be left with exitFrame
+// once its execution finishes. Frames executing code are linked to it by hash.
+func (evm *EVM) recordFrame(record map[string]interface{}, contract *Contract) error {
+	if evm.vmConfig.Recorder == nil {
+		return nil
+	}
+	if evm.vmConfig.RecordConfig.AccessList {
+		evm.accessFrame(record, contract)
+	}
+	if evm.skipFrame() {
+		evm.skipped++
+		evm.truncated = true
+		return nil
+	}
+	parent := -1
+	if n := len(evm.frames); n > 0 {
+		parent = evm.frames[n-1].index
+	}
+	record["index"], record["parent"] = evm.frameCount, parent
+	if _, ok := record["calleeExecuted"]; !ok {
+		record["calleeExecuted"] = true
+	}
+	// Frames running the code of or on the storage of a watched account are
+	// recorded in full
+	var watched bool
+	if contract != nil {
+		watched = evm.watched(*contract.CodeAddr, contract.Address())
+	} else if to, ok := record["to"].(common.Address); ok {
+		watched = evm.watched(to)
+	}
+	if data, ok := record["data"].(hexutil.Bytes); ok {
+		// Keep the function selector of calls, even if the data gets truncated
+		if record["method"] != "Create" && len(data) >= 4 {
+			record["selector"] = data[:4]
+		}
+		if watched {
+			record["dataTruncated"] = false
+		} else {
+			record["data"], record["dataTruncated"] = evm.truncateData(data)
+		}
+	}
+	// Frames below a static call can't modify the state either, delegated ones
+	// run in the context of their caller
+	record["static"] = evm.interpreter.readOnly || record["method"] == "StaticCall"
+	record["delegated"] = record["method"] == "DelegateCall"
+	if contract != nil {
+		record["codeAddress"], record["storageAddress"] = contract.CodeAddr, contract.Address()
+	}
+	if contract != nil && len(contract.Code) > 0 {
+		record["codeHash"] = contract.CodeHash
+	}
+	if evm.vmConfig.RecordConfig.CallState {
+		evm.recordCallState(record, contract)
+	}
+	refund := evm.StateDB.GetRefund()
+	if evm.vmConfig.RecordConfig.Refunds {
+		record["refund"] = refund
+	}
+	if err := evm.vmConfig.Recorder.Record(record); err != nil {
+		return err
+	}
+	if contract != nil && len(contract.Code) > 0 && evm.vmConfig.RecordConfig.Code {
+		if err := evm.recordCode(contract); err != nil {
+			return err
+		}
+	}
+	evm.frames = append(evm.frames, recordedFrame{index: evm.frameCount, failedChild: -1, watched: watched, refund: refund})
+	evm.frameCount++
+	return nil
+}
+
+// recordCallState adds the balances and nonces of the caller and callee to a
+// call frame record, as the frame begins: executed frames already had their
+// value transferred (and created accounts their nonce set), rejected ones are
+// recorded as they were rejected. Rejected creations have no callee.
+func (evm *EVM) recordCallState(record map[string]interface{}, contract *Contract) {
+	if from, ok := record["from"].(common.Address); ok {
+		record["fromBalance"], record["fromNonce"] = evm.StateDB.GetBalance(from), evm.StateDB.GetNonce(from)
+	}
+	to, ok := record["to"].(common.Address)
+	if contract != nil {
+		to, ok = contract.Address(), true
+	}
+	if ok {
+		record["toBalance"], record["toNonce"] = evm.StateDB.GetBalance(to), evm.StateDB.GetNonce(to)
+	}
+}
+
+// recordResources sets the memory and stack high-water marks of the executing
+// call frame, if it is recorded.
+func (evm *EVM) recordResources(memory, stack int) {
+	if n := len(evm.frames); evm.skipped == 0 && n > 0 {
+		evm.frames[n-1].maxMemory, evm.frames[n-1].maxStack = memory, stack
+	}
+}
+
+// recordStep writes an operation about to be executed by a watched frame, with
+// the gas available before it and its cost. Storage writes also carry the slot
+// written along with its previous and new values.
+func (evm *EVM) recordStep(pc uint64, op OpCode, gas, cost uint64, stack *Stack, contract *Contract) {
+	n := len(evm.frames)
+	if evm.skipped > 0 || n == 0 || !evm.frames[n-1].watched {
+		return
+	}
+	record := map[string]interface{}{"method": "Step", "index": evm.frames[n-1].index, "pc": pc, "op": op.String(), "gas": gas, "cost": cost}
+	if op == SSTORE {
+		key := common.BigToHash(stack.Back(0))
+		record["storage"] = map[string]interface{}{
+			"key":  key,
+			"from": evm.StateDB.GetState(contract.Address(), key),
+			"to":   common.BigToHash(stack.Back(1)),
+		}
+	}
+	evm.writeRecord(record)
+}
+
+// faultCodeWindow is the number of code bytes recorded on either side of the
+// operation a frame faulted at.
+const faultCodeWindow = 16
+
+// recordFault notes the context of the operation the executing frame faulted
+// at, recorded once the frame returns: the top of the stack as the operation
+// found it, top first, and the code around it. Invalid jumps have their
+// destination analysed as well.
+func (evm *EVM) recordFault(pc uint64, op OpCode, stack []*big.Int, contract *Contract, err error) {
+	n := len(evm.frames)
+	if evm.skipped > 0 || n == 0 {
+		return
+	}
+	items := make([]*hexutil.Big, 0, evm.vmConfig.RecordConfig.FaultStack)
+	for i := len(stack) - 1; i >= 0 && len(items) < cap(items); i-- {
+		items = append(items, (*hexutil.Big)(new(big.Int).Set(stack[i])))
+	}
+	var (
+		start = uint64(0)
+		end   = pc + faultCodeWindow + 1
+	)
+	if pc > faultCodeWindow {
+		start = pc - faultCodeWindow
+	}
+	if end > uint64(len(contract.Code)) {
+		end = uint64(len(contract.Code))
+	}
+	if start > end {
+		start = end
+	}
+	fault := map[string]interface{}{
+		"pc":        pc,
+		"op":        op.String(),
+		"stack":     items,
+		"code":      hexutil.Bytes(common.CopyBytes(contract.Code[start:end])),
+		"codeStart": start,
+	}
+	if (op == JUMP || op == JUMPI) && len(stack) > 0 && ErrorKind(err) == ErrInvalidJump {
+		fault["jump"] = analyzeJump(contract, stack[len(stack)-1])
+	}
+	evm.frames[n-1].fault = fault
+}
+
+// analyzeJump describes the destination of an invalid jump: whether it lies in
+// the data of a PUSH operation, and the valid JUMPDESTs nearest to it in the
+// code, before and after it.
+func analyzeJump(contract *Contract, dest *big.Int) map[string]interface{} {
+	bits, analysed := contract.jumpdests[contract.CodeHash]
+	if !analysed {
+		bits = codeBitmap(contract.Code)
+	}
+	var (
+		code = contract.Code
+		size = uint64(len(code))
+		pos  = size // position the valid JUMPDESTs are searched around
+	)
+	if dest.BitLen() < 63 && dest.Uint64() < size {
+		pos = dest.Uint64()
+	}
+	valid := func(pc uint64) bool { return OpCode(code[pc]) == JUMPDEST && bits.codeSegment(pc) }
+
+	record := map[string]interface{}{
+		"destination": (*hexutil.Big)(new(big.Int).Set(dest)),
+		"inPushData":  pos < size && !bits.codeSegment(pos),
+		"outOfCode":   pos == size,
+	}
+	for pc := pos; pc > 0; pc-- {
+		if valid(pc - 1) {
+			record["prevJumpdest"] = pc - 1
+			break
+		}
+	}
+	for pc := pos + 1; pc < size; pc++ {
+		if valid(pc) {
+			record["nextJumpdest"] = pc
+			break
+		}
+	}
+	return record
+}
+
+// profileOp accounts the gas consumed by an executed opcode to the gas profile
+// of the transaction.
+func (evm *EVM) profileOp(op OpCode, gas uint64) {
+	if evm.gasProfile == nil {
+		evm.gasProfile = new([256]opcodeGas)
+	}
+	evm.gasProfile[op].Count++
+	evm.gasProfile[op].Gas += gas
+}
+
+// RecordGasProfile writes the gas consumed per opcode by the transaction, if
+// enabled. It must be called once the transaction has been executed.
+func (evm *EVM) RecordGasProfile() {
+	if evm.vmConfig.Recorder == nil || !evm.vmConfig.RecordConfig.GasProfile {
+		return
+	}
+	ops := make(map[string]opcodeGas)
+	if evm.gasProfile != nil {
+		for op, gas := range evm.gasProfile {
+			if gas.Count > 0 {
+				ops[OpCode(op).String()] = gas
+			}
+		}
+	}
+	evm.writeRecord(map[string]interface{}{"method": "GasProfile", "ops": ops})
+}
+
+// RecordRefund writes the refund of the transaction, if enabled: the refund
+// counter accumulated by its execution, the limit of half the gas it used and
+// the gas actually refunded. It must be called as the gas is refunded.
+func (evm *EVM) RecordRefund(counter, limit uint64) {
+	if evm.vmConfig.Recorder == nil || !evm.vmConfig.RecordConfig.Refunds {
+		return
+	}
+	applied := counter
+	if applied > limit {
+		applied = limit
+	}
+	evm.writeRecord(map[string]interface{}{"method": "Refund", "counter": counter, "limit": limit, "applied": applied, "capped": counter > limit})
+}
+
+// accessedAccount is an account accessed by a transaction, along with the
+// storage slots of it accessed.
+type accessedAccount struct {
+	written bool
+	slots   map[common.Hash]bool // whether each accessed slot was written
+}
+
+// access adds an account to the access list of the transaction.
+func (evm *EVM) access(addr common.Address, written bool) *accessedAccount {
+	if evm.accessList == nil {
+		evm.accessList = make(map[common.Address]*accessedAccount)
+	}
+	account := evm.accessList[addr]
+	if account == nil {
+		account = &accessedAccount{slots: make(map[common.Hash]bool)}
+		evm.accessList[addr] = account
+	}
+	account.written = account.written || written
+	return account
+}
+
+// accessFrame adds the accounts involved in entering a call frame to the access
+// list: the caller and callee are written if value is moved between them (or
+// the callee is created), read otherwise. The sender of the transaction always
+// has its nonce and balance updated.
+func (evm *EVM) accessFrame(record map[string]interface{}, contract *Contract) {
+	value, _ := record["value"].(*big.Int)
+	moved := value != nil && value.Sign() > 0
+
+	if from, ok := record["from"].(common.Address); ok {
+		evm.access(from, moved || record["external"] == true)
+	}
+	if to, ok := record["to"].(common.Address); ok {
+		evm.access(to, moved || record["method"] == "Create")
+	}
+	if contract != nil {
+		evm.access(contract.Address(), moved || record["method"] == "Create")
+		if contract.CodeAddr != nil {
+			evm.access(*contract.CodeAddr, false)
+		}
+	}
+}
+
+// accessOp adds the accounts and storage slots an operation about to be
+// executed accesses to the access list of the transaction.
+func (evm *EVM) accessOp(op OpCode, stack *Stack, contract *Contract) {
+	switch op {
+	case SLOAD:
+		slots := evm.access(contract.Address(), false).slots
+		if key := common.BigToHash(stack.Back(0)); !slots[key] {
+			slots[key] = false
+		}
+	case SSTORE:
+		evm.access(contract.Address(), true).slots[common.BigToHash(stack.Back(0))] = true
+	case BALANCE, EXTCODESIZE, EXTCODECOPY:
+		evm.access(common.BigToAddress(stack.Back(0)), false)
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		evm.access(common.BigToAddress(stack.Back(1)), false)
+	case SELFDESTRUCT:
+		evm.access(contract.Address(), true)
+		evm.access(common.BigToAddress(stack.Back(0)), true)
+	}
+}
+
+// RecordAccessList writes the accounts and storage slots accessed by the
+// transaction, ordered by address and key, if enabled. It must be called once
+// the transaction has been executed.
+func (evm *EVM) RecordAccessList() {
+	if evm.vmConfig.Recorder == nil || !evm.vmConfig.RecordConfig.AccessList {
+		return
+	}
+	addrs := make([]common.Address, 0, len(evm.accessList))
+	for addr := range evm.accessList {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+
+	accounts := make([]map[string]interface{}, 0, len(addrs))
+	for _, addr := range addrs {
+		account := evm.accessList[addr]
+
+		keys := make([]common.Hash, 0, len(account.slots))
+		for key := range account.slots {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+
+		slots := make([]map[string]interface{}, 0, len(keys))
+		for _, key := range keys {
+			slots = append(slots, map[string]interface{}{"key": key, "written": account.slots[key]})
+		}
+		accounts = append(accounts, map[string]interface{}{"address": addr, "written": account.written, "storage": slots})
+	}
+	evm.writeRecord(map[string]interface{}{"method": "AccessList", "accounts": accounts})
+}
+
+// recordCode writes a record of the code executed by a frame the first time
+// it is executed in the transaction. Recorders may drop the records of code
+// they have seen before.
+func (evm *EVM) recordCode(contract *Contract) error {
+	if evm.codes[contract.CodeHash] {
+		return nil
+	}
+	if evm.codes == nil {
+		evm.codes = make(map[common.Hash]bool)
+	}
+	evm.codes[contract.CodeHash] = true
+
+	return evm.vmConfig.Recorder.Record(map[string]interface{}{"method": "Code", "codeHash": contract.CodeHash, "size": len(contract.Code), "code": hexutil.Bytes(contract.Code)})
+}
+
+// exitFrame leaves the most recently entered call frame, recording the data
+// it returned if enabled. A failed frame is always recorded as returning with
+// the error and where the failure originated: a frame whose last call failed
+// as well is assumed to propagate that failure.
+func (evm *EVM) exitFrame(output []byte, err error) {
+	if evm.vmConfig.Recorder == nil {
+		return
+	}
+	frame, origin, recorded := evm.leaveFrame(err)
+	config := evm.vmConfig.RecordConfig
+	if !recorded || (err == nil && !config.Output && !config.Resources && !config.Refunds && !frame.watched) {
+		return
+	}
+	record := map[string]interface{}{"method": "Return", "index": frame.index}
+	switch {
+	case frame.watched:
+		record["output"], record["outputTruncated"] = hexutil.Bytes(output), false
+	case config.Output:
+		record["output"], record["outputTruncated"] = evm.truncateData(output)
+	}
+	if config.Resources {
+		record["maxMemory"], record["maxStack"] = frame.maxMemory, frame.maxStack
+	}
+	if config.Refunds {
+		// Failed frames have their refunds reverted, though some are only
+		// reverted after leaving the frame
+		record["refund"] = evm.StateDB.GetRefund()
+		if err != nil {
+			record["refund"] = frame.refund
+		}
+	}
+	if err != nil {
+		record["error"], record["originDepth"], record["propagatedFrom"] = err.Error(), origin, frame.failedChild
+		if frame.fault != nil {
+			record["fault"] = frame.fault
+		}
+	}
+	evm.writeRecord(record)
+}
+
+// leaveFrame pops the most recently entered call frame, returning it along with
+// the depth its failure (if any) originated at, and whether it was recorded at
+// all. The failure is noted in the parent frame, so it can be attributed later.
+func (evm *EVM) leaveFrame(err error) (recordedFrame, int, bool) {
+	if evm.skipped > 0 {
+		evm.skipped--
+		if n := len(evm.frames); evm.skipped == 0 && n > 0 {
+			evm.frames[n-1].failedChild = -1
+		}
+		return recordedFrame{}, 0, false
+	}
+	depth := len(evm.frames)
+	frame := evm.frames[depth-1]
+	evm.frames = evm.frames[:depth-1]
+
+	origin := depth
+	if frame.failedChild >= 0 {
+		origin = frame.failedOrigin
+	}
+	if n := len(evm.frames); n > 0 {
+		parent := &evm.frames[n-1]
+		parent.failedChild = -1
+		if err != nil {
+			parent.failedChild, parent.failedOrigin = frame.index, origin
+		}
+	}
+	return frame, origin, true
+}
+
+// recordRejected writes the record of a call frame which failed before its
+// callee was executed (e.g. due to the call depth limit or an insufficient
+// balance), leaving the frame right away.
+func (evm *EVM) recordRejected(record map[string]interface{}, err error) {
+	if evm.vmConfig.Recorder == nil {
+		return
+	}
+	record["calleeExecuted"], record["error"] = false, err.Error()
+	if err := evm.recordFrame(record, nil); err != nil {
+		log.Error("Unable to write to tx_data")
+		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+		return
+	}
+	evm.leaveFrame(err)
+}
+
+// recordOp writes a record of an operation that does not open a call frame of
+// its own (e.g. SELFDESTRUCT), tagged with the frame it was executed in.
+// Operations executed in a skipped frame are skipped as well.
+func (evm *EVM) recordOp(record map[string]interface{}) {
+	if evm.vmConfig.Recorder == nil {
+		return
+	}
+	if evm.skipped > 0 {
+		evm.truncated = true
+		return
+	}
+	record["parent"] = -1
+	if n := len(evm.frames); n > 0 {
+		record["parent"] = evm.frames[n-1].index
+	}
+	evm.writeRecord(record)
+}
+
+// recordPrecompile writes a record of the execution of a precompiled contract,
+// detailing the failure reported by the contract itself. Note that some of them
+// (e.g. ecrecover on an invalid signature) signal failures through an empty
+// output instead.
+func (evm *EVM) recordPrecompile(addr common.Address, p PrecompiledContract, input []byte, output []byte, err error) {
+	if evm.vmConfig.Recorder == nil {
+		return
+	}
+	record := map[string]interface{}{"method": "Precompile", "address": addr, "inputSize": len(input), "outputSize": len(output), "requiredGas": p.RequiredGas(input)}
+	if err != nil {
+		record["error"] = err.Error()
+	}
+	evm.recordOp(record)
+}
+
+// writeRecord persists a record. If the recorder fails the node is interrupted.
+func (evm *EVM) writeRecord(record map[string]interface{}) {
+	if err := evm.vmConfig.Recorder.Record(record); err != nil {
+		log.Error("Unable to write to tx_data")
+		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	}
+}
+
+// callMethod returns the record method of a call into the given address. An
+// internal call moving value into an account without code is recorded as a
+// plain value transfer.
+func (evm *EVM) callMethod(addr common.Address, code []byte, value *big.Int, external bool) string {
+	if external || len(code) > 0 || value.Sign() == 0 {
+		return "Call"
+	}
+	precompiles := PrecompiledContractsHomestead
+	if evm.ChainConfig().IsByzantium(evm.BlockNumber) {
+		precompiles = PrecompiledContractsByzantium
+	}
+	if precompiles[addr] != nil {
+		return "Call"
+	}
+	return "Transfer"
+}
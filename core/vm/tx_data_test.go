@@ -0,0 +1,774 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// txDataRecord is the subset of a tx_data record checked by the tests.
+type txDataRecord struct {
+	Method          string `json:"method"`
+	To              string `json:"to"`
+	Value           int    `json:"value"`
+	Index           int    `json:"index"`
+	Parent          int    `json:"parent"`
+	Data            string `json:"data"`
+	DataTruncated   bool   `json:"dataTruncated"`
+	Selector        string `json:"selector"`
+	Output          string `json:"output"`
+	OutputTruncated bool   `json:"outputTruncated"`
+	CalleeExecuted  bool   `json:"calleeExecuted"`
+	Error           string `json:"error"`
+	OriginDepth     int    `json:"originDepth"`
+	PropagatedFrom  int    `json:"propagatedFrom"`
+	Address         string `json:"address"`
+	InputSize       int    `json:"inputSize"`
+	RequiredGas     int    `json:"requiredGas"`
+	MaxMemory       int    `json:"maxMemory"`
+	MaxStack        int    `json:"maxStack"`
+}
+
+// callOp returns the code to CALL the given address with all available gas and
+// the given value, discarding the result.
+func callOp(addr common.Address, value byte) []byte {
+	code := []byte{byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), value, byte(PUSH20)}
+	code = append(code, addr.Bytes()...)
+	return append(code, byte(GAS), byte(CALL), byte(POP))
+}
+
+// bufferRecorder is a Recorder encoding all records into an in-memory buffer.
+type bufferRecorder struct {
+	bytes.Buffer
+}
+
+func (r *bufferRecorder) Record(record map[string]interface{}) error {
+	return json.NewEncoder(&r.Buffer).Encode(record)
+}
+
+// newTxDataEVM creates an EVM on top of the given state, recording all call
+// frames with the given options into the returned buffer.
+func newTxDataEVM(statedb StateDB, config RecordConfig) (*EVM, *bytes.Buffer) {
+	var (
+		out = new(bufferRecorder)
+		ctx = Context{
+			CanTransfer: func(db StateDB, addr common.Address, amount *big.Int) bool {
+				return db.GetBalance(addr).Cmp(amount) >= 0
+			},
+			Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+			BlockNumber: new(big.Int),
+		}
+	)
+	return NewEVM(ctx, statedb, params.TestChainConfig, Config{Recorder: out, RecordConfig: config}), &out.Buffer
+}
+
+// decodeTxData parses all records written into a tx_data buffer.
+func decodeTxData(t *testing.T, out *bytes.Buffer) []txDataRecord {
+	var records []txDataRecord
+	for dec := json.NewDecoder(out); dec.More(); {
+		var record txDataRecord
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("failed to decode record: %v", err)
+		}
+		if record.To != "" {
+			record.To = common.HexToAddress(record.To).Hex()
+		}
+		if record.Address != "" {
+			record.Address = common.HexToAddress(record.Address).Hex()
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// Tests that sibling call frames at the same depth are attributed to the
+// correct parent frame.
+func TestRecordCallTree(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+		c = common.HexToAddress("0x0c")
+	)
+	statedb.SetCode(a, append(callOp(b, 0), callOp(b, 0)...))
+	statedb.SetCode(b, callOp(c, 0))
+	statedb.SetCode(c, []byte{byte(STOP)})
+
+	evm, out := newTxDataEVM(statedb, RecordConfig{})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), a, nil, 1000000, new(big.Int), true); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	want := [][2]int{{0, -1}, {1, 0}, {2, 1}, {3, 0}, {4, 3}}
+
+	have := decodeTxData(t, out)
+	if len(have) != len(want) {
+		t.Fatalf("record count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i].Index != want[i][0] || have[i].Parent != want[i][1] {
+			t.Errorf("record %d: index/parent mismatch: have %d/%d, want %d/%d", i, have[i].Index, have[i].Parent, want[i][0], want[i][1])
+		}
+	}
+}
+
+// Tests that call input and output data are truncated to the configured
+// maximum length.
+func TestRecordDataTruncation(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	addr := common.HexToAddress("0x0a")
+	statedb.SetCode(addr, []byte{
+		byte(PUSH4), 0xde, 0xad, 0xbe, 0xef, byte(PUSH1), 0, byte(MSTORE),
+		byte(PUSH1), 4, byte(PUSH1), 28, byte(RETURN),
+	})
+	evm, out := newTxDataEVM(statedb, RecordConfig{MaxDataLength: 2, Output: true})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), addr, []byte{1, 2, 3, 4, 5}, 1000000, new(big.Int), true); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	want := []txDataRecord{
+		{Method: "Call", To: addr.Hex(), Index: 0, Parent: -1, Data: "0x0102", DataTruncated: true, Selector: "0x01020304", CalleeExecuted: true},
+		{Method: "Return", Index: 0, Output: "0xdead", OutputTruncated: true},
+	}
+	have := decodeTxData(t, out)
+	if len(have) != len(want) {
+		t.Fatalf("record count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("record %d mismatch: have %+v, want %+v", i, have[i], want[i])
+		}
+	}
+}
+
+// Tests that internal value transfers and self-destructs are recorded with
+// their own methods.
+func TestRecordValueMovements(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+		c = common.HexToAddress("0x0c")
+	)
+	statedb.SetCode(a, append(append(callOp(b, 1), byte(PUSH20)), append(c.Bytes(), byte(SELFDESTRUCT))...))
+	statedb.SetBalance(a, big.NewInt(10))
+	statedb.CreateAccount(b)
+
+	evm, out := newTxDataEVM(statedb, RecordConfig{})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), a, nil, 1000000, new(big.Int), true); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	want := []txDataRecord{
+		{Method: "Call", To: a.Hex(), Index: 0, Parent: -1, Data: "0x", CalleeExecuted: true},
+		{Method: "Transfer", To: b.Hex(), Value: 1, Index: 1, Parent: 0, Data: "0x", CalleeExecuted: true},
+		{Method: "Selfdestruct", To: c.Hex(), Value: 10, Parent: 0},
+	}
+	have := decodeTxData(t, out)
+	if len(have) != len(want) {
+		t.Fatalf("record count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("record %d mismatch: have %+v, want %+v", i, have[i], want[i])
+		}
+	}
+}
+
+// Tests that call frames beyond the configured count and depth limits are left
+// out of the records, marking the transaction as truncated.
+func TestRecordFrameLimits(t *testing.T) {
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+		c = common.HexToAddress("0x0c")
+	)
+	tests := []struct {
+		config    RecordConfig
+		want      [][2]int
+		truncated bool
+	}{
+		{RecordConfig{}, [][2]int{{0, -1}, {1, 0}, {2, 1}, {3, 0}, {4, 3}}, false},
+		{RecordConfig{MaxFrames: 5}, [][2]int{{0, -1}, {1, 0}, {2, 1}, {3, 0}, {4, 3}}, false},
+		{RecordConfig{MaxFrames: 2}, [][2]int{{0, -1}, {1, 0}}, true},
+		{RecordConfig{MaxDepth: 2}, [][2]int{{0, -1}, {1, 0}, {2, 0}}, true},
+		{RecordConfig{MaxFrames: 2, MaxDepth: 1}, [][2]int{{0, -1}}, true},
+	}
+	for i, tt := range tests {
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+		statedb.SetCode(a, append(callOp(b, 0), callOp(b, 0)...))
+		statedb.SetCode(b, callOp(c, 0))
+		statedb.SetCode(c, []byte{byte(STOP)})
+
+		evm, out := newTxDataEVM(statedb, tt.config)
+		if _, _, err := evm.Call(AccountRef(common.Address{}), a, nil, 1000000, new(big.Int), true); err != nil {
+			t.Fatalf("test %d: call failed: %v", i, err)
+		}
+		if evm.RecordTruncated() != tt.truncated {
+			t.Errorf("test %d: truncation mismatch: have %v, want %v", i, evm.RecordTruncated(), tt.truncated)
+		}
+		have := decodeTxData(t, out)
+		if len(have) != len(tt.want) {
+			t.Errorf("test %d: record count mismatch: have %d, want %d", i, len(have), len(tt.want))
+			continue
+		}
+		for j := range tt.want {
+			if have[j].Index != tt.want[j][0] || have[j].Parent != tt.want[j][1] {
+				t.Errorf("test %d, record %d: index/parent mismatch: have %d/%d, want %d/%d", i, j, have[j].Index, have[j].Parent, tt.want[j][0], tt.want[j][1])
+			}
+		}
+	}
+}
+
+// Tests that calls failing before their callee executed are recorded as such.
+func TestRecordRejectedCalls(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+	)
+	// Call b without having the value to transfer, then call it without value
+	statedb.SetCode(a, append(callOp(b, 1), callOp(b, 0)...))
+	statedb.SetCode(b, []byte{byte(STOP)})
+
+	evm, out := newTxDataEVM(statedb, RecordConfig{Output: true})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), a, nil, 1000000, new(big.Int), true); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	want := []txDataRecord{
+		{Method: "Call", To: a.Hex(), Index: 0, Parent: -1, Data: "0x", CalleeExecuted: true},
+		{Method: "Call", To: b.Hex(), Value: 1, Index: 1, Parent: 0, Data: "0x", Error: ErrInsufficientBalance.Error()},
+		{Method: "Call", To: b.Hex(), Index: 2, Parent: 0, Data: "0x", CalleeExecuted: true},
+		{Method: "Return", Index: 2, Output: "0x"},
+		{Method: "Return", Index: 0, Output: "0x"},
+	}
+	have := decodeTxData(t, out)
+	if len(have) != len(want) {
+		t.Fatalf("record count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("record %d mismatch: have %+v, want %+v", i, have[i], want[i])
+		}
+	}
+}
+
+// Tests that failed frames are recorded along with the frame their failure
+// originated at.
+func TestRecordFailurePropagation(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+		c = common.HexToAddress("0x0c")
+		d = common.HexToAddress("0x0d")
+		e = common.HexToAddress("0x0e")
+
+		revert = []byte{byte(PUSH1), 0, byte(PUSH1), 0, byte(REVERT)}
+	)
+	// a bubbles up the failure of b, which bubbles up the one of c
+	statedb.SetCode(a, append(callOp(b, 0), revert...))
+	statedb.SetCode(b, append(callOp(c, 0), revert...))
+	statedb.SetCode(c, revert)
+
+	// e calls d successfully after the failure of b, so it fails on its own
+	statedb.SetCode(e, append(append(callOp(b, 0), callOp(d, 0)...), revert...))
+	statedb.SetCode(d, []byte{byte(STOP)})
+
+	reverted := errExecutionReverted.Error()
+	tests := []struct {
+		addr common.Address
+		want []txDataRecord
+	}{
+		{a, []txDataRecord{
+			{Method: "Return", Index: 2, Error: reverted, OriginDepth: 3, PropagatedFrom: -1},
+			{Method: "Return", Index: 1, Error: reverted, OriginDepth: 3, PropagatedFrom: 2},
+			{Method: "Return", Index: 0, Error: reverted, OriginDepth: 3, PropagatedFrom: 1},
+		}},
+		{e, []txDataRecord{
+			{Method: "Return", Index: 2, Error: reverted, OriginDepth: 3, PropagatedFrom: -1},
+			{Method: "Return", Index: 1, Error: reverted, OriginDepth: 3, PropagatedFrom: 2},
+			{Method: "Return", Index: 0, Error: reverted, OriginDepth: 1, PropagatedFrom: -1},
+		}},
+	}
+	for i, tt := range tests {
+		evm, out := newTxDataEVM(statedb, RecordConfig{})
+		if _, _, err := evm.Call(AccountRef(common.Address{}), tt.addr, nil, 1000000, new(big.Int), true); err != errExecutionReverted {
+			t.Fatalf("test %d: error mismatch: have %v, want %v", i, err, errExecutionReverted)
+		}
+		var have []txDataRecord
+		for _, record := range decodeTxData(t, out) {
+			if record.Method == "Return" {
+				have = append(have, record)
+			}
+		}
+		if len(have) != len(tt.want) {
+			t.Fatalf("test %d: return count mismatch: have %d, want %d", i, len(have), len(tt.want))
+		}
+		for j := range tt.want {
+			if have[j] != tt.want[j] {
+				t.Errorf("test %d: return %d mismatch: have %+v, want %+v", i, j, have[j], tt.want[j])
+			}
+		}
+	}
+}
+
+// Tests that executions of precompiled contracts are recorded along with the
+// failure they reported.
+func TestRecordPrecompiles(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a        = common.HexToAddress("0x0a")
+		identity = common.BytesToAddress([]byte{4})
+		pairing  = common.BytesToAddress([]byte{8})
+	)
+	statedb.SetCode(a, callOp(identity, 0))
+
+	evm, out := newTxDataEVM(statedb, RecordConfig{})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), a, nil, 1000000, new(big.Int), true); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if _, _, err := evm.Call(AccountRef(common.Address{}), pairing, []byte{1}, 1000000, new(big.Int), true); err != errBadPairingInput {
+		t.Fatalf("pairing error mismatch: have %v, want %v", err, errBadPairingInput)
+	}
+	want := []txDataRecord{
+		{Method: "Precompile", Address: identity.Hex(), Parent: 1, RequiredGas: int(params.IdentityBaseGas)},
+		{Method: "Precompile", Address: pairing.Hex(), Parent: 2, InputSize: 1, RequiredGas: int(params.Bn256PairingBaseGas), Error: errBadPairingInput.Error()},
+	}
+	var have []txDataRecord
+	for _, record := range decodeTxData(t, out) {
+		if record.Method == "Precompile" {
+			have = append(have, record)
+		}
+	}
+	if len(have) != len(want) {
+		t.Fatalf("record count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("record %d mismatch: have %+v, want %+v", i, have[i], want[i])
+		}
+	}
+}
+
+// Tests that frames are linked to the code they executed, which is recorded
+// once per transaction.
+func TestRecordCode(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+	)
+	statedb.SetCode(a, append(callOp(b, 0), callOp(b, 0)...))
+	statedb.SetCode(b, []byte{byte(STOP)})
+
+	evm, out := newTxDataEVM(statedb, RecordConfig{Code: true})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), a, nil, 1000000, new(big.Int), true); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	type codeRecord struct {
+		Method   string        `json:"method"`
+		CodeHash *common.Hash  `json:"codeHash"`
+		Code     hexutil.Bytes `json:"code"`
+	}
+	var have []codeRecord
+	for dec := json.NewDecoder(out); dec.More(); {
+		var record codeRecord
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("failed to decode record: %v", err)
+		}
+		have = append(have, record)
+	}
+	hash := func(addr common.Address) *common.Hash {
+		hash := statedb.GetCodeHash(addr)
+		return &hash
+	}
+	want := []codeRecord{
+		{"Call", hash(a), nil},
+		{"Code", hash(a), statedb.GetCode(a)},
+		{"Call", hash(b), nil},
+		{"Code", hash(b), statedb.GetCode(b)},
+		{"Call", hash(b), nil},
+	}
+	if len(have) != len(want) {
+		t.Fatalf("record count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i].Method != want[i].Method || (have[i].CodeHash == nil) != (want[i].CodeHash == nil) ||
+			(have[i].CodeHash != nil && *have[i].CodeHash != *want[i].CodeHash) || !bytes.Equal(have[i].Code, want[i].Code) {
+			t.Errorf("record %d mismatch: have %+v, want %+v", i, have[i], want[i])
+		}
+	}
+}
+
+// Tests that the gas profile of a transaction accounts for all the gas it
+// consumed, attributing the gas passed on to calls to the callee.
+func TestRecordGasProfile(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+		c = common.HexToAddress("0x0c")
+	)
+	statedb.SetCode(a, append([]byte{byte(PUSH1), 1, byte(PUSH1), 2, byte(ADD), byte(POP)}, callOp(b, 0)...))
+	statedb.SetCode(b, []byte{byte(PUSH1), 0, byte(POP)})
+	statedb.SetCode(c, []byte{byte(JUMPDEST), byte(PUSH1), 0, byte(JUMP)})
+
+	tests := []struct {
+		addr  common.Address
+		gas   uint64
+		err   error
+		count map[string]uint64
+	}{
+		{a, 1000000, nil, map[string]uint64{"PUSH1": 8, "ADD": 1, "POP": 3, "CALL": 1, "PUSH20": 1, "GAS": 1, "STOP": 2}},
+		{c, 100, ErrOutOfGas, map[string]uint64{"JUMPDEST": 9, "PUSH1": 9, "JUMP": 9}},
+	}
+	for i, tt := range tests {
+		evm, out := newTxDataEVM(statedb, RecordConfig{GasProfile: true})
+		_, left, err := evm.Call(AccountRef(common.Address{}), tt.addr, nil, tt.gas, new(big.Int), true)
+		if err != tt.err {
+			t.Fatalf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+		out.Reset()
+		evm.RecordGasProfile()
+
+		var record struct {
+			Method string               `json:"method"`
+			Ops    map[string]opcodeGas `json:"ops"`
+		}
+		if err := json.NewDecoder(out).Decode(&record); err != nil {
+			t.Fatalf("test %d: failed to decode profile: %v", i, err)
+		}
+		var total uint64
+		for op, gas := range record.Ops {
+			if gas.Count != tt.count[op] {
+				t.Errorf("test %d: %s count mismatch: have %d, want %d", i, op, gas.Count, tt.count[op])
+			}
+			total += gas.Gas
+		}
+		if len(record.Ops) != len(tt.count) {
+			t.Errorf("test %d: profiled opcodes mismatch: have %v, want %v", i, record.Ops, tt.count)
+		}
+		if total != tt.gas-left {
+			t.Errorf("test %d: profiled gas mismatch: have %d, want %d", i, total, tt.gas-left)
+		}
+	}
+}
+
+// Tests that the memory and stack high-water marks are recorded per call frame.
+func TestRecordResources(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+	)
+	// a expands its memory to 3 words before calling b
+	statedb.SetCode(a, append([]byte{byte(PUSH1), 1, byte(PUSH1), 0x40, byte(MSTORE)}, callOp(b, 0)...))
+	statedb.SetCode(b, []byte{byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(POP), byte(POP), byte(POP)})
+
+	evm, out := newTxDataEVM(statedb, RecordConfig{Resources: true})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), a, nil, 1000000, new(big.Int), true); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	var have []txDataRecord
+	for _, record := range decodeTxData(t, out) {
+		if record.Method == "Return" {
+			have = append(have, record)
+		}
+	}
+	want := []txDataRecord{
+		{Method: "Return", Index: 1, MaxMemory: 0, MaxStack: 3},
+		{Method: "Return", Index: 0, MaxMemory: 96, MaxStack: 7},
+	}
+	if len(have) != len(want) {
+		t.Fatalf("return count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("return %d mismatch: have %+v, want %+v", i, have[i], want[i])
+		}
+	}
+}
+
+// Tests that frames are marked with the context they executed in: whether they
+// could modify the state and whose code ran on whose storage.
+func TestRecordCallContext(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+		c = common.HexToAddress("0x0c")
+		d = common.HexToAddress("0x0d")
+	)
+	// contextOp calls the given address without value, discarding the result
+	contextOp := func(op OpCode, addr common.Address) []byte {
+		code := []byte{byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH20)}
+		code = append(code, addr.Bytes()...)
+		return append(code, byte(GAS), byte(op), byte(POP))
+	}
+	// a runs the code of b on its own storage, then calls c statically which
+	// calls d within the static context
+	statedb.SetCode(a, append(contextOp(DELEGATECALL, b), contextOp(STATICCALL, c)...))
+	statedb.SetCode(b, []byte{byte(STOP)})
+	statedb.SetCode(c, callOp(d, 0))
+	statedb.SetCode(d, []byte{byte(STOP)})
+
+	evm, out := newTxDataEVM(statedb, RecordConfig{})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), a, nil, 1000000, new(big.Int), true); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	want := []struct {
+		static, delegated bool
+		code, storage     common.Address
+	}{
+		{false, false, a, a},
+		{false, true, b, a},
+		{true, false, c, c},
+		{true, false, d, d},
+	}
+	dec := json.NewDecoder(out)
+	for i := range want {
+		var record struct {
+			Static         bool           `json:"static"`
+			Delegated      bool           `json:"delegated"`
+			CodeAddress    common.Address `json:"codeAddress"`
+			StorageAddress common.Address `json:"storageAddress"`
+		}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("frame %d: failed to decode record: %v", i, err)
+		}
+		if record.Static != want[i].static || record.Delegated != want[i].delegated || record.CodeAddress != want[i].code || record.StorageAddress != want[i].storage {
+			t.Errorf("frame %d context mismatch: have %+v, want %+v", i, record, want[i])
+		}
+	}
+	if dec.More() {
+		t.Errorf("unexpected records after the frames")
+	}
+}
+
+// Tests that the frames of watched accounts are recorded in full detail, down
+// to their steps and storage writes.
+func TestRecordWatchlist(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+	)
+	// b stores 7 into slot 1 and returns two bytes
+	statedb.SetCode(a, callOp(b, 0))
+	statedb.SetCode(b, []byte{byte(PUSH1), 7, byte(PUSH1), 1, byte(SSTORE), byte(PUSH1), 2, byte(PUSH1), 0, byte(RETURN)})
+
+	evm, out := newTxDataEVM(statedb, RecordConfig{MaxDataLength: 1, Watchlist: []common.Address{b}})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), a, []byte{1, 2}, 1000000, new(big.Int), true); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	type storage struct {
+		Key, From, To common.Hash
+	}
+	var (
+		methods []string
+		ops     []string
+		write   *storage
+		output  string
+		dec     = json.NewDecoder(out)
+	)
+	for dec.More() {
+		var record struct {
+			Method        string   `json:"method"`
+			Index         int      `json:"index"`
+			DataTruncated bool     `json:"dataTruncated"`
+			Op            string   `json:"op"`
+			Storage       *storage `json:"storage"`
+			Output        string   `json:"output"`
+		}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("failed to decode record: %v", err)
+		}
+		methods = append(methods, record.Method)
+		switch record.Method {
+		case "Call":
+			if record.DataTruncated != (record.Index == 0) {
+				t.Errorf("frame %d data truncation mismatch: have %v", record.Index, record.DataTruncated)
+			}
+		case "Step":
+			if record.Index != 1 {
+				t.Errorf("step of unwatched frame %d recorded", record.Index)
+			}
+			ops = append(ops, record.Op)
+			if record.Storage != nil {
+				write = record.Storage
+			}
+		case "Return":
+			output = record.Output
+		}
+	}
+	if want := []string{"Call", "Call", "Step", "Step", "Step", "Step", "Step", "Step", "Return"}; fmt.Sprint(methods) != fmt.Sprint(want) {
+		t.Errorf("records mismatch: have %v, want %v", methods, want)
+	}
+	if want := []string{"PUSH1", "PUSH1", "SSTORE", "PUSH1", "PUSH1", "RETURN"}; fmt.Sprint(ops) != fmt.Sprint(want) {
+		t.Errorf("steps mismatch: have %v, want %v", ops, want)
+	}
+	if want := (storage{common.BigToHash(big.NewInt(1)), common.Hash{}, common.BigToHash(big.NewInt(7))}); write == nil || *write != want {
+		t.Errorf("storage write mismatch: have %+v, want %+v", write, want)
+	}
+	if output != "0x0000" {
+		t.Errorf("watched output mismatch: have %s, want 0x0000", output)
+	}
+}
+
+// Tests that the accounts and storage slots accessed by a transaction are
+// recorded, telling reads apart from writes, even within unrecorded frames.
+func TestRecordAccessList(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+		d = common.HexToAddress("0x0d")
+	)
+	// a reads slot 1, writes and reads back slot 2, queries the balance of d
+	// and calls b, which reads slot 3
+	code := []byte{
+		byte(PUSH1), 1, byte(SLOAD), byte(POP),
+		byte(PUSH1), 1, byte(PUSH1), 2, byte(SSTORE),
+		byte(PUSH1), 2, byte(SLOAD), byte(POP),
+		byte(PUSH1), 0x0d, byte(BALANCE), byte(POP),
+	}
+	statedb.SetCode(a, append(code, callOp(b, 0)...))
+	statedb.SetCode(b, []byte{byte(PUSH1), 3, byte(SLOAD), byte(POP)})
+
+	evm, out := newTxDataEVM(statedb, RecordConfig{MaxFrames: 1, AccessList: true})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), a, nil, 1000000, new(big.Int), true); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	out.Reset()
+	evm.RecordAccessList()
+
+	type slot struct {
+		Key     common.Hash `json:"key"`
+		Written bool        `json:"written"`
+	}
+	type account struct {
+		Address common.Address `json:"address"`
+		Written bool           `json:"written"`
+		Storage []slot         `json:"storage"`
+	}
+	var record struct {
+		Method   string    `json:"method"`
+		Accounts []account `json:"accounts"`
+	}
+	if err := json.NewDecoder(out).Decode(&record); err != nil {
+		t.Fatalf("failed to decode access list: %v", err)
+	}
+	want := []account{
+		{common.Address{}, true, []slot{}},
+		{a, true, []slot{{common.BigToHash(big.NewInt(1)), false}, {common.BigToHash(big.NewInt(2)), true}}},
+		{b, false, []slot{{common.BigToHash(big.NewInt(3)), false}}},
+		{d, false, []slot{}},
+	}
+	if record.Method != "AccessList" || fmt.Sprint(record.Accounts) != fmt.Sprint(want) {
+		t.Errorf("access list mismatch:\nhave %v\nwant %v", record.Accounts, want)
+	}
+}
+
+// Tests that the balances and nonces of the caller and callee are recorded with
+// every call frame, including rejected ones.
+func TestRecordCallState(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+	)
+	// a calls b with 5 wei, then with more than it has
+	statedb.SetCode(a, append(callOp(b, 5), callOp(b, 200)...))
+	statedb.SetBalance(a, big.NewInt(100))
+	statedb.SetNonce(a, 3)
+	statedb.SetBalance(b, big.NewInt(7))
+
+	evm, out := newTxDataEVM(statedb, RecordConfig{CallState: true})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), a, nil, 1000000, new(big.Int), true); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	type callState struct {
+		Index          int  `json:"index"`
+		CalleeExecuted bool `json:"calleeExecuted"`
+		FromBalance    int  `json:"fromBalance"`
+		FromNonce      int  `json:"fromNonce"`
+		ToBalance      int  `json:"toBalance"`
+		ToNonce        int  `json:"toNonce"`
+	}
+	var have []callState
+	for dec := json.NewDecoder(out); dec.More(); {
+		var record callState
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("failed to decode record: %v", err)
+		}
+		have = append(have, record)
+	}
+	want := []callState{
+		{0, true, 0, 0, 100, 3},
+		{1, true, 100, 3, 7, 0},
+		{2, false, 100, 3, 7, 0},
+	}
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("call state mismatch:\nhave %v\nwant %v", have, want)
+	}
+}
+
+// Tests that recorded error messages are parsed back into the errors producing
+// them, including those reported with details.
+func TestParseError(t *testing.T) {
+	stack := newstack()
+	errs := []error{
+		ErrOutOfGas,
+		ErrExecutionReverted,
+		&detailedError{ErrInvalidJump, "(PUSH1) 5"},
+		stack.require(1),
+		makeStackFunc(0, 1025)(stack),
+	}
+	want := []error{ErrOutOfGas, ErrExecutionReverted, ErrInvalidJump, ErrStackUnderflow, ErrStackOverflow}
+	for i, err := range errs {
+		if kind := ErrorKind(err); kind != want[i] {
+			t.Errorf("error %d: kind mismatch: have %v, want %v", i, kind, want[i])
+		}
+		if parsed := ParseError(err.Error()); parsed != want[i] {
+			t.Errorf("error %d: parsed %q into %v, want %v", i, err, parsed, want[i])
+		}
+	}
+	if err := ParseError("out of gas, really"); err != nil {
+		t.Errorf("unknown message parsed into %v", err)
+	}
+}
@@ -41,6 +41,7 @@ import (
 	"github.com/ethereum/go-ethereum/eth/gasprice"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/experiment"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/miner"
@@ -71,6 +72,7 @@ type Ethereum struct {
 	blockchain      *core.BlockChain
 	protocolManager *ProtocolManager
 	lesServer       LesServer
+	collector       *experiment.Collector // Transaction data recorder of the blockchain, closed with it, nil if partitioned
 
 	// DB interfaces
 	chainDb ethdb.Database // Block chain database
@@ -146,6 +148,10 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 		vmConfig    = vm.Config{EnablePreimageRecording: config.EnablePreimageRecording}
 		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieNodeLimit: config.TrieCache, TrieTimeLimit: config.TrieTimeout}
 	)
+	if eth.collector, err = config.Experiment.Attach(&vmConfig, chainConfig); err != nil {
+		return nil, err
+	}
+
 	eth.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, eth.chainConfig, eth.engine, vmConfig)
 	if err != nil {
 		return nil, err
@@ -156,6 +162,11 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 		eth.blockchain.SetHead(compat.RewindTo)
 		rawdb.WriteChainConfig(chainDb, genesisHash, chainConfig)
 	}
+	if eth.collector != nil {
+		if from, head := eth.collector.ResumeFrom(), eth.blockchain.CurrentBlock().NumberU64(); from > 0 && head >= from {
+			log.Warn("Chain head ahead of recorded transaction data", "recorded", from-1, "head", head)
+		}
+	}
 	eth.bloomIndexer.Start(eth.blockchain)
 
 	if config.TxPool.Journal != "" {
@@ -247,6 +258,15 @@ func (s *Ethereum) APIs() []rpc.API {
 	// Append any APIs exposed explicitly by the consensus engine
 	apis = append(apis, s.engine.APIs(s.BlockChain())...)
 
+	// Append the transaction data API, unless the records are partitioned
+	if s.collector != nil {
+		apis = append(apis, rpc.API{
+			Namespace: "experiment",
+			Version:   "1.0",
+			Service:   experiment.NewPublicExperimentAPI(s.collector, s.blockchain, s.chainDb),
+			Public:    true,
+		})
+	}
 	// Append all the local APIs and return
 	return append(apis, []rpc.API{
 		{
@@ -30,6 +30,7 @@ import (
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/eth/downloader"
 	"github.com/ethereum/go-ethereum/eth/gasprice"
+	"github.com/ethereum/go-ethereum/experiment"
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -112,6 +113,9 @@ type Config struct {
 	// Enables tracking of SHA3 preimages in the VM
 	EnablePreimageRecording bool
 
+	// Transaction data recording options
+	Experiment experiment.Config
+
 	// Miscellaneous options
 	DocRoot string `toml:"-"`
 }
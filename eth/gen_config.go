@@ -11,6 +11,7 @@ import (
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/eth/downloader"
 	"github.com/ethereum/go-ethereum/eth/gasprice"
+	"github.com/ethereum/go-ethereum/experiment"
 )
 
 var _ = (*configMarshaling)(nil)
@@ -33,6 +34,7 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		TxPool                  core.TxPoolConfig
 		GPO                     gasprice.Config
 		EnablePreimageRecording bool
+		Experiment              experiment.Config
 		DocRoot                 string `toml:"-"`
 	}
 	var enc Config
@@ -52,6 +54,7 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.TxPool = c.TxPool
 	enc.GPO = c.GPO
 	enc.EnablePreimageRecording = c.EnablePreimageRecording
+	enc.Experiment = c.Experiment
 	enc.DocRoot = c.DocRoot
 	return &enc, nil
 }
@@ -74,6 +77,7 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		TxPool                  *core.TxPoolConfig
 		GPO                     *gasprice.Config
 		EnablePreimageRecording *bool
+		Experiment              *experiment.Config
 		DocRoot                 *string `toml:"-"`
 	}
 	var dec Config
@@ -128,6 +132,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.EnablePreimageRecording != nil {
 		c.EnablePreimageRecording = *dec.EnablePreimageRecording
 	}
+	if dec.Experiment != nil {
+		c.Experiment = *dec.Experiment
+	}
 	if dec.DocRoot != nil {
 		c.DocRoot = *dec.DocRoot
 	}
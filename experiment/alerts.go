@@ -0,0 +1,166 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// webhookTimeout is the longest time an alert is attempted to be posted to a
+// webhook.
+const webhookTimeout = 10 * time.Second
+
+// AlertRule is a condition on the revert rate of transactions, e.g. "more than
+// half of the transactions calling X failed over the last 100 blocks", alerting
+// when it starts to hold.
+type AlertRule struct {
+	Name     string          `json:"name"`
+	Contract *common.Address `json:"contract"` // called or created contract, any if nil
+	Window   uint64          `json:"window"`   // number of most recent blocks the rate is computed over
+	MinTxs   uint64          `json:"minTxs"`   // least number of transactions in the window to alert on
+	MaxRate  float64         `json:"maxRate"`  // revert rate above which the rule alerts
+	Webhook  string          `json:"webhook"`  // URL alerts are posted to as JSON, only logged if empty
+}
+
+// Alert is raised when the condition of a rule starts to hold.
+type Alert struct {
+	Rule     string          `json:"rule"`
+	Block    uint64          `json:"block"` // block at which the condition started to hold
+	Txs      uint64          `json:"txs"`   // transactions in the window
+	Failed   uint64          `json:"failed"`
+	Rate     float64         `json:"rate"`
+	Contract *common.Address `json:"contract,omitempty"`
+}
+
+// LoadAlertRules reads alert rules from a JSON file holding an array of them.
+func LoadAlertRules(path string) ([]AlertRule, error) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []AlertRule
+	if err := json.Unmarshal(blob, &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.Window == 0 {
+			return nil, fmt.Errorf("alert rule %q: zero window", rule.Name)
+		}
+	}
+	return rules, nil
+}
+
+// alertCount is the number of transactions of a block matched by a rule.
+type alertCount struct {
+	number      uint64
+	txs, failed uint64
+}
+
+// alertState is a rule along with the counts of the blocks in its window.
+type alertState struct {
+	rule        AlertRule
+	counts      []alertCount // oldest first
+	txs, failed uint64       // totals of the window
+	firing      bool         // whether the condition held at the last block
+}
+
+// observe adds the transactions of a checkpointed block to the window of the
+// rule, dropping the blocks falling out of it, and reports whether the
+// condition started to hold.
+func (s *alertState) observe(number uint64, txs []viewTx) bool {
+	count := alertCount{number: number}
+	for _, tx := range txs {
+		if s.rule.Contract != nil && tx.contract != *s.rule.Contract {
+			continue
+		}
+		count.txs++
+		if tx.failed {
+			count.failed++
+		}
+	}
+	s.counts = append(s.counts, count)
+	s.txs, s.failed = s.txs+count.txs, s.failed+count.failed
+	for len(s.counts) > 0 && s.counts[0].number+s.rule.Window <= number {
+		s.txs, s.failed = s.txs-s.counts[0].txs, s.failed-s.counts[0].failed
+		s.counts = s.counts[1:]
+	}
+	holds := s.txs > 0 && s.txs >= s.rule.MinTxs && s.rate() > s.rule.MaxRate
+	started := holds && !s.firing
+	s.firing = holds
+	return started
+}
+
+// rate returns the revert rate over the window.
+func (s *alertState) rate() float64 {
+	if s.txs == 0 {
+		return 0
+	}
+	return float64(s.failed) / float64(s.txs)
+}
+
+// evaluateAlerts feeds the blocks of the pending records to the alert rules,
+// raising the alerts whose condition started to hold. Windows aren't persisted,
+// so they start empty when the run is resumed.
+func (c *Collector) evaluateAlerts(number uint64) {
+	var txs []viewTx
+	for _, block := range c.pendingViews {
+		if !block.orphaned {
+			txs = append(txs, block.txs...)
+		}
+	}
+	for _, state := range c.alerts {
+		wasFiring := state.firing
+		if state.observe(number, txs) {
+			c.raiseAlert(&Alert{Rule: state.rule.Name, Block: number, Txs: state.txs, Failed: state.failed, Rate: state.rate(), Contract: state.rule.Contract}, state.rule.Webhook)
+		} else if wasFiring && !state.firing {
+			logger.Info("Transaction failure alert resolved", "rule", state.rule.Name, "block", number, "rate", state.rate())
+		}
+	}
+}
+
+// raiseAlert logs the alert and posts it to the webhook in the background, so
+// slow endpoints don't hold up block processing.
+func (c *Collector) raiseAlert(alert *Alert, webhook string) {
+	logger.Warn("Transaction failure alert", "rule", alert.Rule, "block", alert.Block, "txs", alert.Txs, "failed", alert.Failed, "rate", alert.Rate)
+	if webhook == "" {
+		return
+	}
+	blob, err := json.Marshal(alert)
+	if err != nil {
+		logger.Error("Failed to encode alert", "rule", alert.Rule, "err", err)
+		return
+	}
+	go func() {
+		client := &http.Client{Timeout: webhookTimeout}
+		resp, err := client.Post(webhook, "application/json", bytes.NewReader(blob))
+		if err != nil {
+			logger.Warn("Failed to post alert", "rule", alert.Rule, "webhook", webhook, "err", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			logger.Warn("Alert rejected by webhook", "rule", alert.Rule, "webhook", webhook, "status", resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// anonymizedPayloads are the record fields carrying call data, return data,
+// bytecode or stack items, dropped from anonymized records. The flag marking
+// the field as truncated is set instead, if it has one.
+var anonymizedPayloads = map[string]string{
+	"data":   "dataTruncated",
+	"output": "outputTruncated",
+	"code":   "",
+	"stack":  "",
+}
+
+// anonymizedQuantities are the record fields holding amounts of wei or gas and
+// nonces, rounded down to a power of two in anonymized records so they can't be
+// matched against the exact amounts of the chain.
+var anonymizedQuantities = map[string]bool{
+	"value": true, "balance": true, "fromBalance": true, "toBalance": true, "gasPrice": true,
+	"gasUsed": true, "cumulativeGasUsed": true, "altGasUsed": true, "nonce": true, "fromNonce": true, "toNonce": true,
+}
+
+const (
+	anonymizedBlocks = 1000  // span block numbers are rounded down to in anonymized records
+	anonymizedTime   = 86400 // span timestamps are rounded down to in anonymized records, in seconds
+)
+
+// anonymizer replaces the accounts, transactions, blocks, code, selectors and
+// storage of records by pseudonyms keyed by a secret of the run: the same value
+// always gets the same pseudonym within a run, but pseudonyms can't be linked
+// to the values of the chain without the key. Block numbers, timestamps and
+// quantities are coarsened. Precompiled contracts keep their well-known
+// addresses, empty addresses and hashes stay empty.
+type anonymizer struct {
+	key []byte
+}
+
+// address returns the pseudonym of an account.
+func (a *anonymizer) address(addr common.Address) common.Address {
+	if addr == (common.Address{}) || vm.PrecompiledContractsByzantium[addr] != nil {
+		return addr
+	}
+	return common.BytesToAddress(crypto.Keccak256(a.key, addr[:]))
+}
+
+// hash returns the pseudonym of a transaction, block, code or storage hash.
+func (a *anonymizer) hash(hash common.Hash) common.Hash {
+	if hash == (common.Hash{}) {
+		return hash
+	}
+	return crypto.Keccak256Hash(a.key, hash[:])
+}
+
+// coarsen rounds a quantity down to a power of two.
+func coarsen(quantity interface{}) interface{} {
+	switch quantity := quantity.(type) {
+	case *big.Int:
+		if quantity == nil || quantity.Sign() <= 0 {
+			return quantity
+		}
+		return new(big.Int).Lsh(common.Big1, uint(quantity.BitLen()-1))
+	case uint64:
+		if quantity == 0 {
+			return quantity
+		}
+		return uint64(1) << uint(new(big.Int).SetUint64(quantity).BitLen()-1)
+	}
+	return quantity
+}
+
+// truncate rounds a block number or timestamp down to a multiple of span.
+func truncate(number interface{}, span int64) interface{} {
+	switch number := number.(type) {
+	case *big.Int:
+		if number == nil {
+			return number
+		}
+		return new(big.Int).Sub(number, new(big.Int).Mod(number, big.NewInt(span)))
+	case uint64:
+		return number - number%uint64(span)
+	}
+	return number
+}
+
+// record returns a copy of the record with all accounts and hashes
+// pseudonymized, the quantities coarsened and the payloads dropped.
+func (a *anonymizer) record(record map[string]interface{}) map[string]interface{} {
+	anonymized := make(map[string]interface{}, len(record))
+	for key, value := range record {
+		if _, ok := anonymizedPayloads[key]; ok {
+			continue
+		}
+		switch {
+		case anonymizedQuantities[key]:
+			anonymized[key] = coarsen(value)
+		case key == "block":
+			anonymized[key] = truncate(value, anonymizedBlocks)
+		case key == "timestamp":
+			anonymized[key] = truncate(value, anonymizedTime)
+		case key == "selector":
+			if selector, ok := value.(hexutil.Bytes); ok && selector != nil {
+				value = hexutil.Bytes(crypto.Keccak256(a.key, selector)[:len(selector)])
+			}
+			anonymized[key] = value
+		default:
+			anonymized[key] = a.value(value)
+		}
+	}
+	for payload, flag := range anonymizedPayloads {
+		if _, ok := record[payload]; ok && flag != "" {
+			anonymized[flag] = true
+		}
+	}
+	return anonymized
+}
+
+// value pseudonymizes the accounts and hashes within a field of a record.
+func (a *anonymizer) value(value interface{}) interface{} {
+	switch value := value.(type) {
+	case common.Hash:
+		return a.hash(value)
+	case *common.Hash:
+		if value == nil {
+			return value
+		}
+		hash := a.hash(*value)
+		return &hash
+	case common.Address:
+		return a.address(value)
+	case *common.Address:
+		if value == nil {
+			return value
+		}
+		addr := a.address(*value)
+		return &addr
+	case []common.Hash:
+		hashes := make([]common.Hash, len(value))
+		for i, hash := range value {
+			hashes[i] = a.hash(hash)
+		}
+		return hashes
+	case []common.Address:
+		addrs := make([]common.Address, len(value))
+		for i, addr := range value {
+			addrs[i] = a.address(addr)
+		}
+		return addrs
+	case map[string]interface{}:
+		return a.record(value)
+	case []map[string]interface{}:
+		records := make([]map[string]interface{}, len(value))
+		for i, record := range value {
+			records[i] = a.record(record)
+		}
+		return records
+	}
+	return value
+}
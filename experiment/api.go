@@ -0,0 +1,218 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// exceptionBuffer is the number of failed transactions buffered for an RPC
+// subscriber before further ones are dropped.
+const exceptionBuffer = 256
+
+// latencySamples is the number of most recent checkpoints the latency
+// percentiles of the status are computed over.
+const latencySamples = 1024
+
+// latencies is a ring of the durations of the most recent checkpoints.
+type latencies struct {
+	samples []int64
+	next    int
+}
+
+// add records the duration of a checkpoint, overwriting the oldest one if the
+// ring is full.
+func (l *latencies) add(d time.Duration) {
+	if len(l.samples) < latencySamples {
+		l.samples = append(l.samples, int64(d))
+		return
+	}
+	l.samples[l.next] = int64(d)
+	l.next = (l.next + 1) % latencySamples
+}
+
+// Latency is the distribution of the time taken to write checkpoints, in
+// nanoseconds.
+type Latency struct {
+	P50 hexutil.Uint64 `json:"p50"`
+	P95 hexutil.Uint64 `json:"p95"`
+	P99 hexutil.Uint64 `json:"p99"`
+	Max hexutil.Uint64 `json:"max"`
+}
+
+// Status describes the state of a recording run.
+type Status struct {
+	Path       string          `json:"path"`
+	RunID      string          `json:"runId"`
+	Config     vm.RecordConfig `json:"config"`
+	Codec      string          `json:"codec,omitempty"`
+	ResumeFrom hexutil.Uint64  `json:"resumeFrom"` // first block not recorded yet
+	Hash       *common.Hash    `json:"hash"`       // last recorded block, nil if none
+	Size       hexutil.Uint64  `json:"size"`       // size of the record file at the last checkpoint
+	Pending    hexutil.Uint64  `json:"pending"`    // size of the records not checkpointed yet
+	Buffered   hexutil.Uint64  `json:"buffered"`   // number of the records not checkpointed yet
+	Written    hexutil.Uint64  `json:"written"`    // records checkpointed since the node started
+	Failures   hexutil.Uint64  `json:"failures"`   // records and checkpoints failed to be written
+	Latency    *Latency        `json:"latency"`    // of recent checkpoints, nil if none
+	DryRun     bool            `json:"dryRun"`
+	Violations hexutil.Uint64  `json:"violations"` // invalid records found in a dry run
+}
+
+// Status returns the current state of the recording run.
+func (c *Collector) Status() Status {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	status := Status{
+		Path:       c.path,
+		RunID:      c.progress.RunID,
+		Config:     c.config,
+		Codec:      c.codec,
+		Size:       hexutil.Uint64(c.progress.Offset),
+		Pending:    hexutil.Uint64(c.pending.Len()),
+		Buffered:   hexutil.Uint64(c.pendingStats.records),
+		Written:    hexutil.Uint64(c.written),
+		Failures:   hexutil.Uint64(c.failures),
+		DryRun:     c.dryRun,
+		Violations: hexutil.Uint64(c.violations),
+	}
+	if c.checkpointed {
+		hash := c.progress.Hash
+		status.ResumeFrom, status.Hash = hexutil.Uint64(c.progress.Number+1), &hash
+	}
+	if len(c.latencies.samples) > 0 {
+		// SamplePercentiles sorts in place, keep the ring intact
+		samples := append([]int64(nil), c.latencies.samples...)
+		ps := metrics.SamplePercentiles(samples, []float64{0.5, 0.95, 0.99})
+		status.Latency = &Latency{
+			P50: hexutil.Uint64(ps[0]),
+			P95: hexutil.Uint64(ps[1]),
+			P99: hexutil.Uint64(ps[2]),
+			Max: hexutil.Uint64(metrics.SampleMax(samples)),
+		}
+	}
+	return status
+}
+
+// PublicExperimentAPI offers information about the recording of transaction
+// data through the experiment RPC namespace.
+type PublicExperimentAPI struct {
+	collector *Collector
+	chain     Chain                // chain replayed transactions are looked up on, nil if unavailable
+	db        rawdb.DatabaseReader // database holding the transaction lookup entries
+}
+
+// NewPublicExperimentAPI creates a new RPC service for the given collector,
+// replaying transactions of the given chain.
+func NewPublicExperimentAPI(collector *Collector, chain Chain, db rawdb.DatabaseReader) *PublicExperimentAPI {
+	return &PublicExperimentAPI{collector, chain, db}
+}
+
+// Status returns the state of the recording run, for monitoring long runs.
+func (api *PublicExperimentAPI) Status() Status {
+	return api.collector.Status()
+}
+
+// ReplayTx re-executes a historical transaction with the recording configuration
+// of the run, returning its records without writing them to the record file.
+func (api *PublicExperimentAPI) ReplayTx(hash common.Hash) (*Transaction, error) {
+	if api.chain == nil {
+		return nil, errors.New("no chain to replay transactions on")
+	}
+	return ReplayTx(api.chain, api.db, hash, api.collector.Status().Config)
+}
+
+// ReplayCounterfactual replays a historical transaction both as it was executed
+// and with the given parameters overridden, e.g. to find out whether it would
+// have succeeded with more gas.
+func (api *PublicExperimentAPI) ReplayCounterfactual(hash common.Hash, overrides Overrides) (*Counterfactual, error) {
+	if api.chain == nil {
+		return nil, errors.New("no chain to replay transactions on")
+	}
+	return ReplayCounterfactual(api.chain, api.db, hash, overrides, api.collector.Status().Config)
+}
+
+// Views returns the aggregates of the recorded transactions, or nil if they
+// aren't maintained.
+func (api *PublicExperimentAPI) Views() *Views {
+	return api.collector.Views()
+}
+
+// Hotspots returns the n contracts and functions called by the most failed
+// transactions, or nil if they aren't tracked.
+func (api *PublicExperimentAPI) Hotspots(n int) *Hotspots {
+	return api.collector.Hotspots(n)
+}
+
+// ExceptionFilter restricts the failed transactions announced to a subscriber.
+type ExceptionFilter struct {
+	Contracts []common.Address `json:"contracts"` // called or created contracts, any if empty
+}
+
+// matches reports whether the failed transaction passes the filter.
+func (f *ExceptionFilter) matches(exception *Exception) bool {
+	if f == nil || len(f.Contracts) == 0 {
+		return true
+	}
+	for _, contract := range f.Contracts {
+		if contract == exception.To {
+			return true
+		}
+	}
+	return false
+}
+
+// Exceptions creates a subscription notified of the failed transactions of
+// every recorded block, optionally only those calling the given contracts.
+func (api *PublicExperimentAPI) Exceptions(ctx context.Context, filter *ExceptionFilter) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		exceptions := make(chan *Exception, exceptionBuffer)
+		exceptionsSub := api.collector.SubscribeExceptions(exceptions)
+		defer exceptionsSub.Unsubscribe()
+
+		for {
+			select {
+			case exception := <-exceptions:
+				if filter.matches(exception) {
+					notifier.Notify(rpcSub.ID, exception)
+				}
+			case <-exceptionsSub.Err():
+				return
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
@@ -0,0 +1,907 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package experiment collects records describing the execution of the
+// processed transactions (call frames, account states, system operations)
+// into a JSON-lines file.
+package experiment
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// DefaultPath is the file records are written into if not configured otherwise.
+const DefaultPath = "tx_data"
+
+// header is the first line of every record file.
+const header = "Recording Tx Information\n"
+
+// codecGzip is the codec of record files compressed with gzip.
+const codecGzip = "gzip"
+
+// statsReportLimit is the time limit during recording after which the progress
+// of the run is reported.
+const statsReportLimit = 8 * time.Second
+
+// logger tags all messages of the recording with the experiment module.
+var logger = log.New("module", "experiment")
+
+// Option is a configuration option of a Collector.
+type Option func(*Collector)
+
+// WithPath sets the file the records are written into.
+func WithPath(path string) Option {
+	return func(c *Collector) { c.path = path }
+}
+
+// WithMaxDataLength limits the number of input and output bytes recorded per
+// call frame.
+func WithMaxDataLength(length int) Option {
+	return func(c *Collector) { c.config.MaxDataLength = length }
+}
+
+// WithMaxFrames limits the number of call frames recorded per transaction.
+func WithMaxFrames(frames int) Option {
+	return func(c *Collector) { c.config.MaxFrames = frames }
+}
+
+// WithMaxDepth limits the nesting depth of the recorded call frames.
+func WithMaxDepth(depth int) Option {
+	return func(c *Collector) { c.config.MaxDepth = depth }
+}
+
+// WithOutput enables recording the return data of every call frame.
+func WithOutput() Option {
+	return func(c *Collector) { c.config.Output = true }
+}
+
+// WithAccountState enables recording the state of the accounts involved in a
+// transaction before and after its execution.
+func WithAccountState() Option {
+	return func(c *Collector) { c.config.AccountState = true }
+}
+
+// WithCode enables recording the bytecode of every executed contract, once per
+// run. Call frames are linked to the code they executed by hash regardless.
+func WithCode() Option {
+	return func(c *Collector) { c.config.Code = true }
+}
+
+// WithGasProfile enables recording the gas consumed per opcode by every
+// transaction.
+func WithGasProfile() Option {
+	return func(c *Collector) { c.config.GasProfile = true }
+}
+
+// WithResources enables recording the memory and stack high-water marks of
+// every call frame.
+func WithResources() Option {
+	return func(c *Collector) { c.config.Resources = true }
+}
+
+// WithRejections enables recording the transactions rejected before their
+// execution, along with the reason. Such transactions make their block
+// invalid, so their records are marked as orphaned.
+func WithRejections() Option {
+	return func(c *Collector) { c.config.Rejections = true }
+}
+
+// WithAccessList enables recording the accounts and storage slots accessed by
+// every transaction, telling reads apart from writes.
+func WithAccessList() Option {
+	return func(c *Collector) { c.config.AccessList = true }
+}
+
+// WithCallState enables recording the balances and nonces of the caller and
+// callee of every call frame as it begins.
+func WithCallState() Option {
+	return func(c *Collector) { c.config.CallState = true }
+}
+
+// WithRefunds enables recording the refund counter of every call frame as it
+// begins and returns, and the refund of every transaction along with whether it
+// was capped to half the gas used.
+func WithRefunds() Option {
+	return func(c *Collector) { c.config.Refunds = true }
+}
+
+// WithLogs enables recording the logs emitted by every transaction along with
+// its receipt, e.g. to decode the token transfer events.
+func WithLogs() Option {
+	return func(c *Collector) { c.config.Logs = true }
+}
+
+// WithFaultContext enables recording the context of the operation a call frame
+// faults at: the given number of items on top of the stack and the code around
+// the operation, along with the destination of invalid jumps and the valid
+// destinations nearest to it.
+func WithFaultContext(stackItems int) Option {
+	return func(c *Collector) { c.config.FaultStack = stackItems }
+}
+
+// WithDifferential executes every transaction under the given chain
+// configuration too (e.g. with a fork rule disabled or an alternative gas
+// table), recording the outcomes of the transactions where they diverge.
+func WithDifferential(config *params.ChainConfig) Option {
+	return func(c *Collector) { c.config.Differential = config }
+}
+
+// WithWatchlist records the frames of the given accounts in full detail: their
+// data and output are never truncated, and every executed step is recorded
+// along with the storage slots it writes.
+func WithWatchlist(addrs ...common.Address) Option {
+	return func(c *Collector) { c.config.Watchlist = append(c.config.Watchlist, addrs...) }
+}
+
+// WithCompression compresses the record file with gzip. Every checkpointed
+// block is written as a separate gzip member, so the file can be truncated
+// back to any checkpoint when resuming.
+func WithCompression() Option {
+	return func(c *Collector) { c.codec = codecGzip }
+}
+
+// WithIndex maintains an index of the record file, locating the records of
+// every transaction by hash, next to it.
+func WithIndex() Option {
+	return func(c *Collector) { c.indexed = true }
+}
+
+// WithSparse only keeps a bloom filter of the contracts, functions and errors of
+// the failed transactions of every block, instead of their records, locating
+// the blocks worth recording in full for a query at a fraction of the size.
+// Block summaries and statuses are still kept.
+func WithSparse() Option {
+	return func(c *Collector) { c.sparse = true }
+}
+
+// WithViews maintains aggregates of the recorded transactions (daily exception
+// counts, per-contract revert rates, gas wasted by failed transactions) as
+// blocks are checkpointed, persisted next to the record file.
+func WithViews() Option {
+	return func(c *Collector) { c.views = newViews() }
+}
+
+// WithHotspots tracks the contracts and functions called by the most failed
+// transactions, keeping the given number of candidates of each. The counts are
+// approximate, but take constant memory. They are persisted at most once per
+// interval, DefaultHotspotsInterval if zero.
+func WithHotspots(capacity int, interval time.Duration) Option {
+	if interval == 0 {
+		interval = DefaultHotspotsInterval
+	}
+	return func(c *Collector) {
+		c.hotspots = &hotspots{contracts: newSpaceSaving(capacity), functions: newSpaceSaving(capacity), interval: interval}
+	}
+}
+
+// WithAlerts raises an alert, logged and posted to the webhook of the rule if
+// any, whenever the condition of one of the rules starts to hold.
+func WithAlerts(rules ...AlertRule) Option {
+	return func(c *Collector) {
+		for _, rule := range rules {
+			c.alerts = append(c.alerts, &alertState{rule: rule})
+		}
+	}
+}
+
+// WithChainConfig stores the configuration of the recorded chain along with the
+// run, so records can be interpreted later on.
+func WithChainConfig(config *params.ChainConfig) Option {
+	return func(c *Collector) { c.chainConfig = config }
+}
+
+// WithSync flushes the record file and its index to stable storage before every
+// checkpoint is persisted, and the progress itself before it replaces the old
+// one. Slower, but a power failure can't lose checkpointed records.
+func WithSync() Option {
+	return func(c *Collector) { c.sync = true }
+}
+
+// WithStartBlock only records the blocks from the given number on. Records of
+// earlier blocks are dropped and the blocks are not checkpointed.
+func WithStartBlock(number uint64) Option {
+	return func(c *Collector) { c.start = number }
+}
+
+// WithEndBlock only records the blocks up to the given number (included).
+// Records of later blocks are dropped and the blocks are not checkpointed.
+func WithEndBlock(number uint64) Option {
+	return func(c *Collector) { c.end = number }
+}
+
+// WithOnlyAfterFork only records the blocks from the activation of the given
+// fork on (e.g. "byzantium"), as scheduled by the chain configuration set with
+// WithChainConfig. It overrides any earlier start block.
+func WithOnlyAfterFork(fork string) Option {
+	return func(c *Collector) { c.fork = fork }
+}
+
+// forkBlock returns the block the named fork is activated at in the chain
+// configuration, or nil if it is unknown or not scheduled.
+func forkBlock(config *params.ChainConfig, fork string) *big.Int {
+	switch fork {
+	case "homestead":
+		return config.HomesteadBlock
+	case "dao":
+		return config.DAOForkBlock
+	case "eip150", "tangerinewhistle":
+		return config.EIP150Block
+	case "eip155":
+		return config.EIP155Block
+	case "eip158", "spuriousdragon":
+		return config.EIP158Block
+	case "byzantium":
+		return config.ByzantiumBlock
+	case "constantinople":
+		return config.ConstantinopleBlock
+	}
+	return nil
+}
+
+// WithoutFields drops the given fields (e.g. "error", "value") from every record
+// written, to tune the size of the records. Only top level fields are dropped,
+// fields a record doesn't have are skipped. The fields the records are grouped
+// and linked by can't be dropped.
+func WithoutFields(fields ...string) Option {
+	return func(c *Collector) { c.dropped = append(c.dropped, fields...) }
+}
+
+// requiredFields are the fields the reader groups and links the records by.
+var requiredFields = map[string]bool{
+	"method": true, "tx": true, "block": true, "blockHash": true, "index": true, "parent": true,
+}
+
+// WithAnonymization replaces the accounts, hashes, selectors and storage in the
+// records by pseudonyms, rounds block numbers, timestamps and quantities down
+// and drops call data, return data, bytecode and stack items, so records can't
+// be matched with the transactions of the chain. Pseudonyms are consistent
+// within a run; the key deriving them is kept in the progress of the run, which
+// must not be shared along with the records. Records keep the order of the
+// chain, so records of a sparse activity may still be told apart by their
+// position.
+func WithAnonymization() Option {
+	return func(c *Collector) { c.anonymize = true }
+}
+
+// WithDryRun validates the records against the constraints of the record
+// format instead of writing them, reporting every violation. No file is
+// written, so nothing can be resumed either.
+func WithDryRun() Option {
+	return func(c *Collector) { c.dryRun = true }
+}
+
+// WithResume continues the run checkpointed next to an existing record file
+// instead of starting a new one. Records written after the last checkpoint are
+// dropped. Without a checkpoint a new run is started.
+func WithResume() Option {
+	return func(c *Collector) { c.resume = true }
+}
+
+// progress is the checkpoint of a run, persisted next to its record file.
+type progress struct {
+	RunID       string              `json:"runId"`
+	ConfigHash  common.Hash         `json:"configHash"`
+	Config      vm.RecordConfig     `json:"config"`
+	ChainConfig *params.ChainConfig `json:"chainConfig,omitempty"`
+	Versions    []string            `json:"versions"`        // client versions which recorded the run, in order
+	Start       uint64              `json:"start"`           // first recorded block
+	Codec       string              `json:"codec,omitempty"` // compression of the record file, if any
+	Number      uint64              `json:"number"`          // last fully recorded block
+	Hash        common.Hash         `json:"hash"`
+	Offset      int64               `json:"offset"`               // size of the record file at the checkpoint
+	Indexed     bool                `json:"indexed,omitempty"`    // whether the record file is indexed
+	IndexSize   int64               `json:"indexSize,omitempty"`  // size of the index file at the checkpoint
+	Clean       bool                `json:"clean,omitempty"`      // whether the last session was closed
+	Pseudonyms  hexutil.Bytes       `json:"pseudonyms,omitempty"` // key of the pseudonyms, if anonymized
+	Dropped     []string            `json:"dropped,omitempty"`    // fields dropped from the records, sorted
+}
+
+// Collector writes the records produced during transaction execution into a
+// file. It implements vm.Recorder and vm.Checkpointer: the records of a block
+// are kept in memory until the block is checkpointed, so the file and the
+// progress of the run stay consistent if the node is interrupted.
+type Collector struct {
+	path        string
+	config      vm.RecordConfig
+	codec       string
+	indexed     bool
+	sparse      bool // whether only the blooms of the blocks are kept
+	chainConfig *params.ChainConfig
+	resume      bool
+	dryRun      bool
+	sync        bool   // whether checkpoints are flushed to stable storage
+	start, end  uint64 // range of recorded blocks, both ends included
+	fork        string // fork the recording starts at, if any
+	anonymize   bool
+	anonymizer  *anonymizer // nil unless the records are anonymized
+	dropped     []string    // fields dropped from the records
+
+	file         *os.File
+	index        *os.File             // index of the record file, nil if not indexed
+	pending      bytes.Buffer         // records since the last checkpoint
+	pendingIndex []indexEntry         // index entries of the pending records
+	lastTx       common.Hash          // last transaction added to the pending index
+	codes        map[common.Hash]bool // hashes of the code recorded in the run
+	pendingCodes map[common.Hash]bool // hashes of the code in the pending records
+	pendingSide  map[common.Hash]bool // blocks marked as orphaned in the pending records
+	enc          *json.Encoder
+	progress     progress
+	checkpointed bool   // whether progress holds a checkpointed block
+	resumed      uint64 // first block not recorded before the run was resumed
+	validator    validator
+	violations   uint64      // number of invalid records found in a dry run
+	pendingStats recordStats // records of the block being recorded
+	written      uint64      // records written into the file in this session
+	failures     uint64      // records and checkpoints failed to be written
+	latencies    latencies
+	stats        recordStats // recorded since the last progress report
+
+	exception         *Exception                 // summary of the transaction being recorded
+	pendingExceptions []*Exception               // failed transactions in the pending records
+	exceptionSubs     map[*exceptionSub]struct{} // subscribers to the failed transactions
+	subLock           sync.Mutex                 // protects exceptionSubs, not held with lock
+	scope             event.SubscriptionScope
+
+	views        *Views                     // aggregates of the recorded transactions, nil unless maintained
+	viewTx       viewTx                     // outcome of the transaction being recorded
+	pendingViews map[common.Hash]*blockView // blocks in the pending records
+	recentViews  map[common.Hash]*blockView // checkpointed blocks which may still be reorged
+	viewsSaved   time.Time                  // time the views were last persisted
+	hotspots     *hotspots                  // nil unless tracked
+	alerts       []*alertState              // rules alerted on
+
+	lock sync.Mutex
+}
+
+// New creates a collector with the given options. Unless resuming, any previous
+// content of its output file is truncated.
+func New(opts ...Option) (*Collector, error) {
+	c := &Collector{path: DefaultPath, end: math.MaxUint64, codes: make(map[common.Hash]bool), pendingCodes: make(map[common.Hash]bool), pendingSide: make(map[common.Hash]bool),
+		pendingViews: make(map[common.Hash]*blockView), recentViews: make(map[common.Hash]*blockView)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.fork != "" {
+		if c.chainConfig == nil {
+			return nil, fmt.Errorf("no chain config to schedule fork %q", c.fork)
+		}
+		number := forkBlock(c.chainConfig, c.fork)
+		if number == nil {
+			return nil, fmt.Errorf("fork %q not scheduled", c.fork)
+		}
+		c.start = number.Uint64()
+	}
+	for _, field := range c.dropped {
+		if requiredFields[field] {
+			return nil, fmt.Errorf("field %q can't be dropped", field)
+		}
+	}
+	sort.Strings(c.dropped)
+	c.enc = json.NewEncoder(&c.pending)
+
+	blob, err := json.Marshal(c.config)
+	if err != nil {
+		return nil, err
+	}
+	configHash := crypto.Keccak256Hash(blob)
+
+	var pseudonyms []byte
+	if c.anonymize {
+		pseudonyms = make([]byte, 32)
+		if _, err := rand.Read(pseudonyms); err != nil {
+			return nil, err
+		}
+		c.anonymizer = &anonymizer{pseudonyms}
+	}
+	if c.dryRun {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		c.indexed = false
+		c.progress = progress{RunID: hex.EncodeToString(id), ConfigHash: configHash, Config: c.config, ChainConfig: c.chainConfig, Versions: []string{params.Version}, Pseudonyms: pseudonyms}
+		logger.Info("Validating transaction data without recording", "run", c.progress.RunID)
+		return c, nil
+	}
+	if c.resume {
+		blob, err := ioutil.ReadFile(progressPath(c.path))
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(blob, &c.progress); err != nil {
+				return nil, err
+			}
+			if c.progress.ConfigHash != configHash {
+				return nil, fmt.Errorf("record config of run %s changed", c.progress.RunID)
+			}
+			if c.progress.Codec != c.codec {
+				return nil, fmt.Errorf("codec of run %s changed from %q to %q", c.progress.RunID, c.progress.Codec, c.codec)
+			}
+			if c.progress.Indexed != c.indexed {
+				return nil, fmt.Errorf("indexing of run %s changed", c.progress.RunID)
+			}
+			if (len(c.progress.Pseudonyms) > 0) != c.anonymize {
+				return nil, fmt.Errorf("anonymization of run %s changed", c.progress.RunID)
+			}
+			if c.anonymize {
+				c.anonymizer = &anonymizer{c.progress.Pseudonyms}
+			}
+			if fmt.Sprint(c.progress.Dropped) != fmt.Sprint(c.dropped) {
+				return nil, fmt.Errorf("dropped fields of run %s changed from %v to %v", c.progress.RunID, c.progress.Dropped, c.dropped)
+			}
+			if !c.progress.Clean {
+				logger.Warn("Previous transaction data session was interrupted", "run", c.progress.RunID, "number", c.progress.Number)
+			}
+			c.checkpointed, c.resumed = true, c.progress.Number+1
+
+			if versions := c.progress.Versions; len(versions) == 0 || versions[len(versions)-1] != params.Version {
+				c.progress.Versions = append(versions, params.Version)
+			}
+			if c.chainConfig != nil {
+				prev, _ := json.Marshal(c.progress.ChainConfig)
+				if cur, _ := json.Marshal(c.chainConfig); c.progress.ChainConfig != nil && !bytes.Equal(prev, cur) {
+					logger.Warn("Chain configuration changed since the start of the run", "run", c.progress.RunID, "previous", c.progress.ChainConfig, "current", c.chainConfig)
+				}
+				c.progress.ChainConfig = c.chainConfig
+			}
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+	}
+	if c.checkpointed {
+		if c.file, err = os.OpenFile(c.path, os.O_RDWR, 0644); err != nil {
+			return nil, err
+		}
+		if err := c.file.Truncate(c.progress.Offset); err != nil {
+			c.file.Close()
+			return nil, err
+		}
+		if _, err := c.file.Seek(c.progress.Offset, 0); err != nil {
+			c.file.Close()
+			return nil, err
+		}
+		if c.indexed {
+			if c.index, err = os.OpenFile(indexPath(c.path), os.O_RDWR|os.O_CREATE, 0644); err != nil {
+				c.file.Close()
+				return nil, err
+			}
+			if err := c.index.Truncate(c.progress.IndexSize); err != nil {
+				c.Close()
+				return nil, err
+			}
+			if _, err := c.index.Seek(c.progress.IndexSize, 0); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+		if c.views != nil {
+			if err := c.loadViews(); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+		if c.hotspots != nil {
+			if err := c.loadHotspots(); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+		// Until closed again, the session counts as interrupted
+		c.progress.Clean = false
+		if err := c.saveProgress(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		logger.Info("Resuming transaction data recording", "path", c.path, "run", c.progress.RunID, "number", c.progress.Number, "hash", c.progress.Hash)
+		return c, nil
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	if c.file, err = os.Create(c.path); err != nil {
+		return nil, err
+	}
+	// Drop any index of a previous run, it would not match the new records
+	if c.indexed {
+		c.index, err = os.Create(indexPath(c.path))
+	} else if err = os.Remove(indexPath(c.path)); os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		c.file.Close()
+		return nil, err
+	}
+	// Drop the views and hotspots of a previous run, they would not match the new records
+	for _, path := range []string{viewsPath(c.path), hotspotsPath(c.path)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			c.file.Close()
+			return nil, err
+		}
+	}
+	c.progress = progress{
+		RunID:       hex.EncodeToString(id),
+		ConfigHash:  configHash,
+		Config:      c.config,
+		ChainConfig: c.chainConfig,
+		Versions:    []string{params.Version},
+		Codec:       c.codec,
+		Indexed:     c.indexed,
+		Pseudonyms:  pseudonyms,
+		Dropped:     c.dropped,
+	}
+	c.pending.WriteString(header)
+	if err := c.flush(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	logger.Info("Recording transaction data", "path", c.path, "run", c.progress.RunID)
+	return c, nil
+}
+
+// progressPath returns the file the checkpoints of the run recorded into the
+// given record file are persisted into.
+func progressPath(path string) string {
+	return path + ".progress"
+}
+
+// Attach configures the EVM (and through it the state processor) to record
+// into the collector.
+func (c *Collector) Attach(cfg *vm.Config) {
+	cfg.Recorder = c
+	cfg.RecordConfig = c.config
+}
+
+// RunID returns the identifier of the recording run.
+func (c *Collector) RunID() string {
+	return c.progress.RunID
+}
+
+// ResumeFrom returns the number of the first block following the last
+// checkpointed one, or zero if nothing has been checkpointed in the run.
+func (c *Collector) ResumeFrom() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.checkpointed {
+		return 0
+	}
+	return c.progress.Number + 1
+}
+
+// Record implements vm.Recorder, buffering a single record until the block it
+// belongs to is checkpointed.
+func (c *Collector) Record(record map[string]interface{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if number, ok := record["block"].(*big.Int); ok && !c.inRange(number.Uint64()) {
+		return nil
+	}
+	if record["method"] == "BlockStatus" {
+		hash, _ := record["blockHash"].(common.Hash)
+		c.pendingSide[hash], _ = record["orphaned"].(bool)
+	}
+	if c.anonymizer != nil {
+		record = c.anonymizer.record(record)
+	}
+	// Code is recorded once per run, drop the records of known code
+	if record["method"] == "Code" {
+		hash, _ := record["codeHash"].(common.Hash)
+		if c.codes[hash] || c.pendingCodes[hash] {
+			return nil
+		}
+		c.pendingCodes[hash] = true
+	}
+	start := time.Now()
+	offset := int64(c.pending.Len())
+	if err := c.enc.Encode(c.project(record)); err != nil {
+		c.failures++
+		failureMeter.Mark(1)
+		return err
+	}
+	if c.sparse && record["tx"] != nil {
+		c.pending.Truncate(int(offset))
+	} else if c.indexed {
+		c.indexRecord(record, offset)
+	}
+	if c.dryRun {
+		if err := c.validator.validate(record); err != nil {
+			c.violations++
+			violationMeter.Mark(1)
+			logger.Warn("Invalid transaction data record", "record", c.pending.String(), "err", err)
+		}
+		c.pending.Reset()
+	}
+	c.trackException(record)
+	if c.views != nil || c.alerts != nil {
+		c.trackView(record)
+	}
+	c.pendingStats.records++
+	if record["method"] == "Receipt" {
+		c.pendingStats.txs++
+		logger.Trace("Recorded transaction", "tx", record["tx"], "failed", record["failed"])
+	}
+	recordTimer.UpdateSince(start)
+	recordMeter.Mark(1)
+	return nil
+}
+
+// Checkpoint implements vm.Checkpointer, writing the records of the block into
+// the file and persisting the progress of the run. Side blocks (marked as
+// orphaned in their records) are written without moving the progress, which
+// follows the canonical chain. Canonical blocks reprocessed after resuming
+// (e.g. because the node rewound its head) were already recorded by the
+// previous session, so their records are dropped.
+func (c *Collector) Checkpoint(number uint64, hash common.Hash) error {
+	// Failed transactions are announced once the lock is released, so that
+	// subscribers never hold up the recording
+	var announced []*Exception
+	c.lock.Lock()
+	defer func() {
+		c.lock.Unlock()
+		c.announceExceptions(announced)
+	}()
+
+	if !c.inRange(number) {
+		return nil
+	}
+	side := c.pendingSide[hash]
+	if number < c.resumed && !side {
+		dropMeter.Mark(int64(bytes.Count(c.pending.Bytes(), []byte{'\n'})))
+		c.pending.Reset()
+		c.pendingIndex, c.lastTx = c.pendingIndex[:0], common.Hash{}
+		c.pendingCodes = make(map[common.Hash]bool)
+		c.pendingSide = make(map[common.Hash]bool)
+		c.pendingStats = recordStats{}
+		c.pendingExceptions = nil
+		c.pendingViews = make(map[common.Hash]*blockView)
+		return nil
+	}
+	start := time.Now()
+	var err error
+	if c.sparse {
+		err = c.writeBloom(number, hash)
+	}
+	if err == nil {
+		err = c.checkpoint(number, hash, side)
+	}
+	if err == nil {
+		if c.hotspots != nil {
+			err = c.countHotspots(c.pendingExceptions)
+		}
+		announced = c.settleExceptions(number, hash)
+		if c.alerts != nil {
+			c.evaluateAlerts(number)
+		}
+		if c.views != nil && err == nil {
+			err = c.applyViews(number)
+		} else if c.views == nil {
+			c.pendingViews = make(map[common.Hash]*blockView)
+		}
+	}
+
+	checkpointTimer.UpdateSince(start)
+	c.latencies.add(time.Since(start))
+	if err != nil {
+		c.failures++
+		failureMeter.Mark(1)
+	}
+	return err
+}
+
+// checkpoint writes the pending records of the block and persists the progress,
+// only moved to the block if it is canonical.
+func (c *Collector) checkpoint(number uint64, hash common.Hash, side bool) error {
+	size := c.progress.Offset
+	if err := c.flush(); err != nil {
+		return err
+	}
+	c.report(number, hash, c.progress.Offset-size)
+
+	for hash := range c.pendingCodes {
+		c.codes[hash] = true
+	}
+	c.pendingCodes = make(map[common.Hash]bool)
+	c.pendingSide = make(map[common.Hash]bool)
+	c.validator = validator{}
+
+	if !side {
+		if !c.checkpointed {
+			c.progress.Start = number
+		}
+		c.progress.Number, c.progress.Hash = number, hash
+		c.checkpointed = true
+	}
+
+	if c.dryRun {
+		return nil
+	}
+	if c.sync {
+		if err := c.file.Sync(); err != nil {
+			return err
+		}
+		if c.index != nil {
+			if err := c.index.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return c.saveProgress()
+}
+
+// saveProgress atomically persists the progress of the run next to its record
+// file.
+func (c *Collector) saveProgress() error {
+	blob, err := json.Marshal(c.progress)
+	if err != nil {
+		return err
+	}
+	tmp := progressPath(c.path) + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(blob)
+	if err == nil && c.sync {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, progressPath(c.path))
+}
+
+// project returns the record without the dropped fields.
+func (c *Collector) project(record map[string]interface{}) map[string]interface{} {
+	if len(c.dropped) == 0 {
+		return record
+	}
+	projected := make(map[string]interface{}, len(record))
+	for field, value := range record {
+		projected[field] = value
+	}
+	for _, field := range c.dropped {
+		delete(projected, field)
+	}
+	return projected
+}
+
+// recordStats are the statistics of the recording reported periodically.
+type recordStats struct {
+	blocks, txs, records int
+	bytes                int64
+	start                time.Time
+}
+
+// report accounts the block being checkpointed, with the pending records and
+// the bytes they took, to the statistics and logs them if enough time passed
+// since the last report.
+func (c *Collector) report(number uint64, hash common.Hash, size int64) {
+	logger.Debug("Checkpointed transaction data", "number", number, "hash", hash, "txs", c.pendingStats.txs, "records", c.pendingStats.records, "size", common.StorageSize(size))
+
+	if c.stats.start.IsZero() {
+		c.stats.start = time.Now()
+	}
+	c.stats.blocks++
+	c.stats.txs += c.pendingStats.txs
+	c.stats.records += c.pendingStats.records
+	c.stats.bytes += size
+	c.written += uint64(c.pendingStats.records)
+	c.pendingStats = recordStats{}
+
+	if elapsed := time.Since(c.stats.start); elapsed >= statsReportLimit {
+		seconds := elapsed.Seconds()
+		logger.Info("Recorded transaction data", "number", number, "blocks", c.stats.blocks, "txs", c.stats.txs, "records", c.stats.records,
+			"size", common.StorageSize(c.stats.bytes), "blocks/s", fmt.Sprintf("%.2f", float64(c.stats.blocks)/seconds), "txs/s", fmt.Sprintf("%.2f", float64(c.stats.txs)/seconds),
+			"elapsed", common.PrettyDuration(elapsed))
+		c.stats = recordStats{start: time.Now()}
+	}
+}
+
+// inRange reports whether the block with the given number is to be recorded.
+func (c *Collector) inRange(number uint64) bool {
+	return number >= c.start && number <= c.end
+}
+
+// flush writes the pending records into the file, compressed as a single gzip
+// member if enabled, and their entries into the index.
+func (c *Collector) flush() error {
+	if c.pending.Len() == 0 || c.dryRun {
+		return nil
+	}
+	data := c.pending.Bytes()
+	if c.codec == codecGzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	base := c.progress.Offset
+	n, err := c.file.Write(data)
+	c.pending.Reset()
+	c.progress.Offset += int64(n)
+	writeMeter.Mark(int64(n))
+	if err != nil {
+		return err
+	}
+	if c.index != nil {
+		return c.flushIndex(base)
+	}
+	return nil
+}
+
+// Close writes any pending records and closes the output file, marking the
+// session of the run as cleanly shut down.
+func (c *Collector) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.scope.Close()
+	if c.dryRun {
+		return nil
+	}
+	// Records written past the last checkpoint are dropped on resume anyway, so
+	// the progress is persisted as of the checkpoint before flushing them
+	var err error
+	if c.checkpointed {
+		c.progress.Clean = true
+		err = c.saveProgress()
+		if c.views != nil && err == nil {
+			err = c.saveViews()
+		}
+		if c.hotspots != nil && err == nil {
+			err = c.saveHotspots()
+		}
+	}
+	if flushErr := c.flush(); err == nil {
+		err = flushErr
+	}
+	if c.index != nil {
+		c.index.Close()
+	}
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,1269 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	c, err := New(WithPath(path), WithMaxDataLength(32), WithOutput(), WithAccountState())
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	var cfg vm.Config
+	c.Attach(&cfg)
+	if cfg.Recorder != c {
+		t.Errorf("recorder not attached")
+	}
+	if want := (vm.RecordConfig{MaxDataLength: 32, Output: true, AccountState: true}); !reflect.DeepEqual(cfg.RecordConfig, want) {
+		t.Errorf("record config mismatch: have %+v, want %+v", cfg.RecordConfig, want)
+	}
+	if err := c.Record(map[string]interface{}{"method": "Call", "index": 0}); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("failed to close collector: %v", err)
+	}
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Recording Tx Information\n{\"index\":0,\"method\":\"Call\"}\n"; string(blob) != want {
+		t.Errorf("content mismatch: have %q, want %q", blob, want)
+	}
+}
+
+func TestCollectorResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	record := func(c *Collector, id int) {
+		if err := c.Record(map[string]interface{}{"id": id}); err != nil {
+			t.Fatalf("failed to record %d: %v", id, err)
+		}
+	}
+	checkpoint := func(c *Collector, number uint64) {
+		if err := c.Checkpoint(number, common.Hash{byte(number)}); err != nil {
+			t.Fatalf("failed to checkpoint %d: %v", number, err)
+		}
+	}
+	// Record a first session, interrupted in the middle of block 2
+	c, err := New(WithPath(path), WithResume())
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	if from := c.ResumeFrom(); from != 0 {
+		t.Errorf("new run resume block mismatch: have %d, want 0", from)
+	}
+	run := c.RunID()
+	record(c, 0)
+	checkpoint(c, 0)
+	record(c, 1)
+	checkpoint(c, 1)
+	record(c, 2)
+	c.Close()
+
+	// Resume after rewinding to block 1, which must not be recorded twice
+	if _, err := New(WithPath(path), WithResume(), WithOutput()); err == nil {
+		t.Fatalf("resumed with a different record config")
+	}
+	if c, err = New(WithPath(path), WithResume()); err != nil {
+		t.Fatalf("failed to resume collector: %v", err)
+	}
+	if c.RunID() != run {
+		t.Errorf("run mismatch: have %s, want %s", c.RunID(), run)
+	}
+	if from := c.ResumeFrom(); from != 2 {
+		t.Errorf("resume block mismatch: have %d, want 2", from)
+	}
+	if run, err := ReadRun(path); err != nil || run.Clean {
+		t.Errorf("resumed session marked clean: %v", err)
+	}
+	record(c, 1)
+	checkpoint(c, 1)
+	record(c, 2)
+	checkpoint(c, 2)
+	c.Close()
+
+	if run, err := ReadRun(path); err != nil || !run.Clean {
+		t.Errorf("closed session not marked clean: %v", err)
+	}
+
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := header + "{\"id\":0}\n{\"id\":1}\n{\"id\":2}\n"; string(blob) != want {
+		t.Errorf("content mismatch: have %q, want %q", blob, want)
+	}
+}
+
+// Tests that side blocks don't move the progress of the run, and that those
+// first processed below the resume point aren't dropped with the canonical
+// blocks reprocessed.
+func TestCollectorReorgResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	block := func(c *Collector, number uint64, hash common.Hash, tx byte, side bool) {
+		if err := c.Record(map[string]interface{}{"method": "Receipt", "tx": common.Hash{tx}, "block": new(big.Int).SetUint64(number), "blockHash": hash}); err != nil {
+			t.Fatalf("failed to record %d: %v", tx, err)
+		}
+		if side {
+			if err := c.Record(map[string]interface{}{"method": "BlockStatus", "block": new(big.Int).SetUint64(number), "blockHash": hash, "orphaned": true}); err != nil {
+				t.Fatalf("failed to mark block %x: %v", hash, err)
+			}
+		}
+		if err := c.Checkpoint(number, hash); err != nil {
+			t.Fatalf("failed to checkpoint %x: %v", hash, err)
+		}
+	}
+	c, err := New(WithPath(path), WithResume())
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	block(c, 1, common.Hash{1}, 1, false)
+	block(c, 2, common.Hash{2}, 2, false)
+	block(c, 1, common.Hash{0x11}, 0x11, true)
+	if from := c.ResumeFrom(); from != 3 {
+		t.Errorf("resume block mismatch after side block: have %d, want 3", from)
+	}
+	c.Close()
+
+	if c, err = New(WithPath(path), WithResume()); err != nil {
+		t.Fatalf("failed to resume collector: %v", err)
+	}
+	if from := c.ResumeFrom(); from != 3 {
+		t.Errorf("resumed block mismatch: have %d, want 3", from)
+	}
+	block(c, 2, common.Hash{2}, 2, false)
+	block(c, 2, common.Hash{0x12}, 0x12, true)
+	block(c, 3, common.Hash{3}, 3, false)
+	c.Close()
+
+	if run, err := ReadRun(path); err != nil || *run.End != 3 || *run.EndHash != (common.Hash{3}) {
+		t.Errorf("run end mismatch: %+v, %v", run, err)
+	}
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open records: %v", err)
+	}
+	defer r.Close()
+
+	var have []string
+	if err := r.Iterate(func(tx *Transaction) error {
+		have = append(have, fmt.Sprintf("%x@%x", tx.Hash[:1], tx.BlockHash[:1]))
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to read records: %v", err)
+	}
+	if want := []string{"01@01", "02@02", "11@11", "12@12", "03@03"}; fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("transactions mismatch: have %v, want %v", have, want)
+	}
+	if !r.Orphaned(common.Hash{0x11}) || !r.Orphaned(common.Hash{0x12}) {
+		t.Errorf("side blocks not orphaned")
+	}
+}
+
+func TestCollectorStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := New(WithPath(filepath.Join(dir, "records")))
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	defer c.Close()
+
+	if status := c.Status(); status.ResumeFrom != 0 || status.Hash != nil || status.Size != hexutil.Uint64(len(header)) {
+		t.Errorf("new run status mismatch: %+v", status)
+	}
+	c.Record(map[string]interface{}{"id": 0})
+	if status := c.Status(); status.Pending != hexutil.Uint64(len("{\"id\":0}\n")) || status.Buffered != 1 || status.Latency != nil {
+		t.Errorf("pending records mismatch: %+v", status)
+	}
+	c.Checkpoint(5, common.Hash{5})
+
+	status := NewPublicExperimentAPI(c, nil, nil).Status()
+	if status.ResumeFrom != 6 || status.Hash == nil || *status.Hash != (common.Hash{5}) || status.Pending != 0 {
+		t.Errorf("checkpointed status mismatch: %+v", status)
+	}
+	if status.RunID != c.RunID() {
+		t.Errorf("run mismatch: have %s, want %s", status.RunID, c.RunID())
+	}
+	if status.Written != 1 || status.Buffered != 0 || status.Failures != 0 {
+		t.Errorf("record counters mismatch: written %d, buffered %d, failures %d", status.Written, status.Buffered, status.Failures)
+	}
+	if status.Latency == nil || status.Latency.P50 > status.Latency.Max {
+		t.Errorf("checkpoint latency mismatch: %+v", status.Latency)
+	}
+}
+
+func TestCollectorRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	c, err := New(WithPath(path), WithResume(), WithMaxFrames(10), WithChainConfig(params.TestChainConfig))
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	if run := c.Run(); run.Start != nil || run.End != nil || run.Config.MaxFrames != 10 {
+		t.Errorf("new run mismatch: %+v", run)
+	}
+	c.Checkpoint(3, common.Hash{3})
+	c.Checkpoint(4, common.Hash{4})
+	c.Close()
+
+	run, err := ReadRun(path)
+	if err != nil {
+		t.Fatalf("failed to read run: %v", err)
+	}
+	if run.ID != c.RunID() || run.Config.MaxFrames != 10 || run.ChainConfig == nil || run.ChainConfig.ChainID.Cmp(params.TestChainConfig.ChainID) != 0 {
+		t.Errorf("run settings mismatch: %+v", run)
+	}
+	if run.Start == nil || *run.Start != 3 || run.End == nil || *run.End != 4 || *run.EndHash != (common.Hash{4}) {
+		t.Errorf("run blocks mismatch: %+v", run)
+	}
+	if len(run.Versions) != 1 || run.Versions[0] != params.Version {
+		t.Errorf("run versions mismatch: have %v, want [%s]", run.Versions, params.Version)
+	}
+}
+
+func TestCollectorDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	c, err := New(WithPath(path), WithResume(), WithDryRun())
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	tx := common.Hash{1}
+	records := []struct {
+		record map[string]interface{}
+		valid  bool
+	}{
+		{map[string]interface{}{"method": "Call", "tx": tx, "index": 0, "parent": -1}, true},
+		{map[string]interface{}{"method": "Call", "tx": tx, "index": 1, "parent": 0}, true},
+		{map[string]interface{}{"method": "Return", "tx": tx, "index": 1}, true},
+		{map[string]interface{}{"method": "Call", "tx": tx, "index": 3, "parent": 0}, false},
+		{map[string]interface{}{"method": "Call", "tx": tx, "index": 2, "parent": 2}, false},
+		{map[string]interface{}{"method": "Return", "tx": tx, "index": 7}, false},
+		{map[string]interface{}{"method": "Receipt", "tx": common.Hash{}}, false},
+		{map[string]interface{}{"method": "BlockStatus", "blockHash": common.Hash{}}, false},
+		{map[string]interface{}{"tx": tx}, false},
+		{map[string]interface{}{"method": "Call", "tx": common.Hash{2}, "index": 0, "parent": -1}, true},
+		{map[string]interface{}{"method": "Call", "tx": common.Hash{2}, "index": 1, "parent": 0.0}, false},
+		{map[string]interface{}{"method": "Call", "tx": common.Hash{2}, "index": 1, "parent": 0, "value": big.NewInt(-1)}, false},
+		{map[string]interface{}{"method": "Call", "tx": common.Hash{2}, "index": 1, "parent": 0, "value": 0}, true},
+		{map[string]interface{}{"method": "Step", "tx": common.Hash{2}, "index": 1, "gas": -5}, false},
+		{map[string]interface{}{"method": "Receipt", "tx": common.Hash{2}, "gasUsed": "21000"}, false},
+	}
+	var invalid uint64
+	for i, test := range records {
+		if err := c.Record(test.record); err != nil {
+			t.Fatalf("record %d: failed to record: %v", i, err)
+		}
+		if !test.valid {
+			invalid++
+		}
+		if violations := uint64(c.Status().Violations); violations != invalid {
+			t.Errorf("record %d: violation count mismatch: have %d, want %d", i, violations, invalid)
+		}
+	}
+	// The frames of a transaction are counted anew in the next block, e.g. if it
+	// is included again after a reorg
+	if err := c.Checkpoint(1, common.Hash{1}); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	if err := c.Record(map[string]interface{}{"method": "Call", "tx": common.Hash{2}, "index": 0, "parent": -1}); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if violations := uint64(c.Status().Violations); violations != invalid {
+		t.Errorf("violation count mismatch after checkpoint: have %d, want %d", violations, invalid)
+	}
+	c.Checkpoint(2, common.Hash{2})
+	c.Close()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("record file written in dry run: %v", err)
+	}
+	if _, err := os.Stat(progressPath(path)); !os.IsNotExist(err) {
+		t.Errorf("progress written in dry run: %v", err)
+	}
+}
+
+// Tests that code is recorded once per run, unless its first record is dropped.
+func TestCollectorCode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	code := func(c *Collector, hash byte) {
+		if err := c.Record(map[string]interface{}{"method": "Code", "codeHash": common.Hash{hash}}); err != nil {
+			t.Fatalf("failed to record code %d: %v", hash, err)
+		}
+	}
+	c, err := New(WithPath(path), WithResume(), WithCode())
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	code(c, 1)
+	code(c, 1)
+	c.Checkpoint(1, common.Hash{1})
+	code(c, 1)
+	code(c, 2)
+	c.Close()
+
+	// Resume, dropping the reprocessed block 1 along with its code records
+	if c, err = New(WithPath(path), WithResume(), WithCode()); err != nil {
+		t.Fatalf("failed to resume collector: %v", err)
+	}
+	code(c, 2)
+	c.Checkpoint(1, common.Hash{1})
+	code(c, 2)
+	code(c, 3)
+	c.Checkpoint(2, common.Hash{2})
+	c.Close()
+
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want string
+	for _, hash := range []byte{1, 2, 3} {
+		want += fmt.Sprintf("{\"codeHash\":\"%s\",\"method\":\"Code\"}\n", common.Hash{hash}.Hex())
+	}
+	if string(blob) != header+want {
+		t.Errorf("content mismatch: have %q, want %q", blob, header+want)
+	}
+}
+
+func TestCollectorBlockRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	config := &params.ChainConfig{ByzantiumBlock: big.NewInt(2)}
+	if _, err := New(WithPath(path), WithOnlyAfterFork("byzantium")); err == nil {
+		t.Errorf("started after a fork without chain config")
+	}
+	if _, err := New(WithPath(path), WithChainConfig(config), WithOnlyAfterFork("constantinople")); err == nil {
+		t.Errorf("started after an unscheduled fork")
+	}
+	c, err := New(WithPath(path), WithChainConfig(config), WithOnlyAfterFork("byzantium"), WithEndBlock(3))
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	for number := uint64(1); number <= 4; number++ {
+		if err := c.Record(map[string]interface{}{"method": "Call", "block": new(big.Int).SetUint64(number)}); err != nil {
+			t.Fatalf("failed to record block %d: %v", number, err)
+		}
+		if err := c.Checkpoint(number, common.Hash{byte(number)}); err != nil {
+			t.Fatalf("failed to checkpoint block %d: %v", number, err)
+		}
+	}
+	c.Close()
+
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := header + "{\"block\":2,\"method\":\"Call\"}\n{\"block\":3,\"method\":\"Call\"}\n"; string(blob) != want {
+		t.Errorf("content mismatch: have %q, want %q", blob, want)
+	}
+	run, err := ReadRun(path)
+	if err != nil {
+		t.Fatalf("failed to read run: %v", err)
+	}
+	if *run.Start != 2 || *run.End != 3 {
+		t.Errorf("recorded range mismatch: have %d-%d, want 2-3", *run.Start, *run.End)
+	}
+}
+
+// Tests that failed transactions are announced to the subscribers once their
+// block is checkpointed, filtered by contract over RPC.
+func TestCollectorExceptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := New(WithPath(filepath.Join(dir, "records")))
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	defer c.Close()
+
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("experiment", NewPublicExperimentAPI(c, nil, nil)); err != nil {
+		t.Fatalf("failed to register API: %v", err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	filtered := make(chan *Exception)
+	sub, err := client.Subscribe(context.Background(), "experiment", filtered, "exceptions", &ExceptionFilter{Contracts: []common.Address{{0x0c}}})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	all := make(chan *Exception, 3)
+	defer c.SubscribeExceptions(all).Unsubscribe()
+
+	// Transactions calling b and c fail, one to d succeeds
+	for i, to := range []common.Address{{0x0b}, {0x0c}, {0x0d}} {
+		tx := common.Hash{byte(i + 1)}
+		c.Record(map[string]interface{}{"method": "Call", "tx": tx, "index": 0, "parent": -1, "from": common.Address{0x0a}, "to": to, "calleeExecuted": true})
+		if to != (common.Address{0x0d}) {
+			c.Record(map[string]interface{}{"method": "Return", "tx": tx, "index": 0, "error": "evm: execution reverted"})
+		}
+		c.Record(map[string]interface{}{"method": "Receipt", "tx": tx, "failed": to != (common.Address{0x0d}), "gasUsed": uint64(21000), "contractAddress": common.Address{}})
+	}
+	// The RPC subscription is only activated once its ID reached the client
+	time.Sleep(100 * time.Millisecond)
+	go c.Checkpoint(1, common.Hash{1})
+
+	want := &Exception{Tx: common.Hash{2}, Block: 1, BlockHash: common.Hash{1}, From: common.Address{0x0a}, To: common.Address{0x0c}, Error: "evm: execution reverted", Kind: KindRevert, GasUsed: 21000}
+	select {
+	case have := <-filtered:
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("exception mismatch: have %+v, want %+v", have, want)
+		}
+	case err := <-sub.Err():
+		t.Fatalf("subscription failed: %v", err)
+	}
+	if have := <-all; have.To != (common.Address{0x0b}) {
+		t.Errorf("first exception mismatch: have %+v", have)
+	}
+	if have := <-all; !reflect.DeepEqual(have, want) {
+		t.Errorf("second exception mismatch: have %+v, want %+v", have, want)
+	}
+	select {
+	case have := <-all:
+		t.Errorf("successful transaction announced: %+v", have)
+	default:
+	}
+}
+
+// Tests that subscribers not reading the failed transactions announced never
+// block the checkpoints, missing the ones their channel has no room for.
+func TestCollectorExceptionsStalled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := New(WithPath(filepath.Join(dir, "records")))
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	defer c.Close()
+
+	var (
+		stalled = make(chan *Exception)
+		full    = make(chan *Exception, 1)
+		live    = make(chan *Exception, 2)
+	)
+	defer c.SubscribeExceptions(stalled).Unsubscribe()
+	defer c.SubscribeExceptions(full).Unsubscribe()
+	defer c.SubscribeExceptions(live).Unsubscribe()
+
+	for i := 0; i < 2; i++ {
+		tx := common.Hash{byte(i + 1)}
+		c.Record(map[string]interface{}{"method": "Call", "tx": tx, "index": 0, "parent": -1, "from": common.Address{0x0a}, "to": common.Address{0x0b}, "calleeExecuted": true})
+		c.Record(map[string]interface{}{"method": "Return", "tx": tx, "index": 0, "error": "evm: execution reverted"})
+		c.Record(map[string]interface{}{"method": "Receipt", "tx": tx, "failed": true, "gasUsed": uint64(21000), "contractAddress": common.Address{}})
+	}
+	done := make(chan error)
+	go func() { done <- c.Checkpoint(1, common.Hash{1}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("failed to checkpoint: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("checkpoint blocked by a stalled subscriber")
+	}
+	if len(full) != 1 || (<-full).Tx != (common.Hash{1}) {
+		t.Errorf("full subscriber not sent the first exception only")
+	}
+	if len(live) != 2 {
+		t.Errorf("exceptions missed by a subscriber keeping up: have %d, want 2", len(live))
+	}
+}
+
+// Tests that anonymized runs replace accounts and transactions by pseudonyms
+// consistent across sessions, and drop the payloads.
+func TestCollectorAnonymization(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		path  = filepath.Join(dir, "records")
+		from  = common.HexToAddress("0x0a")
+		to    = common.HexToAddress("0x0b")
+		ecrec = common.BytesToAddress([]byte{1})
+	)
+	record := func(c *Collector, number uint64) {
+		tx := common.Hash{byte(number)}
+		c.Record(map[string]interface{}{"method": "Call", "tx": tx, "index": 0, "parent": -1, "from": from, "to": &to, "data": hexutil.Bytes{0xaa, 0xbb}, "dataTruncated": false})
+		c.Record(map[string]interface{}{"method": "AccountState", "stage": "pre", "tx": tx, "accounts": []map[string]interface{}{{"address": to}}})
+		c.Record(map[string]interface{}{"method": "Precompile", "tx": tx, "address": ecrec})
+		if err := c.Checkpoint(number, common.Hash{byte(number)}); err != nil {
+			t.Fatalf("failed to checkpoint %d: %v", number, err)
+		}
+	}
+	c, err := New(WithPath(path), WithAnonymization())
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	record(c, 1)
+	c.Close()
+
+	if _, err := New(WithPath(path), WithResume()); err == nil {
+		t.Fatalf("resumed anonymized run without anonymization")
+	}
+	if c, err = New(WithPath(path), WithResume(), WithAnonymization()); err != nil {
+		t.Fatalf("failed to resume collector: %v", err)
+	}
+	record(c, 2)
+	c.Close()
+
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{from.Hex()[2:], to.Hex()[2:], common.Hash{1}.Hex()[2:], common.Hash{2}.Hex()[2:], "aabb"} {
+		if strings.Contains(strings.ToLower(string(blob)), strings.ToLower(leak)) {
+			t.Errorf("records leak %s", leak)
+		}
+	}
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open records: %v", err)
+	}
+	defer r.Close()
+
+	var txs []*Transaction
+	if err := r.Iterate(func(tx *Transaction) error {
+		txs = append(txs, tx)
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to read records: %v", err)
+	}
+	if len(txs) != 2 || txs[0].Hash == txs[1].Hash {
+		t.Fatalf("transaction pseudonyms mismatch: %v", txs)
+	}
+	for i, tx := range txs {
+		frame := tx.Frames[0]
+		if *frame.To != *txs[0].Frames[0].To || *frame.To != tx.Pre[0].Address || frame.From == *frame.To {
+			t.Errorf("session %d: account pseudonyms mismatch: %+v", i, frame)
+		}
+		if len(frame.Data) != 0 || !frame.DataTruncated {
+			t.Errorf("session %d: call data not dropped: %+v", i, frame)
+		}
+		if tx.Precompiles[0].Address != ecrec {
+			t.Errorf("session %d: precompile pseudonymized: %x", i, tx.Precompiles[0].Address)
+		}
+	}
+}
+
+// Tests that anonymized records hold none of the values a transaction could be
+// matched with on chain: its hash, block, code, function, storage and amounts.
+func TestAnonymizerUnlinkable(t *testing.T) {
+	var (
+		a        = &anonymizer{key: []byte("secret")}
+		tx       = common.HexToHash("0x7a7a")
+		block    = common.HexToHash("0xb1b1")
+		code     = common.HexToHash("0xc0c0")
+		slot     = common.HexToHash("0x5151")
+		word     = common.HexToHash("0x7777")
+		selector = hexutil.Bytes{0xa9, 0x05, 0x9c, 0xbb}
+		value    = big.NewInt(123456789)
+	)
+	records := []map[string]interface{}{
+		{"method": "Call", "tx": tx, "block": big.NewInt(1234567), "blockHash": block, "index": 0, "parent": -1, "codeHash": &code, "selector": selector, "value": value, "fromBalance": big.NewInt(987654321), "fromNonce": uint64(4321)},
+		{"method": "Step", "tx": tx, "block": big.NewInt(1234567), "blockHash": block, "index": 0, "storage": map[string]interface{}{"key": slot, "from": common.Hash{}, "to": word}},
+		{"method": "Receipt", "tx": tx, "block": big.NewInt(1234567), "blockHash": block, "gasUsed": uint64(43219), "cumulativeGasUsed": uint64(654321)},
+		{"method": "BlockSummary", "block": big.NewInt(1234567), "blockHash": block, "timestamp": big.NewInt(1500000123)},
+	}
+	var blob []byte
+	for _, record := range records {
+		anonymized, err := json.Marshal(a.record(record))
+		if err != nil {
+			t.Fatalf("failed to encode record: %v", err)
+		}
+		blob = append(blob, anonymized...)
+	}
+	leaks := []string{tx.Hex()[2:], block.Hex()[2:], code.Hex()[2:], slot.Hex()[2:], word.Hex()[2:], selector.String()[2:], "1234567", "123456789", "987654321", "4321", "43219", "654321", "1500000123"}
+	for _, leak := range leaks {
+		if strings.Contains(string(blob), leak) {
+			t.Errorf("anonymized records leak %s: %s", leak, blob)
+		}
+	}
+	call := a.record(records[0])
+	if call["block"].(*big.Int).Uint64() != 1234000 || call["value"].(*big.Int).Uint64() != 1<<26 || call["fromNonce"] != uint64(4096) {
+		t.Errorf("coarsened quantities mismatch: block %v, value %v, nonce %v", call["block"], call["value"], call["fromNonce"])
+	}
+	if call["tx"] != a.record(records[2])["tx"] || call["blockHash"] != a.record(records[3])["blockHash"] {
+		t.Errorf("pseudonyms inconsistent within the run")
+	}
+	if storage := a.record(records[1])["storage"].(map[string]interface{}); storage["from"] != (common.Hash{}) {
+		t.Errorf("empty storage word pseudonymized: %v", storage["from"])
+	}
+}
+
+// Tests that dropped fields are left out of the written records.
+func TestCollectorDroppedFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	if _, err := New(WithPath(path), WithoutFields("tx")); err == nil {
+		t.Fatalf("dropped a required field")
+	}
+	c, err := New(WithPath(path), WithoutFields("value", "error", "unknown"))
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	record := map[string]interface{}{"method": "Return", "index": 0, "error": "evm: execution reverted", "value": 1, "output": "0x"}
+	c.Record(record)
+	c.Checkpoint(1, common.Hash{1})
+	c.Close()
+
+	if len(record) != 5 {
+		t.Errorf("recorded map modified: %v", record)
+	}
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := header + "{\"index\":0,\"method\":\"Return\",\"output\":\"0x\"}\n"; string(blob) != want {
+		t.Errorf("content mismatch: have %q, want %q", blob, want)
+	}
+	if _, err := New(WithPath(path), WithResume(), WithoutFields("value")); err == nil {
+		t.Errorf("resumed with different dropped fields")
+	}
+	if c, err := New(WithPath(path), WithResume(), WithoutFields("error", "unknown", "value")); err != nil {
+		t.Errorf("failed to resume with the same dropped fields: %v", err)
+	} else {
+		c.Close()
+	}
+}
+
+// failingRecorder is a recorder failing once the given number of records were
+// recorded.
+type failingRecorder struct {
+	*Memory
+	left int
+}
+
+func (r *failingRecorder) Record(record map[string]interface{}) error {
+	if r.left == 0 {
+		return errors.New("recorder failed")
+	}
+	r.left--
+	return r.Memory.Record(record)
+}
+
+// Tests that a fanout keeps writing every record into the healthy recorders
+// whichever recorder failed, only surfacing the failures of the required one.
+func TestFanout(t *testing.T) {
+	var (
+		primary = &failingRecorder{Memory: NewMemory(), left: 1}
+		backup  = NewMemory()
+		flaky   = &failingRecorder{Memory: NewMemory(), left: 0}
+		f       = NewFanout(primary, flaky, backup)
+	)
+	if err := f.Record(map[string]interface{}{"method": "Call"}); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := f.Record(map[string]interface{}{"method": "Return"}); err == nil {
+		t.Fatalf("failure of the required recorder not returned")
+	}
+	if err := f.Checkpoint(1, common.Hash{1}); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	if records := backup.Records(""); len(records) != 2 {
+		t.Errorf("backup record count mismatch: have %d, want 2", len(records))
+	}
+	if records := primary.Records(""); len(records) != 1 {
+		t.Errorf("primary record count mismatch: have %d, want 1", len(records))
+	}
+	if failures := f.Failures(); len(failures) != 1 || failures[0] == nil {
+		t.Errorf("optional failures mismatch: have %v, want the flaky recorder", failures)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("failed to close: %v", err)
+	}
+}
+
+// Tests that the views aggregate the transactions of checkpointed blocks, take
+// orphaned blocks out again, and are caught up from the records on resume.
+func TestCollectorViews(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	c, err := New(WithPath(path), WithViews())
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	// Block 1 calls b successfully and c failing, its sibling 1' calls c failing
+	record := func(block common.Hash, tx common.Hash, to common.Address, failed bool) {
+		tags := map[string]interface{}{"block": big.NewInt(1), "blockHash": block, "tx": tx}
+		for _, record := range []map[string]interface{}{
+			{"method": "Call", "index": 0, "parent": -1, "from": common.Address{0x0a}, "to": to, "calleeExecuted": true},
+			{"method": "Return", "index": 0, "error": "evm: execution reverted"},
+			{"method": "Receipt", "failed": failed, "gasUsed": uint64(30000), "contractAddress": common.Address{}},
+		} {
+			if record["method"] == "Return" && !failed {
+				continue
+			}
+			for key, value := range tags {
+				record[key] = value
+			}
+			c.Record(record)
+		}
+	}
+	summary := func(block common.Hash) {
+		c.Record(map[string]interface{}{"method": "BlockSummary", "block": big.NewInt(1), "blockHash": block, "timestamp": big.NewInt(1500000000)})
+	}
+	record(common.Hash{1}, common.Hash{0x11}, common.Address{0x0b}, false)
+	record(common.Hash{1}, common.Hash{0x12}, common.Address{0x0c}, true)
+	summary(common.Hash{1})
+	c.Checkpoint(1, common.Hash{1})
+
+	record(common.Hash{2}, common.Hash{0x21}, common.Address{0x0c}, true)
+	summary(common.Hash{2})
+	c.Record(map[string]interface{}{"method": "BlockStatus", "block": big.NewInt(1), "blockHash": common.Hash{2}, "orphaned": true})
+	c.Checkpoint(1, common.Hash{2})
+
+	want := &Views{
+		Daily: map[string]*DailyView{
+			"2017-07-14": {Txs: 2, Failed: 1, GasWasted: 30000, Exceptions: map[string]uint64{"evm: execution reverted": 1}},
+		},
+		Contracts: map[common.Address]*ContractView{
+			{0x0b}: {Txs: 1},
+			{0x0c}: {Txs: 1, Failed: 1, GasWasted: 30000},
+		},
+		GasWasted: 30000,
+	}
+	check := func(stage string, have *Views) {
+		have.Number, have.Offset = 0, 0
+		if !reflect.DeepEqual(have, want) {
+			haveBlob, _ := json.Marshal(have)
+			wantBlob, _ := json.Marshal(want)
+			t.Errorf("%s: views mismatch:\nhave %s\nwant %s", stage, haveBlob, wantBlob)
+		}
+	}
+	check("sibling orphaned", c.Views())
+	if rate := c.Views().Contracts[common.Address{0x0c}].RevertRate(); rate != 1 {
+		t.Errorf("revert rate mismatch: have %v, want 1", rate)
+	}
+	// Reorg onto the sibling, then back
+	c.Record(map[string]interface{}{"method": "BlockStatus", "block": big.NewInt(1), "blockHash": common.Hash{1}, "orphaned": true})
+	c.Record(map[string]interface{}{"method": "BlockStatus", "block": big.NewInt(1), "blockHash": common.Hash{2}, "orphaned": false})
+	if have := c.Views(); have.Contracts[common.Address{0x0b}] != nil || have.Contracts[common.Address{0x0c}].Txs != 1 || have.GasWasted != 30000 {
+		t.Errorf("views not reorged: %+v", have.Contracts)
+	}
+	c.Record(map[string]interface{}{"method": "BlockStatus", "block": big.NewInt(1), "blockHash": common.Hash{2}, "orphaned": true})
+	c.Record(map[string]interface{}{"method": "BlockStatus", "block": big.NewInt(1), "blockHash": common.Hash{1}, "orphaned": false})
+	c.Checkpoint(1, common.Hash{1})
+	check("reorged back", c.Views())
+	c.Close()
+
+	// Resume without the persisted views, rebuilding them from the records
+	if err := os.Remove(viewsPath(path)); err != nil {
+		t.Fatalf("failed to remove views: %v", err)
+	}
+	if c, err = New(WithPath(path), WithResume(), WithViews()); err != nil {
+		t.Fatalf("failed to resume collector: %v", err)
+	}
+	defer c.Close()
+	check("caught up", c.Views())
+}
+
+// Tests that the contracts and functions called by the most failed transactions
+// are tracked within the configured space, and restored on resume.
+func TestCollectorHotspots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	c, err := New(WithPath(path), WithHotspots(2, 0))
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	selector := hexutil.Bytes{0xa9, 0x05, 0x9c, 0xbb}
+	for i, to := range []common.Address{{0x0b}, {0x0b}, {0x0c}, {0x0b}, {0x0d}, {0x0e}} {
+		tx := common.Hash{byte(i + 1)}
+		c.Record(map[string]interface{}{"method": "Call", "tx": tx, "index": 0, "parent": -1, "from": common.Address{0x0a}, "to": to, "selector": selector, "calleeExecuted": true})
+		c.Record(map[string]interface{}{"method": "Return", "tx": tx, "index": 0, "error": "evm: execution reverted"})
+		c.Record(map[string]interface{}{"method": "Receipt", "tx": tx, "failed": true, "gasUsed": uint64(21000), "contractAddress": common.Address{}})
+	}
+	c.Checkpoint(1, common.Hash{1})
+
+	// b stays on top, d replaced c inheriting its count, e replaced d
+	want := []*Hotspot{
+		{Contract: common.Address{0x0b}, Count: 3},
+		{Contract: common.Address{0x0e}, Count: 3, Error: 2},
+	}
+	hotspots := c.Hotspots(0)
+	if !reflect.DeepEqual(hotspots.Contracts, want) {
+		t.Errorf("contract hotspots mismatch: have %v, want %v", hotspots.Contracts, want)
+	}
+	if top := c.Hotspots(1).Functions; len(top) != 1 || top[0].Contract != (common.Address{0x0b}) || !bytes.Equal(top[0].Selector, selector) || top[0].Count != 3 {
+		t.Errorf("function hotspots mismatch: %v", top)
+	}
+	c.Close()
+
+	if c, err = New(WithPath(path), WithResume(), WithHotspots(2, 0)); err != nil {
+		t.Fatalf("failed to resume collector: %v", err)
+	}
+	defer c.Close()
+	if have := c.Hotspots(0).Contracts; !reflect.DeepEqual(have, want) {
+		t.Errorf("restored hotspots mismatch: have %v, want %v", have, want)
+	}
+}
+
+func TestCollectorAlerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	alerts := make(chan *Alert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alert := new(Alert)
+		if err := json.NewDecoder(r.Body).Decode(alert); err != nil {
+			t.Errorf("failed to decode alert: %v", err)
+		}
+		alerts <- alert
+	}))
+	defer server.Close()
+
+	contract := common.Address{0x0b}
+	rule := AlertRule{Name: "b", Contract: &contract, Window: 2, MinTxs: 2, MaxRate: 0.5, Webhook: server.URL}
+	c, err := New(WithPath(filepath.Join(dir, "records")), WithAlerts(rule))
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	defer c.Close()
+
+	// Outcomes of the transactions calling b, along with one to c always failing
+	blocks := [][]bool{{true, false}, {true}, {true}, {false, false}, {true}, {true}}
+	for i, outcomes := range blocks {
+		for j, failed := range append(outcomes, true) {
+			tx, to := common.Hash{byte(i + 1), byte(j)}, contract
+			if j == len(outcomes) {
+				to = common.Address{0x0c}
+			}
+			c.Record(map[string]interface{}{"method": "Call", "tx": tx, "index": 0, "parent": -1, "from": common.Address{0x0a}, "to": to, "calleeExecuted": true})
+			c.Record(map[string]interface{}{"method": "Receipt", "tx": tx, "failed": failed, "gasUsed": uint64(21000), "contractAddress": common.Address{}})
+		}
+		c.Checkpoint(uint64(i+1), common.Hash{byte(i + 1)})
+	}
+	// The rate exceeds half at blocks 2 and 3, then again at block 6
+	want := []Alert{
+		{Rule: "b", Block: 2, Txs: 3, Failed: 2, Rate: 2.0 / 3, Contract: &contract},
+		{Rule: "b", Block: 6, Txs: 2, Failed: 2, Rate: 1, Contract: &contract},
+	}
+	have := make([]Alert, len(want))
+	for i := range want {
+		select {
+		case alert := <-alerts:
+			have[i] = *alert
+		case <-time.After(5 * time.Second):
+			t.Fatalf("alert %d not posted", i)
+		}
+	}
+	// Alerts are posted in the background, so they may arrive in any order
+	sort.Slice(have, func(i, j int) bool { return have[i].Block < have[j].Block })
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("alerts mismatch: have %+v, want %+v", have, want)
+	}
+	select {
+	case alert := <-alerts:
+		t.Errorf("unexpected alert: %+v", alert)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Tests that the alert rules of a node are loaded from the file configured and
+// alert on the blocks it processes.
+func TestConfigAlerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	alerts := make(chan *Alert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alert := new(Alert)
+		if err := json.NewDecoder(r.Body).Decode(alert); err != nil {
+			t.Errorf("failed to decode alert: %v", err)
+		}
+		alerts <- alert
+	}))
+	defer server.Close()
+
+	// A contract writing storage, running out of gas if called with too little
+	var (
+		key, _   = crypto.GenerateKey()
+		from     = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.Address{0xaa}
+		db       = ethdb.NewMemDatabase()
+		gspec    = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				from:     {Balance: big.NewInt(params.Ether)},
+				contract: {Balance: new(big.Int), Code: common.FromHex("0x600160005500")},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+		rules   = filepath.Join(dir, "alerts.json")
+	)
+	blob, _ := json.Marshal([]AlertRule{{Name: "aa", Contract: &contract, Window: 1, MinTxs: 1, MaxRate: 0.5, Webhook: server.URL}})
+	if err := ioutil.WriteFile(rules, blob, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Config{Path: filepath.Join(dir, "records"), Alerts: filepath.Join(dir, "missing.json")}).Attach(new(vm.Config), gspec.Config); err == nil {
+		t.Fatalf("attached without the alert rules")
+	}
+	var vmcfg vm.Config
+	if _, err := (&Config{Path: filepath.Join(dir, "records"), Alerts: rules}).Attach(&vmcfg, gspec.Config); err != nil {
+		t.Fatalf("failed to attach collector: %v", err)
+	}
+	chain, err := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vmcfg)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	// Block 1 calls the contract with enough gas, block 2 without
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 2, func(i int, b *core.BlockGen) {
+		gas := uint64(25000)
+		if i == 0 {
+			gas = 100000
+		}
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(from), contract, new(big.Int), gas, big.NewInt(1), nil), types.HomesteadSigner{}, key)
+		b.AddTx(tx)
+	})
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	select {
+	case alert := <-alerts:
+		if alert.Rule != "aa" || alert.Block != 2 || alert.Txs != 1 || alert.Failed != 1 {
+			t.Errorf("alert mismatch: %+v", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("alert not posted")
+	}
+}
+
+func TestCollectorSparse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	c, err := New(WithPath(path), WithSparse())
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	selector := hexutil.Bytes{0xa9, 0x05, 0x9c, 0xbb}
+	messages := []string{"evm: execution reverted", "evm: execution reverted", "evm: execution reverted", "invalid jump destination (PUSH1) 5"}
+	for i, to := range []common.Address{{0x0b}, {0x0c}, {0x0b}, {0x0d}} {
+		tx := common.Hash{byte(i + 1)}
+		c.Record(map[string]interface{}{"method": "Call", "tx": tx, "index": 0, "parent": -1, "from": common.Address{0x0a}, "to": to, "selector": selector, "calleeExecuted": true})
+		c.Record(map[string]interface{}{"method": "Return", "tx": tx, "index": 0, "error": messages[i]})
+		c.Record(map[string]interface{}{"method": "Receipt", "tx": tx, "failed": i != 2, "gasUsed": uint64(21000), "contractAddress": common.Address{}})
+		c.Checkpoint(uint64(i+1), common.Hash{byte(i + 1)})
+	}
+	c.Close()
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open records: %v", err)
+	}
+	defer r.Close()
+	if tx, err := r.Next(); err != io.EOF {
+		t.Fatalf("transaction records kept: %v, %v", tx, err)
+	}
+	var (
+		b, c2 = common.Address{0x0b}, common.Address{0x0c}
+		tests = []struct {
+			contract *common.Address
+			selector []byte
+			kind     string
+			want     []uint64
+		}{
+			{nil, nil, "", []uint64{1, 2, 4}},
+			{&b, nil, "", []uint64{1}},
+			{&c2, selector, "", []uint64{2}},
+			{nil, selector, KindRevert, []uint64{1, 2}},
+			{&b, selector, KindOutOfGas, nil},
+			{nil, nil, KindInvalidJump, []uint64{4}},
+			{nil, nil, messages[3], nil},
+		}
+	)
+	for i, tt := range tests {
+		blooms, err := r.Locate(tt.contract, tt.selector, tt.kind)
+		if err != nil {
+			t.Fatalf("test %d: failed to locate blocks: %v", i, err)
+		}
+		var have []uint64
+		for _, bloom := range blooms {
+			have = append(have, bloom.Number)
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("test %d: located blocks mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+
+	// Blooms of anonymized runs hold pseudonyms only, and no dropped field
+	path = filepath.Join(dir, "anonymized")
+	if c, err = New(WithPath(path), WithSparse(), WithAnonymization(), WithoutFields("selector")); err != nil {
+		t.Fatalf("failed to create anonymized collector: %v", err)
+	}
+	pseudonyms := c.anonymizer
+	c.Record(map[string]interface{}{"method": "Call", "tx": common.Hash{1}, "index": 0, "parent": -1, "from": common.Address{0x0a}, "to": b, "selector": selector, "calleeExecuted": true})
+	c.Record(map[string]interface{}{"method": "Return", "tx": common.Hash{1}, "index": 0, "error": "evm: execution reverted"})
+	c.Record(map[string]interface{}{"method": "Receipt", "tx": common.Hash{1}, "failed": true, "gasUsed": uint64(21000), "contractAddress": common.Address{}})
+	c.Checkpoint(1, common.Hash{1})
+	c.Close()
+
+	anonymized, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open anonymized records: %v", err)
+	}
+	defer anonymized.Close()
+
+	pseudonym := pseudonyms.address(b)
+	if blooms, err := anonymized.Locate(&pseudonym, nil, ""); err != nil || len(blooms) != 1 || blooms[0].Hash != pseudonyms.hash(common.Hash{1}) {
+		t.Errorf("pseudonymized contract not located: %v, %v", blooms, err)
+	}
+	if blooms, err := anonymized.Locate(&b, nil, ""); err != nil || len(blooms) != 0 {
+		t.Errorf("anonymized bloom holds the contract: %v, %v", blooms, err)
+	}
+	if blooms, err := anonymized.Locate(nil, selector, ""); err != nil || len(blooms) != 0 {
+		t.Errorf("anonymized bloom holds the dropped selector: %v, %v", blooms, err)
+	}
+}
+
+// Tests that the recording configured by the flags of a node is attached to
+// its EVM with the options set.
+func TestConfigAttach(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		vmcfg vm.Config
+		cfg   = Config{Path: filepath.Join(dir, "records"), Start: 5, End: 10, Fork: "byzantium", DryRun: true, Sparse: true, Views: true, Hotspots: 10, HotspotsInterval: time.Second, DropFields: []string{"error", "value"}}
+	)
+	c, err := cfg.Attach(&vmcfg, params.TestChainConfig)
+	if err != nil {
+		t.Fatalf("failed to attach collector: %v", err)
+	}
+	if vmcfg.Recorder != c {
+		t.Fatalf("collector not attached: %v", vmcfg.Recorder)
+	}
+	// The fork overrides the start block, byzantium being active from the genesis
+	if c.path != cfg.Path || c.start != 0 || c.end != 10 || c.fork != "byzantium" {
+		t.Errorf("collector range mismatch: path %s, start %d, end %d, fork %s", c.path, c.start, c.end, c.fork)
+	}
+	if !c.resume || !c.dryRun || !c.sparse || c.views == nil {
+		t.Errorf("collector modes mismatch: resume %v, dry run %v, sparse %v, views %v", c.resume, c.dryRun, c.sparse, c.views != nil)
+	}
+	if c.hotspots == nil || c.hotspots.contracts.capacity != 10 || c.hotspots.interval != time.Second {
+		t.Errorf("hotspots mismatch: %+v", c.hotspots)
+	}
+	if !reflect.DeepEqual(c.dropped, cfg.DropFields) {
+		t.Errorf("dropped fields mismatch: have %v, want %v", c.dropped, cfg.DropFields)
+	}
+	c.Close()
+
+	cfg = Config{Path: filepath.Join(dir, "partitioned"), Partitions: true}
+	if c, err = cfg.Attach(&vmcfg, params.TestChainConfig); err != nil || c != nil {
+		t.Fatalf("partitions not attached: %v, %v", c, err)
+	}
+	if p, ok := vmcfg.Recorder.(*Partitions); !ok || p.path != cfg.Path {
+		t.Errorf("partitions mismatch: %v", vmcfg.Recorder)
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := NewPartitions(filepath.Join(dir, "records"), WithOutput())
+	var cfg vm.Config
+	if p.Attach(&cfg); cfg.Recorder != p || !cfg.RecordConfig.Output {
+		t.Fatalf("partitions not attached: %+v", cfg)
+	}
+	block := common.Hash{1}
+	for i, err := range []string{"evm: execution reverted", "", "out of gas", "evm: execution reverted"} {
+		tx := common.Hash{byte(i + 1)}
+		p.Record(map[string]interface{}{"method": "Call", "tx": tx, "blockHash": block, "index": 0, "parent": -1, "from": common.Address{0x0a}, "to": common.Address{0x0b}, "calleeExecuted": true})
+		p.Record(map[string]interface{}{"method": "Return", "tx": tx, "blockHash": block, "index": 0, "error": err})
+		p.Record(map[string]interface{}{"method": "Receipt", "tx": tx, "blockHash": block, "failed": err != "", "gasUsed": uint64(21000), "contractAddress": common.Address{}})
+	}
+	p.Record(map[string]interface{}{"method": "Rejected", "tx": common.Hash{5}, "blockHash": block, "error": "nonce too low"})
+	p.Record(map[string]interface{}{"method": "BlockSummary", "block": big.NewInt(1), "blockHash": block, "txs": 4})
+	if err := p.Checkpoint(1, block); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("failed to close partitions: %v", err)
+	}
+	want := map[string][]common.Hash{
+		KindRevert:   {{1}, {4}},
+		KindSuccess:  {{2}},
+		KindOutOfGas: {{3}},
+		KindRejected: {{5}},
+	}
+	paths := p.Paths()
+	if len(paths) != len(want) {
+		t.Errorf("partition count mismatch: have %d, want %d", len(paths), len(want))
+	}
+	for kind, hashes := range want {
+		r, err := Open(paths[kind])
+		if err != nil {
+			t.Fatalf("%s: failed to open partition: %v", kind, err)
+		}
+		var have []common.Hash
+		r.Iterate(func(tx *Transaction) error {
+			have = append(have, tx.Hash)
+			return nil
+		})
+		if !reflect.DeepEqual(have, hashes) {
+			t.Errorf("%s: transactions mismatch: have %x, want %x", kind, have, hashes)
+		}
+		if r.Block(block) == nil {
+			t.Errorf("%s: block summary missing", kind)
+		}
+		r.Close()
+	}
+}
+
+func TestExceptionKind(t *testing.T) {
+	tests := map[string]string{
+		"evm: execution reverted": KindRevert,
+		"out of gas":              KindOutOfGas,
+		"contract creation code storage out of gas": KindOutOfGas,
+		"invalid jump destination (PUSH1) 5":        KindInvalidJump,
+		"invalid opcode 0xfe":                       KindInvalidOpcode,
+		"stack underflow (0 <=> 1)":                 KindStack,
+		"stack limit reached 1024 (1023)":           KindStack,
+		"evm: write protection":                     KindOther,
+		"out of gas in an unknown way":              KindOther,
+		"":                                          KindOther,
+	}
+	for err, want := range tests {
+		if have := ExceptionKind(err); have != want {
+			t.Errorf("%q: kind mismatch: have %s, want %s", err, have, want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Config are the settings of the transaction data recording of a node, as set
+// by its command line flags.
+type Config struct {
+	Path       string   `toml:",omitempty"` // record file, DefaultPath if empty
+	Start      uint64   `toml:",omitempty"` // first block to record
+	End        uint64   `toml:",omitempty"` // last block to record, no limit if zero
+	Fork       string   `toml:",omitempty"` // fork to start recording at, overriding Start
+	DryRun     bool     `toml:",omitempty"` // validate the records instead of writing them
+	Sparse     bool     `toml:",omitempty"` // only record blooms of the failed transactions
+	Views      bool     `toml:",omitempty"` // maintain the aggregated views of the records
+	Partitions bool     `toml:",omitempty"` // partition the records by exception kind
+	Alerts     string   `toml:",omitempty"` // JSON file of the alert rules, none if empty
+	DropFields []string `toml:",omitempty"` // record fields to drop, see WithoutFields
+
+	Hotspots         int           `toml:",omitempty"` // number of hotspot candidates tracked, none if zero
+	HotspotsInterval time.Duration `toml:",omitempty"` // least time between two saves of the hotspots
+}
+
+// options returns the options of the collectors recording the chain with the
+// given configuration, loading the alert rules if any.
+func (cfg *Config) options(chainConfig *params.ChainConfig) ([]Option, error) {
+	opts := []Option{WithResume(), WithChainConfig(chainConfig)}
+	if cfg.Path != "" {
+		opts = append(opts, WithPath(cfg.Path))
+	}
+	if cfg.Start > 0 {
+		opts = append(opts, WithStartBlock(cfg.Start))
+	}
+	if cfg.End > 0 {
+		opts = append(opts, WithEndBlock(cfg.End))
+	}
+	if cfg.Fork != "" {
+		opts = append(opts, WithOnlyAfterFork(cfg.Fork))
+	}
+	if cfg.DryRun {
+		opts = append(opts, WithDryRun())
+	}
+	if cfg.Sparse {
+		opts = append(opts, WithSparse())
+	}
+	if cfg.Views {
+		opts = append(opts, WithViews())
+	}
+	if len(cfg.DropFields) > 0 {
+		opts = append(opts, WithoutFields(cfg.DropFields...))
+	}
+	if cfg.Hotspots > 0 {
+		opts = append(opts, WithHotspots(cfg.Hotspots, cfg.HotspotsInterval))
+	}
+	if cfg.Alerts != "" {
+		rules, err := LoadAlertRules(cfg.Alerts)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithAlerts(rules...))
+	}
+	return opts, nil
+}
+
+// Attach creates the recorder of the configuration for the chain and configures
+// the EVM to record into it. The collector is returned, unless the records are
+// partitioned into a collector per exception kind.
+func (cfg *Config) Attach(vmcfg *vm.Config, chainConfig *params.ChainConfig) (*Collector, error) {
+	opts, err := cfg.options(chainConfig)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Partitions {
+		path := cfg.Path
+		if path == "" {
+			path = DefaultPath
+		}
+		NewPartitions(path, opts...).Attach(vmcfg)
+		return nil, nil
+	}
+	collector, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	collector.Attach(vmcfg)
+	return collector, nil
+}
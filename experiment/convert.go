@@ -0,0 +1,246 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+)
+
+// callTypes maps the methods of frame records to the call types of the
+// callTracer, value transfers being plain calls there.
+var callTypes = map[string]string{
+	"Call":         "CALL",
+	"Transfer":     "CALL",
+	"CallCode":     "CALLCODE",
+	"DelegateCall": "DELEGATECALL",
+	"StaticCall":   "STATICCALL",
+	"Create":       "CREATE",
+}
+
+// revertError is the error the callTracer reports reverted frames with, which
+// the records report with the prefix of the EVM errors.
+const revertError = "execution reverted"
+
+// CallTrace is a call frame in the format of the callTracer of debug_trace*
+// (eth/tracers/internal/tracers/call_tracer.js), with its child frames nested.
+//
+// Records don't carry the gas available to frames, so converted traces only
+// report the gas used by the transaction at their root: like the callTracer,
+// without the intrinsic gas, but unlike it after refunds.
+type CallTrace struct {
+	Type    string          `json:"type"`
+	From    common.Address  `json:"from"`
+	To      common.Address  `json:"to"`
+	Value   *hexutil.Big    `json:"value,omitempty"`
+	Gas     *hexutil.Uint64 `json:"gas,omitempty"`
+	GasUsed *hexutil.Uint64 `json:"gasUsed,omitempty"`
+	Input   hexutil.Bytes   `json:"input"`
+	Output  hexutil.Bytes   `json:"output,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Calls   []*CallTrace    `json:"calls,omitempty"`
+}
+
+// CallTrace converts the recorded frames of the transaction into the format of
+// the callTracer, or returns nil if no frames were recorded. Self-destructs are
+// appended to the calls of their frame, as their order among the calls isn't
+// recorded.
+func (tx *Transaction) CallTrace() *CallTrace {
+	if len(tx.Frames) == 0 {
+		return nil
+	}
+	traces := make(map[int]*CallTrace)
+	for _, frame := range tx.Frames {
+		trace := &CallTrace{
+			Type:  callTypes[frame.Method],
+			From:  frame.From,
+			Input: frame.Data,
+		}
+		switch {
+		case frame.To != nil:
+			trace.To = *frame.To
+		case frame.StorageAddress != nil:
+			trace.To = *frame.StorageAddress // created contract
+		}
+		if frame.Value != nil && frame.Method != "DelegateCall" && frame.Method != "StaticCall" {
+			trace.Value = (*hexutil.Big)(frame.Value)
+		}
+		if frame.Error != "" {
+			trace.Error = strings.TrimPrefix(frame.Error, "evm: ")
+		} else {
+			trace.Output = frame.Output
+		}
+		traces[frame.Index] = trace
+		if parent := traces[frame.Parent]; parent != nil {
+			parent.Calls = append(parent.Calls, trace)
+		}
+	}
+	for _, op := range tx.Selfdestructs {
+		if parent := traces[op.Parent]; parent != nil {
+			parent.Calls = append(parent.Calls, &CallTrace{Type: "SELFDESTRUCT", From: op.From, To: op.To, Value: (*hexutil.Big)(op.Value)})
+		}
+	}
+	root := traces[tx.Frames[0].Index]
+	if tx.Receipt != nil {
+		gasUsed := hexutil.Uint64(tx.Receipt.GasUsed - intrinsicGas(tx.Frames[0]))
+		root.GasUsed = &gasUsed
+	}
+	return root
+}
+
+// intrinsicGas returns the gas charged for the outermost frame of a transaction
+// before executing it, since Homestead. It's underestimated if the data of the
+// frame was truncated.
+func intrinsicGas(frame *Frame) uint64 {
+	gas, _ := core.IntrinsicGas(frame.Data, frame.Method == "Create", true)
+	return gas
+}
+
+// FromCallTrace converts a trace produced by the callTracer into a transaction
+// with the given hash, numbering its frames in the order they were entered.
+// The receipt is derived from the root frame if it reports the gas used, taking
+// no refunds into account.
+func FromCallTrace(hash common.Hash, trace *CallTrace) (*Transaction, error) {
+	tx := &Transaction{Hash: hash}
+	if err := tx.addCallTrace(trace, -1); err != nil {
+		return nil, err
+	}
+	if trace.GasUsed != nil {
+		tx.Receipt = &Receipt{Failed: trace.Error != "", GasUsed: uint64(*trace.GasUsed) + intrinsicGas(tx.Frames[0])}
+	}
+	return tx, nil
+}
+
+// addCallTrace appends the frames of a call trace, and those of its calls, to
+// the transaction.
+func (tx *Transaction) addCallTrace(trace *CallTrace, parent int) error {
+	if trace.Type == "SELFDESTRUCT" {
+		tx.Selfdestructs = append(tx.Selfdestructs, &Selfdestruct{From: trace.From, To: trace.To, Value: trace.Value.ToInt(), Parent: parent})
+		return nil
+	}
+	frame := &Frame{
+		External:       parent == -1,
+		From:           trace.From,
+		Value:          new(big.Int),
+		Data:           trace.Input,
+		Index:          len(tx.Frames),
+		Parent:         parent,
+		CalleeExecuted: true,
+		PropagatedFrom: -1,
+	}
+	to := trace.To
+	switch trace.Type {
+	case "CALL":
+		frame.Method, frame.To = "Call", &to
+	case "CALLCODE":
+		frame.Method, frame.To = "CallCode", &to
+	case "DELEGATECALL":
+		frame.Method, frame.To, frame.Delegated = "DelegateCall", &to, true
+	case "STATICCALL":
+		frame.Method, frame.To, frame.Static = "StaticCall", &to, true
+	case "CREATE":
+		frame.Method, frame.StorageAddress = "Create", &to
+	default:
+		return fmt.Errorf("unknown call type %q", trace.Type)
+	}
+	if trace.Value != nil {
+		frame.Value = trace.Value.ToInt()
+	}
+	if len(frame.Data) >= 4 && frame.Method != "Create" {
+		frame.Selector = frame.Data[:4]
+	}
+	switch trace.Error {
+	case "":
+		frame.Output = trace.Output
+	case revertError:
+		frame.Error = "evm: " + revertError
+	default:
+		frame.Error = trace.Error
+	}
+	tx.Frames = append(tx.Frames, frame)
+	for _, call := range trace.Calls {
+		if err := tx.addCallTrace(call, frame.Index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StructLog is an executed operation in the format of the structLogger of
+// debug_trace* (the default tracer), without the stack, memory and storage,
+// which aren't recorded.
+type StructLog struct {
+	Pc      uint64 `json:"pc"`
+	Op      string `json:"op"`
+	Gas     uint64 `json:"gas"`
+	GasCost uint64 `json:"gasCost"`
+	Depth   int    `json:"depth"`
+}
+
+// StructLogTrace is the result of the structLogger for a transaction.
+type StructLogTrace struct {
+	Gas         uint64      `json:"gas"`
+	Failed      bool        `json:"failed"`
+	ReturnValue string      `json:"returnValue"`
+	StructLogs  []StructLog `json:"structLogs"`
+}
+
+// StructLogs converts the recorded steps of the transaction into the format of
+// the structLogger, interleaving the steps of child frames after the calls
+// entering them. Steps are only recorded for watched accounts, so the result
+// only covers their frames; converting back isn't possible, as the operations
+// don't identify the accounts executing them.
+func (tx *Transaction) StructLogs() *StructLogTrace {
+	result := &StructLogTrace{StructLogs: []StructLog{}}
+	if tx.Receipt != nil {
+		result.Gas, result.Failed = tx.Receipt.GasUsed, tx.Receipt.Failed
+	}
+	if len(tx.Frames) == 0 {
+		return result
+	}
+	if root := tx.Frames[0]; root.Error == "" {
+		result.ReturnValue = fmt.Sprintf("%x", []byte(root.Output))
+	}
+	children := make(map[int][]*Frame)
+	for _, frame := range tx.Frames[1:] {
+		children[frame.Parent] = append(children[frame.Parent], frame)
+	}
+	result.StructLogs = appendStructLogs(result.StructLogs, tx.Frames[0], 1, children)
+	return result
+}
+
+// appendStructLogs appends the steps of a frame at the given depth, and those of
+// the child frames following the calls entering them.
+func appendStructLogs(logs []StructLog, frame *Frame, depth int, children map[int][]*Frame) []StructLog {
+	calls := children[frame.Index]
+	for _, step := range frame.Steps {
+		logs = append(logs, StructLog{Pc: step.PC, Op: step.Op, Gas: step.Gas, GasCost: step.Cost, Depth: depth})
+		switch step.Op {
+		case "CALL", "CALLCODE", "DELEGATECALL", "STATICCALL", "CREATE":
+			if len(calls) > 0 {
+				logs = appendStructLogs(logs, calls[0], depth+1, children)
+				calls = calls[1:]
+			}
+		}
+	}
+	return logs
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// stripGas drops the gas of the frames of a call trace save the gas used at its
+// root, which records don't carry.
+func stripGas(trace *CallTrace, root bool) {
+	trace.Gas = nil
+	if !root {
+		trace.GasUsed = nil
+	}
+	for _, call := range trace.Calls {
+		stripGas(call, false)
+	}
+}
+
+// Tests that the records of the golden transactions convert into the traces the
+// callTracer produces for them, and that those traces convert back.
+func TestCallTraceConversion(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatalf("failed to list golden tests: %v", err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		t.Run(name, func(t *testing.T) {
+			var (
+				test  = new(goldenTest)
+				trace struct {
+					Result *CallTrace `json:"result"`
+				}
+			)
+			for path, v := range map[string]interface{}{file: test, filepath.Join("..", "eth", "tracers", "testdata", "call_tracer_"+name+".json"): &trace} {
+				blob, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatalf("failed to read test: %v", err)
+				}
+				if err := json.Unmarshal(blob, v); err != nil {
+					t.Fatalf("failed to parse test: %v", err)
+				}
+			}
+			want := trace.Result
+			stripGas(want, true)
+
+			records, err := recordGolden(test)
+			if err != nil {
+				t.Fatalf("failed to record transaction: %v", err)
+			}
+			r, err := NewReader(bytes.NewReader(records))
+			if err != nil {
+				t.Fatalf("failed to create reader: %v", err)
+			}
+			tx, err := r.Next()
+			if err != nil {
+				t.Fatalf("failed to read transaction: %v", err)
+			}
+			have, _ := json.Marshal(tx.CallTrace())
+			wantBlob, _ := json.Marshal(want)
+			if !bytes.Equal(have, wantBlob) {
+				t.Errorf("call trace mismatch:\nhave %s\nwant %s", have, wantBlob)
+			}
+			converted, err := FromCallTrace(tx.Hash, want)
+			if err != nil {
+				t.Fatalf("failed to convert call trace: %v", err)
+			}
+			if back, _ := json.Marshal(converted.CallTrace()); !bytes.Equal(back, wantBlob) {
+				t.Errorf("call trace changed converting back:\nhave %s\nwant %s", back, wantBlob)
+			}
+		})
+	}
+}
+
+// Tests that the steps of watched frames are interleaved in execution order.
+func TestStructLogs(t *testing.T) {
+	tx := &Transaction{
+		Frames: []*Frame{
+			{Index: 0, Parent: -1, Output: []byte{1}, Steps: []*Step{{PC: 0, Op: "PUSH1", Gas: 100, Cost: 3}, {PC: 2, Op: "CALL", Gas: 97, Cost: 40}, {PC: 3, Op: "STOP", Gas: 50}}},
+			{Index: 1, Parent: 0, Steps: []*Step{{PC: 0, Op: "STOP", Gas: 7}}},
+		},
+		Receipt: &Receipt{GasUsed: 21050},
+	}
+	have, _ := json.Marshal(tx.StructLogs())
+	want := `{"gas":21050,"failed":false,"returnValue":"01","structLogs":[` +
+		`{"pc":0,"op":"PUSH1","gas":100,"gasCost":3,"depth":1},` +
+		`{"pc":2,"op":"CALL","gas":97,"gasCost":40,"depth":1},` +
+		`{"pc":0,"op":"STOP","gas":7,"gasCost":0,"depth":2},` +
+		`{"pc":3,"op":"STOP","gas":50,"gasCost":0,"depth":1}]}`
+	if string(have) != want {
+		t.Errorf("struct logs mismatch:\nhave %s\nwant %s", have, want)
+	}
+	if logs := (&Transaction{Hash: common.Hash{1}}).StructLogs(); len(logs.StructLogs) != 0 {
+		t.Errorf("struct logs of an unrecorded transaction: %v", logs.StructLogs)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Exception summarizes a failed transaction, as announced to the subscribers
+// of a collector once its block is checkpointed.
+type Exception struct {
+	Tx        common.Hash    `json:"tx"`
+	Block     hexutil.Uint64 `json:"block"`
+	BlockHash common.Hash    `json:"blockHash"`
+	From      common.Address `json:"from"`
+	To        common.Address `json:"to"`                 // called or created contract
+	Selector  hexutil.Bytes  `json:"selector,omitempty"` // of the called function, nil for creations and plain transfers
+	Error     string         `json:"error"`              // error of the outermost frame, empty if unknown
+	Kind      string         `json:"kind"`               // classification of the error
+	GasUsed   hexutil.Uint64 `json:"gasUsed"`
+}
+
+// Exception kinds failed transactions are classified into, by the error of
+// their outermost frame. Partitions also hold successful and rejected ones.
+const (
+	KindSuccess       = "success"
+	KindRevert        = "revert"
+	KindOutOfGas      = "outofgas"
+	KindInvalidJump   = "invalidjump"
+	KindInvalidOpcode = "invalidopcode"
+	KindStack         = "stack" // stack underflow or overflow
+	KindRejected      = "rejected"
+	KindOther         = "other"
+)
+
+// ExceptionKind classifies the error of the outermost frame of a failed
+// transaction.
+func ExceptionKind(err string) string {
+	switch vm.ParseError(err) {
+	case vm.ErrExecutionReverted:
+		return KindRevert
+	case vm.ErrOutOfGas, vm.ErrCodeStoreOutOfGas:
+		return KindOutOfGas
+	case vm.ErrInvalidJump:
+		return KindInvalidJump
+	case vm.ErrInvalidOpCode:
+		return KindInvalidOpcode
+	case vm.ErrStackUnderflow, vm.ErrStackOverflow:
+		return KindStack
+	}
+	return KindOther
+}
+
+// exceptionSub is a subscriber to the failed transactions of a collector.
+type exceptionSub struct {
+	ch chan<- *Exception
+}
+
+// SubscribeExceptions subscribes to the failed transactions of every block
+// checkpointed from now on, including blocks orphaned later. Block processing
+// never waits on subscribers: failed transactions are dropped for those whose
+// channel is full, so it should be buffered.
+func (c *Collector) SubscribeExceptions(ch chan<- *Exception) event.Subscription {
+	sub := &exceptionSub{ch: ch}
+
+	c.subLock.Lock()
+	if c.exceptionSubs == nil {
+		c.exceptionSubs = make(map[*exceptionSub]struct{})
+	}
+	c.exceptionSubs[sub] = struct{}{}
+	c.subLock.Unlock()
+
+	return c.scope.Track(event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		c.subLock.Lock()
+		delete(c.exceptionSubs, sub)
+		c.subLock.Unlock()
+		return nil
+	}))
+}
+
+// trackException gathers the summary of the transaction being recorded from its
+// outermost frame and receipt, keeping it until the block is checkpointed if
+// the transaction failed.
+func (c *Collector) trackException(record map[string]interface{}) {
+	switch record["method"] {
+	case "Receipt":
+		if c.exception != nil && record["failed"] == true {
+			c.exception.Tx, _ = record["tx"].(common.Hash)
+			c.exception.Kind = ExceptionKind(c.exception.Error)
+			if gas, ok := record["gasUsed"].(uint64); ok {
+				c.exception.GasUsed = hexutil.Uint64(gas)
+			}
+			if to, ok := record["contractAddress"].(common.Address); ok && to != (common.Address{}) {
+				c.exception.To = to
+			}
+			c.pendingExceptions = append(c.pendingExceptions, c.exception)
+		}
+		c.exception = nil
+	case "Return":
+		if c.exception != nil && record["index"] == 0 {
+			c.exception.Error, _ = record["error"].(string)
+		}
+	default:
+		if record["parent"] == -1 {
+			c.exception = new(Exception)
+			c.exception.From, _ = record["from"].(common.Address)
+			c.exception.To, _ = record["to"].(common.Address)
+			c.exception.Selector, _ = record["selector"].(hexutil.Bytes)
+			if record["calleeExecuted"] == false {
+				c.exception.Error, _ = record["error"].(string)
+			}
+		}
+	}
+}
+
+// settleExceptions sets the block of the failed transactions of a checkpointed
+// block, returning them to be announced.
+func (c *Collector) settleExceptions(number uint64, hash common.Hash) []*Exception {
+	for _, exception := range c.pendingExceptions {
+		exception.Block, exception.BlockHash = hexutil.Uint64(number), hash
+	}
+	exceptions := c.pendingExceptions
+	c.pendingExceptions = nil
+	return exceptions
+}
+
+// announceExceptions sends the failed transactions of a checkpointed block to
+// the subscribers, dropping them for those not keeping up.
+func (c *Collector) announceExceptions(exceptions []*Exception) {
+	if len(exceptions) == 0 {
+		return
+	}
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+
+	dropped := 0
+	for _, exception := range exceptions {
+		for sub := range c.exceptionSubs {
+			select {
+			case sub.ch <- exception:
+			default:
+				dropped++
+			}
+		}
+	}
+	if dropped > 0 {
+		exceptionDropMeter.Mark(int64(dropped))
+		logger.Warn("Dropped failed transactions of slow subscribers", "count", dropped)
+	}
+}
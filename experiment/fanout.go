@@ -0,0 +1,127 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"io"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// fanoutSink is a recorder written by a Fanout, along with its failure.
+type fanoutSink struct {
+	recorder vm.Recorder
+	optional bool
+	err      error // first failure of an optional recorder, which is then skipped
+}
+
+// Fanout is a recorder writing every record into several recorders, each
+// buffering and checkpointing on its own (e.g. collectors writing to different
+// disks). Every recorder receives every record even if another one failed, so
+// a failing recorder doesn't cost the others any data.
+//
+// Failures of required recorders are returned, interrupting the node as usual.
+// A failing optional recorder is logged and skipped from then on, leaving the
+// node and the other recorders running.
+type Fanout struct {
+	sinks []*fanoutSink
+	lock  sync.Mutex
+}
+
+// NewFanout creates a recorder writing into the required recorder and the
+// optional ones.
+func NewFanout(required vm.Recorder, optional ...vm.Recorder) *Fanout {
+	f := &Fanout{sinks: []*fanoutSink{{recorder: required}}}
+	for _, recorder := range optional {
+		f.sinks = append(f.sinks, &fanoutSink{recorder: recorder, optional: true})
+	}
+	return f
+}
+
+// Record implements vm.Recorder, writing the record into every recorder.
+func (f *Fanout) Record(record map[string]interface{}) error {
+	return f.each(func(recorder vm.Recorder) error {
+		return recorder.Record(record)
+	})
+}
+
+// Checkpoint implements vm.Checkpointer, checkpointing every recorder tracking
+// its progress.
+func (f *Fanout) Checkpoint(number uint64, hash common.Hash) error {
+	return f.each(func(recorder vm.Recorder) error {
+		if checkpointer, ok := recorder.(vm.Checkpointer); ok {
+			return checkpointer.Checkpoint(number, hash)
+		}
+		return nil
+	})
+}
+
+// Close closes every recorder implementing io.Closer, including failed ones.
+func (f *Fanout) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var err error
+	for _, sink := range f.sinks {
+		if closer, ok := sink.recorder.(io.Closer); ok {
+			if closeErr := closer.Close(); err == nil && !sink.optional {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+// Failures returns the failures of the optional recorders skipped so far, by
+// their position among the optional recorders.
+func (f *Fanout) Failures() map[int]error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	failures := make(map[int]error)
+	for i, sink := range f.sinks[1:] {
+		if sink.err != nil {
+			failures[i] = sink.err
+		}
+	}
+	return failures
+}
+
+// each calls fn with every recorder not skipped, returning the failure of the
+// required one.
+func (f *Fanout) each(fn func(vm.Recorder) error) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var err error
+	for i, sink := range f.sinks {
+		if sink.err != nil {
+			continue
+		}
+		if sinkErr := fn(sink.recorder); sinkErr != nil {
+			if !sink.optional {
+				err = sinkErr
+				continue
+			}
+			logger.Error("Optional transaction data recorder failed, skipping it", "recorder", i-1, "err", sinkErr)
+			sink.err = sinkErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+var update = flag.Bool("update", false, "overwrite the golden record files with the current records")
+
+// goldenConfig is the recording configuration the golden records are produced
+// with, covering every optional field of the records save the bytecode.
+var goldenConfig = vm.RecordConfig{Output: true, AccountState: true, GasProfile: true, Resources: true, AccessList: true}
+
+// goldenContext is the block a golden transaction was executed in.
+type goldenContext struct {
+	Number     math.HexOrDecimal64   `json:"number"`
+	Difficulty *math.HexOrDecimal256 `json:"difficulty"`
+	Time       math.HexOrDecimal64   `json:"timestamp"`
+	GasLimit   math.HexOrDecimal64   `json:"gasLimit"`
+	Miner      common.Address        `json:"miner"`
+}
+
+// goldenTest is a historical transaction, RLP encoded, with the state it was
+// executed on.
+type goldenTest struct {
+	Genesis *core.Genesis  `json:"genesis"`
+	Context *goldenContext `json:"context"`
+	Input   string         `json:"input"`
+}
+
+// Tests that historical transactions produce the records in the golden files
+// next to them, catching unintended changes of the record format. Run with
+// -update to accept intended changes.
+func TestGoldenRecords(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatalf("failed to list golden tests: %v", err)
+	}
+	for _, file := range files {
+		file := file // capture range variable
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			blob, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatalf("failed to read test: %v", err)
+			}
+			test := new(goldenTest)
+			if err := json.Unmarshal(blob, test); err != nil {
+				t.Fatalf("failed to parse test: %v", err)
+			}
+			have, err := recordGolden(test)
+			if err != nil {
+				t.Fatalf("failed to record transaction: %v", err)
+			}
+			golden := strings.TrimSuffix(file, ".json") + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(golden, have, 0644); err != nil {
+					t.Fatalf("failed to update golden records: %v", err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden records: %v", err)
+			}
+			haveLines, wantLines := bytes.Split(have, []byte{'\n'}), bytes.Split(want, []byte{'\n'})
+			for i := 0; i < len(haveLines) && i < len(wantLines); i++ {
+				if !bytes.Equal(haveLines[i], wantLines[i]) {
+					t.Fatalf("record %d mismatch:\nhave %s\nwant %s", i, haveLines[i], wantLines[i])
+				}
+			}
+			if len(haveLines) != len(wantLines) {
+				t.Fatalf("record count mismatch: have %d, want %d", len(haveLines)-1, len(wantLines)-1)
+			}
+		})
+	}
+}
+
+// recordGolden executes the transaction of the test on its state, returning the
+// records it produced as JSON lines.
+func recordGolden(test *goldenTest) ([]byte, error) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		return nil, err
+	}
+	header := &types.Header{
+		Number:     new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:       new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty: (*big.Int)(test.Context.Difficulty),
+		GasLimit:   uint64(test.Context.GasLimit),
+		Coinbase:   test.Context.Miner,
+	}
+	statedb := tests.MakePreState(ethdb.NewMemDatabase(), test.Genesis.Alloc)
+
+	var (
+		memory  = NewMemory()
+		usedGas uint64
+		gp      = new(core.GasPool).AddGas(header.GasLimit)
+	)
+	cfg := vm.Config{Recorder: memory, RecordConfig: goldenConfig}
+	if _, _, err := core.ApplyTransaction(test.Genesis.Config, nil, &header.Coinbase, gp, statedb, header, tx, &usedGas, cfg); err != nil {
+		return nil, err
+	}
+	if err := memory.Checkpoint(header.Number.Uint64(), header.Hash()); err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, record := range memory.Records("") {
+		if err := enc.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// DefaultHotspotsInterval is the least time between two saves of the hotspots,
+// unless set otherwise.
+const DefaultHotspotsInterval = time.Minute
+
+// Hotspot is a contract, or a function of it, frequently called by failed
+// transactions. Counts are approximate: the true count lies between Count-Error
+// and Count.
+type Hotspot struct {
+	Contract common.Address `json:"contract"`
+	Selector hexutil.Bytes  `json:"selector,omitempty"` // nil if counting the contract as a whole
+	Count    uint64         `json:"count"`
+	Error    uint64         `json:"error"` // largest possible overcount
+}
+
+// Hotspots are the contracts and functions called by the most failed
+// transactions, most frequent first.
+type Hotspots struct {
+	Contracts []*Hotspot `json:"contracts"`
+	Functions []*Hotspot `json:"functions"`
+}
+
+// spaceSaving tracks the most frequent keys of a stream in constant space with
+// the Space-Saving algorithm: once full, a new key replaces the least frequent
+// one, inheriting its count as the error.
+type spaceSaving struct {
+	capacity int
+	counters map[string]*Hotspot
+}
+
+// newSpaceSaving creates a sketch tracking up to capacity keys.
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{capacity: capacity, counters: make(map[string]*Hotspot)}
+}
+
+// add counts an occurrence of the contract, or the function of it.
+func (s *spaceSaving) add(contract common.Address, selector []byte) {
+	key := string(append(contract.Bytes(), selector...))
+	if counter := s.counters[key]; counter != nil {
+		counter.Count++
+		return
+	}
+	counter := &Hotspot{Contract: contract, Count: 1}
+	if selector != nil {
+		counter.Selector = common.CopyBytes(selector)
+	}
+	if len(s.counters) >= s.capacity {
+		var (
+			minKey string
+			min    *Hotspot
+		)
+		for key, candidate := range s.counters {
+			if min == nil || candidate.Count < min.Count {
+				minKey, min = key, candidate
+			}
+		}
+		delete(s.counters, minKey)
+		counter.Count, counter.Error = min.Count+1, min.Count
+	}
+	s.counters[key] = counter
+}
+
+// top returns copies of the n most frequent keys, all of them if n is zero.
+func (s *spaceSaving) top(n int) []*Hotspot {
+	hotspots := make([]*Hotspot, 0, len(s.counters))
+	for _, counter := range s.counters {
+		cpy := *counter
+		hotspots = append(hotspots, &cpy)
+	}
+	sort.Slice(hotspots, func(i, j int) bool {
+		if hotspots[i].Count != hotspots[j].Count {
+			return hotspots[i].Count > hotspots[j].Count
+		}
+		return string(append(hotspots[i].Contract.Bytes(), hotspots[i].Selector...)) < string(append(hotspots[j].Contract.Bytes(), hotspots[j].Selector...))
+	})
+	if n > 0 && n < len(hotspots) {
+		hotspots = hotspots[:n]
+	}
+	return hotspots
+}
+
+// hotspots tracks the contracts and functions called by the most failed
+// transactions.
+type hotspots struct {
+	contracts *spaceSaving
+	functions *spaceSaving
+	interval  time.Duration // least time between two saves
+	saved     time.Time     // time the hotspots were last persisted
+}
+
+// hotspotsPath returns the file the hotspots of the run recorded into the given
+// record file are persisted into.
+func hotspotsPath(path string) string {
+	return path + ".hotspots"
+}
+
+// Hotspots returns the n contracts and functions called by the most failed
+// transactions so far, or nil if they aren't tracked.
+func (c *Collector) Hotspots(n int) *Hotspots {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.hotspots == nil {
+		return nil
+	}
+	return &Hotspots{Contracts: c.hotspots.contracts.top(n), Functions: c.hotspots.functions.top(n)}
+}
+
+// countHotspots counts the failed transactions of a checkpointed block into the
+// hotspots, persisting them if they weren't saved for a while.
+func (c *Collector) countHotspots(exceptions []*Exception) error {
+	for _, exception := range exceptions {
+		c.hotspots.contracts.add(exception.To, nil)
+		if exception.Selector != nil {
+			c.hotspots.functions.add(exception.To, exception.Selector)
+		}
+	}
+	if c.dryRun || time.Since(c.hotspots.saved) < c.hotspots.interval {
+		return nil
+	}
+	return c.saveHotspots()
+}
+
+// saveHotspots atomically persists the hotspots next to the record file. Only
+// the hotspots of blocks checkpointed by then are saved, so they are a little
+// behind the records if the node is interrupted.
+func (c *Collector) saveHotspots() error {
+	blob, err := json.Marshal(&Hotspots{Contracts: c.hotspots.contracts.top(0), Functions: c.hotspots.functions.top(0)})
+	if err != nil {
+		return err
+	}
+	tmp := hotspotsPath(c.path) + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, hotspotsPath(c.path)); err != nil {
+		return err
+	}
+	c.hotspots.saved = time.Now()
+	return nil
+}
+
+// loadHotspots loads the hotspots persisted by the previous session of the run.
+func (c *Collector) loadHotspots() error {
+	blob, err := ioutil.ReadFile(hotspotsPath(c.path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved Hotspots
+	if err := json.Unmarshal(blob, &saved); err != nil {
+		return err
+	}
+	for sketch, hotspots := range map[*spaceSaving][]*Hotspot{c.hotspots.contracts: saved.Contracts, c.hotspots.functions: saved.Functions} {
+		for _, hotspot := range hotspots {
+			if len(sketch.counters) < sketch.capacity {
+				sketch.counters[string(append(hotspot.Contract.Bytes(), hotspot.Selector...))] = hotspot
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Kinds of index entries.
+const (
+	indexTx        byte = 't' // first record of a transaction
+	indexOrphaned  byte = 'o' // block marked as orphaned
+	indexCanonical byte = 'c' // block marked as canonical again
+)
+
+// indexEntrySize is the size of an encoded index entry: its kind, the hash of
+// the transaction or block, and the offset of the record in the record file.
+const indexEntrySize = 1 + common.HashLength + 8
+
+// indexEntry locates a record in the record file. In compressed files the
+// offset is the one of the gzip member containing the record.
+type indexEntry struct {
+	kind   byte
+	hash   common.Hash
+	offset int64
+}
+
+// indexPath returns the file the index of a record file is kept in.
+func indexPath(path string) string {
+	return path + ".index"
+}
+
+// indexRecord adds an index entry for the record if it is the first one of a
+// transaction or changes the status of a block. The offset is relative to the
+// pending records.
+func (c *Collector) indexRecord(record map[string]interface{}, offset int64) {
+	if tx, ok := record["tx"].(common.Hash); ok {
+		if tx != c.lastTx {
+			c.pendingIndex = append(c.pendingIndex, indexEntry{kind: indexTx, hash: tx, offset: offset})
+			c.lastTx = tx
+		}
+		return
+	}
+	if record["method"] != "BlockStatus" {
+		return
+	}
+	hash, _ := record["blockHash"].(common.Hash)
+	kind := indexCanonical
+	if orphaned, _ := record["orphaned"].(bool); orphaned {
+		kind = indexOrphaned
+	}
+	c.pendingIndex = append(c.pendingIndex, indexEntry{kind: kind, hash: hash, offset: offset})
+}
+
+// flushIndex writes the pending index entries into the index file, given the
+// offset the pending records were written at.
+func (c *Collector) flushIndex(base int64) error {
+	if len(c.pendingIndex) == 0 {
+		return nil
+	}
+	blob := make([]byte, 0, len(c.pendingIndex)*indexEntrySize)
+	for _, entry := range c.pendingIndex {
+		offset := base
+		if c.codec != codecGzip {
+			offset += entry.offset
+		}
+		blob = append(blob, entry.kind)
+		blob = append(blob, entry.hash[:]...)
+		blob = append(blob, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(blob[len(blob)-8:], uint64(offset))
+	}
+	n, err := c.index.Write(blob)
+	c.progress.IndexSize += int64(n)
+	c.pendingIndex, c.lastTx = c.pendingIndex[:0], common.Hash{}
+	return err
+}
+
+// txIndex is the index of a record file loaded into memory. It is read once as
+// the record file is opened, then only from where it was left as entries are
+// appended by a collector still recording into the file.
+type txIndex struct {
+	file     *os.File
+	size     int64                   // bytes of the index loaded so far
+	txs      map[common.Hash][]int64 // offsets of the records of every transaction
+	orphaned map[common.Hash]bool    // final status of all blocks marked in the records
+}
+
+// openIndex opens and loads the index kept in the given file.
+func openIndex(path string) (*txIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	index := &txIndex{file: file}
+	if err := index.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return index, nil
+}
+
+// load reads the entries appended to the index since it was last loaded, or
+// the whole index again if it was truncated by a resumed run.
+func (index *txIndex) load() error {
+	info, err := index.file.Stat()
+	if err != nil {
+		return err
+	}
+	if index.txs == nil || info.Size() < index.size {
+		index.size, index.txs, index.orphaned = 0, make(map[common.Hash][]int64), make(map[common.Hash]bool)
+	}
+	if _, err := index.file.Seek(index.size, io.SeekStart); err != nil {
+		return err
+	}
+	var (
+		buf   = bufio.NewReader(index.file)
+		entry = make([]byte, indexEntrySize)
+	)
+	for {
+		if _, err := io.ReadFull(buf, entry); err == io.EOF || err == io.ErrUnexpectedEOF {
+			// An entry being written is loaded by the next lookup
+			return nil
+		} else if err != nil {
+			return err
+		}
+		index.size += indexEntrySize
+
+		key := common.BytesToHash(entry[1 : 1+common.HashLength])
+		switch entry[0] {
+		case indexTx:
+			index.txs[key] = append(index.txs[key], int64(binary.BigEndian.Uint64(entry[1+common.HashLength:])))
+		case indexOrphaned, indexCanonical:
+			index.orphaned[key] = entry[0] == indexOrphaned
+		}
+	}
+}
+
+// lookup returns the offsets of the records of the given transaction and the
+// final status of all blocks marked in the records.
+func (index *txIndex) lookup(hash common.Hash) ([]int64, map[common.Hash]bool, error) {
+	if err := index.load(); err != nil {
+		return nil, nil, err
+	}
+	return index.txs[hash], index.orphaned, nil
+}
+
+// Close closes the file of the index.
+func (index *txIndex) Close() error {
+	return index.file.Close()
+}
+
+// txByIndex looks the transaction with the given hash up through the index of
+// the record file, returning the one recorded in the canonical chain.
+func (r *Reader) txByIndex(hash common.Hash) (*Transaction, error) {
+	offsets, orphaned, err := r.index.lookup(hash)
+	if err != nil {
+		return nil, err
+	}
+	seeker, ok := r.src.(io.Seeker)
+	if !ok {
+		return nil, errNotSeekable
+	}
+	for _, offset := range offsets {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := r.reset(); err != nil {
+			return nil, err
+		}
+		// Compressed offsets point to the block, skip the transactions before
+		for {
+			tx, err := r.Next()
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return nil, err
+			}
+			if tx.Hash != hash {
+				continue
+			}
+			if !orphaned[tx.BlockHash] {
+				return tx, nil
+			}
+			break
+		}
+	}
+	return nil, ErrNotFound
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Memory is a recorder keeping the records in memory instead of a file, for
+// tests and tools executing a few transactions. Like a Collector, it only keeps
+// the records of checkpointed blocks; records are encoded as they would be in a
+// record file, so they decode into the same transactions.
+type Memory struct {
+	records []map[string]interface{} // checkpointed records, in recording order
+	encoded bytes.Buffer             // checkpointed records, encoded
+	pending []map[string]interface{} // records of the block being processed
+	lock    sync.Mutex
+}
+
+// NewMemory creates an empty in-memory recorder.
+func NewMemory() *Memory {
+	return new(Memory)
+}
+
+// Record implements vm.Recorder, buffering the record until its block is
+// checkpointed.
+func (m *Memory) Record(record map[string]interface{}) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.pending = append(m.pending, record)
+	return nil
+}
+
+// Checkpoint implements vm.Checkpointer, keeping the records of the block.
+func (m *Memory) Checkpoint(number uint64, hash common.Hash) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	enc := json.NewEncoder(&m.encoded)
+	for _, record := range m.pending {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	m.records, m.pending = append(m.records, m.pending...), nil
+	return nil
+}
+
+// Records returns the checkpointed records of the given method, or all of them
+// if method is empty.
+func (m *Memory) Records(method string) []map[string]interface{} {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var records []map[string]interface{}
+	for _, record := range m.records {
+		if method == "" || record["method"] == method {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// Reader creates a reader over the checkpointed records. The reader isn't
+// affected by records checkpointed later on.
+func (m *Memory) Reader() (*Reader, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return NewReader(bytes.NewReader(m.encoded.Bytes()))
+}
+
+// Transactions decodes the checkpointed records into transactions.
+func (m *Memory) Transactions() ([]*Transaction, error) {
+	r, err := m.Reader()
+	if err != nil {
+		return nil, err
+	}
+	var txs []*Transaction
+	for {
+		tx, err := r.Next()
+		if err == io.EOF {
+			return txs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+}
+
+// Reset drops all records.
+func (m *Memory) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.records, m.pending = nil, nil
+	m.encoded.Reset()
+}
@@ -0,0 +1,37 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the metrics collected by the collector.
+
+package experiment
+
+import (
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	recordTimer = metrics.NewRegisteredTimer("experiment/records/encode", nil)
+	recordMeter = metrics.NewRegisteredMeter("experiment/records/in", nil)
+	dropMeter   = metrics.NewRegisteredMeter("experiment/records/drop", nil)
+
+	violationMeter = metrics.NewRegisteredMeter("experiment/records/invalid", nil)
+	failureMeter   = metrics.NewRegisteredMeter("experiment/records/fail", nil)
+
+	checkpointTimer = metrics.NewRegisteredTimer("experiment/checkpoints/write", nil)
+	writeMeter      = metrics.NewRegisteredMeter("experiment/checkpoints/bytes", nil)
+
+	exceptionDropMeter = metrics.NewRegisteredMeter("experiment/exceptions/drop", nil)
+)
@@ -0,0 +1,208 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// partitionPath returns the record file of the partition of the given kind.
+func partitionPath(path, kind string) string {
+	return path + "." + kind
+}
+
+// Partitions is a recorder routing the records of every transaction into a
+// collector per exception kind, so users of a single kind (e.g. reverts) query
+// a much smaller record file. The records of a transaction are held until its
+// receipt tells its kind; records not belonging to any transaction (block
+// summaries and statuses) go to every partition.
+//
+// Partitions are created with the first transaction of their kind, recording
+// into the path of the partitions suffixed with the kind, e.g. records.revert.
+type Partitions struct {
+	path   string
+	opts   []Option
+	config vm.RecordConfig
+
+	collectors map[string]*Collector
+	tx         common.Hash              // transaction being recorded
+	pending    []map[string]interface{} // its records until its kind is known
+	kind       string                   // its kind, empty until its receipt
+	err        string                   // error of its outermost frame
+	rejected   bool                     // whether it was rejected before its execution
+
+	lock sync.Mutex
+}
+
+// NewPartitions creates a recorder partitioning the records into collectors
+// with the given options, recording into the given path suffixed with the kind
+// of their transactions.
+func NewPartitions(path string, opts ...Option) *Partitions {
+	template := new(Collector)
+	for _, opt := range opts {
+		opt(template)
+	}
+	return &Partitions{path: path, opts: opts, config: template.config, collectors: make(map[string]*Collector)}
+}
+
+// Attach configures the EVM to record into the partitions.
+func (p *Partitions) Attach(cfg *vm.Config) {
+	cfg.Recorder = p
+	cfg.RecordConfig = p.config
+}
+
+// Record implements vm.Recorder, routing the record into the partition of its
+// transaction once known.
+func (p *Partitions) Record(record map[string]interface{}) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	hash, ok := record["tx"].(common.Hash)
+	if !ok {
+		// Records outside of any transaction complete the current one
+		if err := p.flush(); err != nil {
+			return err
+		}
+		for _, kind := range p.kinds() {
+			if err := p.collectors[kind].Record(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if hash != p.tx {
+		if err := p.flush(); err != nil {
+			return err
+		}
+		p.tx = hash
+	}
+	if p.kind != "" {
+		return p.collectors[p.kind].Record(record)
+	}
+	p.pending = append(p.pending, record)
+
+	switch record["method"] {
+	case "Receipt":
+		p.kind = KindSuccess
+		if record["failed"] == true {
+			p.kind = ExceptionKind(p.err)
+		}
+		return p.route()
+	case "Rejected":
+		p.rejected = true
+	case "Return":
+		if record["index"] == 0 {
+			p.err, _ = record["error"].(string)
+		}
+	default:
+		if record["parent"] == -1 && record["calleeExecuted"] == false {
+			p.err, _ = record["error"].(string)
+		}
+	}
+	return nil
+}
+
+// route writes the held records of the transaction into its partition, creating
+// the partition if it's the first transaction of its kind.
+func (p *Partitions) route() error {
+	collector := p.collectors[p.kind]
+	if collector == nil {
+		var err error
+		opts := append(append([]Option{}, p.opts...), WithPath(partitionPath(p.path, p.kind)))
+		if collector, err = New(opts...); err != nil {
+			return err
+		}
+		p.collectors[p.kind] = collector
+	}
+	for _, record := range p.pending {
+		if err := collector.Record(record); err != nil {
+			return err
+		}
+	}
+	p.pending = nil
+	return nil
+}
+
+// flush finishes the transaction being recorded, routing the records held if
+// it never got a receipt (e.g. it was rejected).
+func (p *Partitions) flush() error {
+	var err error
+	if len(p.pending) > 0 {
+		if p.kind = KindOther; p.rejected {
+			p.kind = KindRejected
+		}
+		err = p.route()
+	}
+	p.tx, p.pending, p.kind, p.err, p.rejected = common.Hash{}, nil, "", "", false
+	return err
+}
+
+// Checkpoint implements vm.Checkpointer, checkpointing every partition.
+func (p *Partitions) Checkpoint(number uint64, hash common.Hash) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := p.flush(); err != nil {
+		return err
+	}
+	for _, kind := range p.kinds() {
+		if err := p.collectors[kind].Checkpoint(number, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every partition.
+func (p *Partitions) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var err error
+	for _, kind := range p.kinds() {
+		if closeErr := p.collectors[kind].Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Paths returns the record files of the partitions created so far, by kind.
+func (p *Partitions) Paths() map[string]string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	paths := make(map[string]string, len(p.collectors))
+	for kind := range p.collectors {
+		paths[kind] = partitionPath(p.path, kind)
+	}
+	return paths
+}
+
+// kinds returns the kinds of the partitions created so far, in order.
+func (p *Partitions) kinds() []string {
+	kinds := make([]string, 0, len(p.collectors))
+	for kind := range p.collectors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
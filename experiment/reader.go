@@ -0,0 +1,640 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Frame is a recorded call frame (Call, Transfer, CallCode, DelegateCall,
+// StaticCall or Create), merged with its return data if recorded.
+type Frame struct {
+	Method          string          `json:"method"`
+	External        bool            `json:"external"`
+	From            common.Address  `json:"from"`
+	To              *common.Address `json:"to"` // nil for contract creations
+	Value           *big.Int        `json:"value"`
+	Data            hexutil.Bytes   `json:"data"`
+	DataTruncated   bool            `json:"dataTruncated"`
+	Selector        hexutil.Bytes   `json:"selector"` // first 4 bytes of the data, nil for creations and shorter data
+	Index           int             `json:"index"`
+	Parent          int             `json:"parent"`
+	Static          bool            `json:"static"`         // true if the frame can't modify the state
+	Delegated       bool            `json:"delegated"`      // true if the frame runs with the sender and value of its parent
+	CodeAddress     *common.Address `json:"codeAddress"`    // account the executed code belongs to, nil if rejected
+	StorageAddress  *common.Address `json:"storageAddress"` // account whose storage the code runs on, nil if rejected
+	CodeHash        *common.Hash    `json:"codeHash"`       // nil if the callee has no code
+	Output          hexutil.Bytes   `json:"output"`
+	OutputTruncated bool            `json:"outputTruncated"`
+	CalleeExecuted  bool            `json:"calleeExecuted"` // false if the call failed before its callee ran
+	Error           string          `json:"error"`          // reason of the failure, empty if the frame succeeded
+	OriginDepth     int             `json:"originDepth"`    // depth of the frame the failure originated at
+	PropagatedFrom  int             `json:"propagatedFrom"` // index of the failed child frame propagated, -1 if none
+	MaxMemory       int             `json:"maxMemory"`      // largest memory size reached in bytes, if recorded
+	MaxStack        int             `json:"maxStack"`       // largest data stack depth reached, if recorded
+	FromBalance     *big.Int        `json:"fromBalance"`    // balance of the caller as the frame began, if recorded
+	FromNonce       uint64          `json:"fromNonce"`      // nonce of the caller as the frame began, if recorded
+	ToBalance       *big.Int        `json:"toBalance"`      // balance of the callee as the frame began, if recorded
+	ToNonce         uint64          `json:"toNonce"`        // nonce of the callee as the frame began, if recorded
+	Refund          uint64          `json:"refund"`         // refund counter as the frame began, if recorded
+	RefundEnd       uint64          `json:"-"`              // refund counter as the frame returned, reverted if it failed, if recorded
+	Fault           *Fault          `json:"-"`              // operation the frame faulted at, if recorded
+	Steps           []*Step         `json:"-"`              // executed operations, only for watched accounts
+}
+
+// Fault is the context of the operation a frame faulted at (e.g. an invalid jump
+// or a stack underflow), as the operation found it. Frames reverting or failing
+// because of a failed call don't fault.
+type Fault struct {
+	PC        uint64         `json:"pc"`
+	Op        string         `json:"op"`
+	Stack     []*hexutil.Big `json:"stack"`     // top items of the stack, top first
+	Code      hexutil.Bytes  `json:"code"`      // code around the operation
+	CodeStart uint64         `json:"codeStart"` // offset of the code around the operation
+	Jump      *InvalidJump   `json:"jump"`      // destination of an invalid jump, nil for other faults
+}
+
+// InvalidJump is the destination of a jump which isn't a JUMPDEST operation,
+// along with the valid ones nearest to it.
+type InvalidJump struct {
+	Destination  *hexutil.Big `json:"destination"`
+	InPushData   bool         `json:"inPushData"`   // whether the destination is data of a PUSH operation
+	OutOfCode    bool         `json:"outOfCode"`    // whether the destination is past the end of the code
+	PrevJumpdest *uint64      `json:"prevJumpdest"` // nil if none before the destination
+	NextJumpdest *uint64      `json:"nextJumpdest"` // nil if none after the destination
+}
+
+// Step is a recorded operation executed by the frame of a watched account.
+type Step struct {
+	PC      uint64        `json:"pc"`
+	Op      string        `json:"op"`
+	Gas     uint64        `json:"gas"` // gas available before the operation
+	Cost    uint64        `json:"cost"`
+	Storage *StorageWrite `json:"storage"` // nil unless the operation writes storage
+}
+
+// StorageWrite is a storage slot written by a step.
+type StorageWrite struct {
+	Key  common.Hash `json:"key"`
+	From common.Hash `json:"from"`
+	To   common.Hash `json:"to"`
+}
+
+// Code is the recorded code of a contract, executed by at least one frame.
+type Code struct {
+	Hash common.Hash   `json:"codeHash"`
+	Size int           `json:"size"`
+	Code hexutil.Bytes `json:"code"`
+}
+
+// Selfdestruct is a recorded self-destruct, moving the remaining balance of a
+// contract to its beneficiary.
+type Selfdestruct struct {
+	From   common.Address `json:"from"`
+	To     common.Address `json:"to"`
+	Value  *big.Int       `json:"value"`
+	Parent int            `json:"parent"`
+}
+
+// Precompile is a recorded execution of a precompiled contract.
+type Precompile struct {
+	Address     common.Address `json:"address"`
+	InputSize   int            `json:"inputSize"`
+	OutputSize  int            `json:"outputSize"`
+	RequiredGas uint64         `json:"requiredGas"`
+	Error       string         `json:"error"` // failure reported by the contract, if any
+	Parent      int            `json:"parent"`
+}
+
+// OpcodeGas is the recorded gas consumed by the executions of an opcode within
+// a transaction. Gas passed on to calls is accounted to the callee, and the
+// operation running out of gas is accounted the remaining gas it burnt.
+type OpcodeGas struct {
+	Count uint64 `json:"count"`
+	Gas   uint64 `json:"gas"`
+}
+
+// Refund is the gas refunded to the sender of a transaction: the refund counter
+// accumulated by its execution (e.g. by clearing storage), capped to half of
+// the gas used.
+type Refund struct {
+	Counter uint64 `json:"counter"`
+	Limit   uint64 `json:"limit"` // half of the gas used before the refund
+	Applied uint64 `json:"applied"`
+	Capped  bool   `json:"capped"` // whether the counter exceeded the limit
+}
+
+// AccessedAccount is an account accessed by a transaction, along with the
+// storage slots of it accessed. Accounts and slots are written if any access
+// modified them, reverted modifications included.
+type AccessedAccount struct {
+	Address common.Address `json:"address"`
+	Written bool           `json:"written"`
+	Storage []AccessedSlot `json:"storage"`
+}
+
+// AccessedSlot is a storage slot accessed by a transaction.
+type AccessedSlot struct {
+	Key     common.Hash `json:"key"`
+	Written bool        `json:"written"`
+}
+
+// AccountState is the recorded state of an account involved in a transaction.
+type AccountState struct {
+	Address  common.Address `json:"address"`
+	Nonce    uint64         `json:"nonce"`
+	Balance  *big.Int       `json:"balance"`
+	CodeHash common.Hash    `json:"codeHash"`
+}
+
+// Receipt is the recorded outcome of a transaction.
+type Receipt struct {
+	Failed            bool           `json:"failed"`
+	GasUsed           uint64         `json:"gasUsed"`
+	CumulativeGasUsed uint64         `json:"cumulativeGasUsed"`
+	GasPrice          *big.Int       `json:"gasPrice"`
+	ContractAddress   common.Address `json:"contractAddress"`
+	HasLogs           bool           `json:"hasLogs"`
+	FromBalance       *big.Int       `json:"fromBalance"`
+	ToBalance         *big.Int       `json:"toBalance"`
+	Truncated         bool           `json:"truncated"`
+	Logs              []*Log         `json:"logs"` // nil unless logs were recorded
+}
+
+// Log is a recorded log emitted by a transaction.
+type Log struct {
+	Address common.Address `json:"address"`
+	Topics  []common.Hash  `json:"topics"`
+	Data    hexutil.Bytes  `json:"data"`
+}
+
+// Divergence is the outcome of a transaction executed under the actual and the
+// alternative chain configuration of a differential run, where they differ.
+type Divergence struct {
+	Failed      bool   `json:"failed"`
+	GasUsed     uint64 `json:"gasUsed"`
+	Error       string `json:"error"` // error of the outermost frame, empty if unknown
+	AltFailed   bool   `json:"altFailed"`
+	AltGasUsed  uint64 `json:"altGasUsed"`
+	AltError    string `json:"altError"`
+	AltRejected string `json:"altRejected"` // reason the alternative execution was rejected, if it was
+}
+
+// Block is the recorded summary of a processed block.
+type Block struct {
+	Number   uint64         `json:"block"`
+	Hash     common.Hash    `json:"blockHash"`
+	Miner    common.Address `json:"miner"`
+	Time     uint64         `json:"timestamp"`
+	GasUsed  uint64         `json:"gasUsed"`
+	Txs      int            `json:"txs"`
+	Failed   int            `json:"failed"`
+	Failures map[string]int `json:"failures"` // failed transactions by the kind of error of their outermost frame
+}
+
+// SystemOp is a recorded block level balance change not caused by any
+// transaction, like a block reward or the DAO refund.
+type SystemOp struct {
+	Type  string          `json:"type"` // BlockReward or DAORefund
+	From  *common.Address `json:"from"` // nil if the value is minted
+	To    common.Address  `json:"to"`
+	Value *big.Int        `json:"value"`
+}
+
+// Transaction groups all the records of a single transaction.
+type Transaction struct {
+	Hash          common.Hash
+	Block         uint64
+	BlockHash     common.Hash
+	Frames        []*Frame // in the order they were entered
+	Selfdestructs []*Selfdestruct
+	Precompiles   []*Precompile
+	Pre, Post     []AccountState       // only if account states were recorded
+	GasProfile    map[string]OpcodeGas // by opcode name, only if recorded
+	AccessList    []AccessedAccount    // ordered by address, only if recorded
+	Refund        *Refund              // only if recorded
+	Rejected      string               // reason the transaction was rejected before its execution, if recorded
+	Divergence    *Divergence          // outcome under the alternative configuration, if recorded and different
+	Receipt       *Receipt
+}
+
+// Depth returns the maximum nesting depth of the recorded call frames, one for
+// a transaction without internal calls.
+func (tx *Transaction) Depth() int {
+	var (
+		depths = make(map[int]int)
+		max    int
+	)
+	for _, frame := range tx.Frames {
+		depth := depths[frame.Parent] + 1
+		depths[frame.Index] = depth
+		if depth > max {
+			max = depth
+		}
+	}
+	return max
+}
+
+// recordHeader contains the fields shared by all records.
+type recordHeader struct {
+	Method    string       `json:"method"`
+	Tx        *common.Hash `json:"tx"`
+	Block     uint64       `json:"block"`
+	BlockHash common.Hash  `json:"blockHash"`
+	Index     int          `json:"index"`
+	Orphaned  bool         `json:"orphaned"`
+}
+
+var (
+	// ErrNotFound is returned if a queried transaction is not in the records.
+	ErrNotFound = errors.New("transaction not found")
+
+	errNotSeekable = errors.New("records not seekable")
+)
+
+// Reader decodes the records written by a Collector, grouped by transaction.
+type Reader struct {
+	src      io.Reader
+	index    *txIndex // index of the record file, if any
+	dec      *json.Decoder
+	next     *Transaction // transaction being assembled
+	orphaned map[common.Hash]bool
+	codes    map[common.Hash]*Code
+	blocks   map[common.Hash]*Block
+	sysOps   map[common.Hash][]*SystemOp // system operations of the blocks read so far
+	blooms   []*BlockBloom               // blooms of the blocks read so far, if recorded in sparse mode
+}
+
+// NewReader creates a reader decoding the records from r, decompressing them
+// if the record file was written with compression. The queries scanning all
+// the records (TxByHash, TxsByBlockRange, Iterate) require r to be seekable.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{src: r, codes: make(map[common.Hash]*Code), blocks: make(map[common.Hash]*Block)}
+	if err := reader.reset(); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// Open creates a reader over the given record file, which must be closed after
+// use. If the file was recorded with an index, transactions are looked up by
+// hash through it.
+func Open(path string) (*Reader, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	if r.index, err = openIndex(indexPath(path)); err != nil && !os.IsNotExist(err) {
+		fd.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close closes the underlying record file and its index, if any.
+func (r *Reader) Close() error {
+	if r.index != nil {
+		r.index.Close()
+	}
+	if closer, ok := r.src.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// reset starts decoding the records from the current position of the source.
+func (r *Reader) reset() error {
+	buf := bufio.NewReader(r.src)
+	if magic, err := buf.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(buf)
+		if err != nil {
+			return err
+		}
+		buf = bufio.NewReader(zr)
+	}
+	if line, err := buf.Peek(len(header)); err == nil && string(line) == header {
+		buf.Discard(len(header))
+	}
+	r.dec, r.next, r.orphaned, r.sysOps = json.NewDecoder(buf), nil, make(map[common.Hash]bool), make(map[common.Hash][]*SystemOp)
+	return nil
+}
+
+// Rewind moves the reader back to the first record.
+func (r *Reader) Rewind() error {
+	seeker, ok := r.src.(io.Seeker)
+	if !ok {
+		return errNotSeekable
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return r.reset()
+}
+
+// Iterate rewinds the reader and calls fn with every recorded transaction,
+// including those of orphaned blocks, until fn returns an error.
+func (r *Reader) Iterate(fn func(*Transaction) error) error {
+	if err := r.Rewind(); err != nil {
+		return err
+	}
+	for {
+		tx, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
+}
+
+// IterateFrames calls fn with every recorded call frame and the transaction it
+// belongs to, including those of orphaned blocks.
+func (r *Reader) IterateFrames(fn func(*Transaction, *Frame) error) error {
+	return r.Iterate(func(tx *Transaction) error {
+		for _, frame := range tx.Frames {
+			if err := fn(tx, frame); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// TxByHash returns the transaction with the given hash as recorded in the
+// canonical chain, or ErrNotFound. The position of the reader is undefined
+// afterwards.
+func (r *Reader) TxByHash(hash common.Hash) (*Transaction, error) {
+	if r.index != nil {
+		return r.txByIndex(hash)
+	}
+	var found []*Transaction
+	if err := r.Iterate(func(tx *Transaction) error {
+		if tx.Hash == hash {
+			found = append(found, tx)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	// The transaction may have been recorded in several competing blocks
+	for _, tx := range found {
+		if !r.Orphaned(tx.BlockHash) {
+			return tx, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// TxsByBlockRange returns the transactions recorded in the canonical blocks of
+// the given range (both ends included), in recording order.
+func (r *Reader) TxsByBlockRange(from, to uint64) ([]*Transaction, error) {
+	var found []*Transaction
+	if err := r.Iterate(func(tx *Transaction) error {
+		if tx.Block >= from && tx.Block <= to {
+			found = append(found, tx)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	// Blocks are only marked orphaned after their records, filter at the end
+	txs := found[:0]
+	for _, tx := range found {
+		if !r.Orphaned(tx.BlockHash) {
+			txs = append(txs, tx)
+		}
+	}
+	return txs, nil
+}
+
+// Next returns the next transaction, or io.EOF after the last one.
+func (r *Reader) Next() (*Transaction, error) {
+	for {
+		var raw json.RawMessage
+		if err := r.dec.Decode(&raw); err != nil {
+			if err == io.EOF && r.next != nil {
+				tx := r.next
+				r.next = nil
+				return tx, nil
+			}
+			return nil, err
+		}
+		var head recordHeader
+		if err := json.Unmarshal(raw, &head); err != nil {
+			return nil, err
+		}
+		// Records of other transactions or outside of any complete the current one
+		var done *Transaction
+		if r.next != nil && (head.Tx == nil || *head.Tx != r.next.Hash) {
+			done, r.next = r.next, nil
+		}
+		if head.Tx == nil {
+			switch head.Method {
+			case "BlockStatus":
+				r.orphaned[head.BlockHash] = head.Orphaned
+			case "BlockSummary":
+				block := new(Block)
+				if err := json.Unmarshal(raw, block); err != nil {
+					return nil, err
+				}
+				r.blocks[block.Hash] = block
+			case "SystemOp":
+				op := new(SystemOp)
+				if err := json.Unmarshal(raw, op); err != nil {
+					return nil, err
+				}
+				r.sysOps[head.BlockHash] = append(r.sysOps[head.BlockHash], op)
+			case "BlockBloom":
+				bloom := new(BlockBloom)
+				if err := json.Unmarshal(raw, bloom); err != nil {
+					return nil, err
+				}
+				r.blooms = append(r.blooms, bloom)
+			}
+		} else {
+			if r.next == nil {
+				r.next = &Transaction{Hash: *head.Tx, Block: head.Block, BlockHash: head.BlockHash}
+			}
+			if err := r.add(head, raw); err != nil {
+				return nil, err
+			}
+		}
+		if done != nil {
+			return done, nil
+		}
+	}
+}
+
+// add decodes a record into the transaction being assembled.
+func (r *Reader) add(head recordHeader, raw json.RawMessage) error {
+	tx := r.next
+	switch head.Method {
+	case "Return":
+		var ret struct {
+			Output          hexutil.Bytes `json:"output"`
+			OutputTruncated bool          `json:"outputTruncated"`
+			Error           string        `json:"error"`
+			OriginDepth     int           `json:"originDepth"`
+			PropagatedFrom  int           `json:"propagatedFrom"`
+			MaxMemory       int           `json:"maxMemory"`
+			MaxStack        int           `json:"maxStack"`
+			Refund          uint64        `json:"refund"`
+			Fault           *Fault        `json:"fault"`
+		}
+		if err := json.Unmarshal(raw, &ret); err != nil {
+			return err
+		}
+		for i := len(tx.Frames) - 1; i >= 0; i-- {
+			if frame := tx.Frames[i]; frame.Index == head.Index {
+				frame.Output, frame.OutputTruncated = ret.Output, ret.OutputTruncated
+				frame.MaxMemory, frame.MaxStack = ret.MaxMemory, ret.MaxStack
+				frame.RefundEnd = ret.Refund
+				if ret.Error != "" {
+					frame.Error, frame.OriginDepth, frame.PropagatedFrom = ret.Error, ret.OriginDepth, ret.PropagatedFrom
+					frame.Fault = ret.Fault
+				}
+				break
+			}
+		}
+	case "Step":
+		step := new(Step)
+		if err := json.Unmarshal(raw, step); err != nil {
+			return err
+		}
+		for i := len(tx.Frames) - 1; i >= 0; i-- {
+			if frame := tx.Frames[i]; frame.Index == head.Index {
+				frame.Steps = append(frame.Steps, step)
+				break
+			}
+		}
+	case "Selfdestruct":
+		op := new(Selfdestruct)
+		if err := json.Unmarshal(raw, op); err != nil {
+			return err
+		}
+		tx.Selfdestructs = append(tx.Selfdestructs, op)
+	case "Code":
+		code := new(Code)
+		if err := json.Unmarshal(raw, code); err != nil {
+			return err
+		}
+		r.codes[code.Hash] = code
+	case "GasProfile":
+		var profile struct {
+			Ops map[string]OpcodeGas `json:"ops"`
+		}
+		if err := json.Unmarshal(raw, &profile); err != nil {
+			return err
+		}
+		tx.GasProfile = profile.Ops
+	case "AccessList":
+		var list struct {
+			Accounts []AccessedAccount `json:"accounts"`
+		}
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return err
+		}
+		tx.AccessList = list.Accounts
+	case "Refund":
+		tx.Refund = new(Refund)
+		return json.Unmarshal(raw, tx.Refund)
+	case "Precompile":
+		op := new(Precompile)
+		if err := json.Unmarshal(raw, op); err != nil {
+			return err
+		}
+		tx.Precompiles = append(tx.Precompiles, op)
+	case "AccountState":
+		var state struct {
+			Stage    string         `json:"stage"`
+			Accounts []AccountState `json:"accounts"`
+		}
+		if err := json.Unmarshal(raw, &state); err != nil {
+			return err
+		}
+		if state.Stage == "pre" {
+			tx.Pre = state.Accounts
+		} else {
+			tx.Post = state.Accounts
+		}
+	case "Rejected":
+		var rejection struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &rejection); err != nil {
+			return err
+		}
+		tx.Rejected = rejection.Error
+	case "Divergence":
+		tx.Divergence = new(Divergence)
+		return json.Unmarshal(raw, tx.Divergence)
+	case "Receipt":
+		tx.Receipt = new(Receipt)
+		return json.Unmarshal(raw, tx.Receipt)
+	default:
+		frame := &Frame{PropagatedFrom: -1}
+		if err := json.Unmarshal(raw, frame); err != nil {
+			return err
+		}
+		tx.Frames = append(tx.Frames, frame)
+	}
+	return nil
+}
+
+// Code returns the code with the given hash if its record has been read so far,
+// or nil otherwise. Code is recorded right after the first frame executing it.
+func (r *Reader) Code(hash common.Hash) *Code {
+	return r.codes[hash]
+}
+
+// Block returns the summary of the block with the given hash if its record has
+// been read so far, or nil otherwise. Blocks are summarized after the records
+// of their transactions.
+func (r *Reader) Block(hash common.Hash) *Block {
+	return r.blocks[hash]
+}
+
+// SystemOps returns the system operations of the block with the given hash read
+// so far, in recording order. They are recorded after the transactions of their
+// block.
+func (r *Reader) SystemOps(hash common.Hash) []*SystemOp {
+	return r.sysOps[hash]
+}
+
+// Orphaned reports whether the block with the given hash has been marked as
+// orphaned by the records read so far.
+func (r *Reader) Orphaned(hash common.Hash) bool {
+	return r.orphaned[hash]
+}
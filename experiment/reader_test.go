@@ -0,0 +1,518 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// testRecords is a record file of two transactions in a block orphaned later.
+var testRecords = header + `{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","codeHash":"0x0c00000000000000000000000000000000000000000000000000000000000000","data":"0xa9059cbb01","external":true,"from":"0x000000000000000000000000000000000000000a","index":0,"method":"Call","parent":-1,"selector":"0xa9059cbb","to":"0x000000000000000000000000000000000000000b","tx":"0x0a00000000000000000000000000000000000000000000000000000000000000","value":0}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","code":"0x00","codeHash":"0x0c00000000000000000000000000000000000000000000000000000000000000","method":"Code","size":1,"tx":"0x0a00000000000000000000000000000000000000000000000000000000000000"}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","cost":20000,"gas":90000,"index":0,"method":"Step","op":"SSTORE","pc":4,"storage":{"from":"0x0000000000000000000000000000000000000000000000000000000000000000","key":"0x0000000000000000000000000000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000000000000000000000000000007"},"tx":"0x0a00000000000000000000000000000000000000000000000000000000000000"}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","codeAddress":"0x000000000000000000000000000000000000000c","data":"0x","delegated":false,"external":false,"from":"0x000000000000000000000000000000000000000b","fromBalance":9,"fromNonce":1,"index":1,"method":"Transfer","parent":0,"static":true,"storageAddress":"0x000000000000000000000000000000000000000c","to":"0x000000000000000000000000000000000000000c","toBalance":5,"toNonce":0,"tx":"0x0a00000000000000000000000000000000000000000000000000000000000000","value":5}
+{"address":"0x0000000000000000000000000000000000000008","block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","error":"bad elliptic curve pairing size","inputSize":1,"method":"Precompile","outputSize":0,"parent":1,"requiredGas":100000,"tx":"0x0a00000000000000000000000000000000000000000000000000000000000000"}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","index":1,"maxMemory":64,"maxStack":5,"method":"Return","output":"0x02","outputTruncated":false,"tx":"0x0a00000000000000000000000000000000000000000000000000000000000000"}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","error":"evm: execution reverted","index":0,"method":"Return","originDepth":2,"output":"0x","outputTruncated":false,"propagatedFrom":1,"tx":"0x0a00000000000000000000000000000000000000000000000000000000000000"}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","method":"GasProfile","ops":{"PUSH1":{"count":2,"gas":6}},"tx":"0x0a00000000000000000000000000000000000000000000000000000000000000"}
+{"accounts":[{"address":"0x000000000000000000000000000000000000000a","storage":[],"written":true},{"address":"0x000000000000000000000000000000000000000b","storage":[{"key":"0x0000000000000000000000000000000000000000000000000000000000000001","written":true}],"written":true}],"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","method":"AccessList","tx":"0x0a00000000000000000000000000000000000000000000000000000000000000"}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","failed":true,"gasUsed":21000,"method":"Receipt","tx":"0x0a00000000000000000000000000000000000000000000000000000000000000"}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","external":true,"from":"0x000000000000000000000000000000000000000a","index":0,"method":"Create","parent":-1,"to":null,"tx":"0x0b00000000000000000000000000000000000000000000000000000000000000","value":0}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","from":"0x000000000000000000000000000000000000000d","method":"Selfdestruct","parent":0,"to":"0x000000000000000000000000000000000000000a","tx":"0x0b00000000000000000000000000000000000000000000000000000000000000","value":7}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","failed":false,"method":"Receipt","tx":"0x0b00000000000000000000000000000000000000000000000000000000000000"}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","from":null,"method":"SystemOp","to":"0x000000000000000000000000000000000000000a","type":"BlockReward","value":3}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","failed":1,"failures":{"evm: execution reverted":1},"gasUsed":42000,"method":"BlockSummary","miner":"0x000000000000000000000000000000000000000a","timestamp":1,"txs":2}
+{"block":1,"blockHash":"0x0100000000000000000000000000000000000000000000000000000000000000","method":"BlockStatus","orphaned":true}
+`
+
+func TestReader(t *testing.T) {
+	r, err := NewReader(strings.NewReader(testRecords))
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	var txs []*Transaction
+	for {
+		tx, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read transaction %d: %v", len(txs), err)
+		}
+		txs = append(txs, tx)
+	}
+	if len(txs) != 2 {
+		t.Fatalf("transaction count mismatch: have %d, want 2", len(txs))
+	}
+	call, create := txs[0], txs[1]
+
+	if call.Hash != (common.Hash{0x0a}) || call.Block != 1 || call.BlockHash != (common.Hash{1}) {
+		t.Errorf("call transaction mismatch: hash %x, block %d, block hash %x", call.Hash, call.Block, call.BlockHash)
+	}
+	if len(call.Frames) != 2 || call.Depth() != 2 {
+		t.Fatalf("call frames mismatch: have %d frames of depth %d, want 2 of depth 2", len(call.Frames), call.Depth())
+	}
+	if frame := call.Frames[1]; frame.Method != "Transfer" || *frame.To != common.HexToAddress("0x0c") || frame.Value.Cmp(big.NewInt(5)) != 0 || frame.Output.String() != "0x02" || frame.MaxMemory != 64 || frame.MaxStack != 5 {
+		t.Errorf("transfer frame mismatch: %+v", frame)
+	}
+	if frame := call.Frames[1]; !frame.Static || frame.Delegated || *frame.CodeAddress != *frame.To || *frame.StorageAddress != *frame.To || call.Frames[0].Static {
+		t.Errorf("transfer context mismatch: %+v", frame)
+	}
+	if frame := call.Frames[1]; frame.FromBalance.Cmp(big.NewInt(9)) != 0 || frame.FromNonce != 1 || frame.ToBalance.Cmp(big.NewInt(5)) != 0 || call.Frames[0].FromBalance != nil {
+		t.Errorf("transfer call state mismatch: %+v", frame)
+	}
+	if frame := call.Frames[0]; frame.Error == "" || frame.OriginDepth != 2 || frame.PropagatedFrom != 1 || call.Frames[1].PropagatedFrom != -1 {
+		t.Errorf("failure propagation mismatch: %+v", frame)
+	}
+	if len(call.Precompiles) != 1 || call.Precompiles[0].Address != common.BytesToAddress([]byte{8}) || call.Precompiles[0].Error == "" || call.Precompiles[0].Parent != 1 {
+		t.Errorf("precompile mismatch: %v", call.Precompiles)
+	}
+	if op := call.GasProfile["PUSH1"]; len(call.GasProfile) != 1 || op.Count != 2 || op.Gas != 6 {
+		t.Errorf("gas profile mismatch: %v", call.GasProfile)
+	}
+	if list := call.AccessList; len(list) != 2 || list[1].Address != common.HexToAddress("0x0b") || len(list[1].Storage) != 1 || !list[1].Storage[0].Written || create.AccessList != nil {
+		t.Errorf("access list mismatch: %v", list)
+	}
+	if call.Receipt == nil || !call.Receipt.Failed || call.Receipt.GasUsed != 21000 {
+		t.Errorf("call receipt mismatch: %+v", call.Receipt)
+	}
+	if len(create.Frames) != 1 || create.Frames[0].To != nil || len(create.Selfdestructs) != 1 || create.Selfdestructs[0].Value.Cmp(big.NewInt(7)) != 0 {
+		t.Errorf("create transaction mismatch: frames %v, selfdestructs %v", create.Frames, create.Selfdestructs)
+	}
+	if hash := call.Frames[0].CodeHash; hash == nil || *hash != (common.Hash{0x0c}) || call.Frames[1].CodeHash != nil {
+		t.Errorf("code hash mismatch: have %v", hash)
+	}
+	if selector := call.Frames[0].Selector.String(); selector != "0xa9059cbb" || call.Frames[1].Selector != nil {
+		t.Errorf("selector mismatch: have %s", selector)
+	}
+	if steps := call.Frames[0].Steps; len(steps) != 1 || steps[0].Op != "SSTORE" || steps[0].Storage == nil || steps[0].Storage.To != common.BigToHash(big.NewInt(7)) || call.Frames[1].Steps != nil {
+		t.Errorf("steps mismatch: %v", steps)
+	}
+	if code := r.Code(common.Hash{0x0c}); code == nil || code.Size != 1 || len(code.Code) != 1 {
+		t.Errorf("code mismatch: %+v", code)
+	}
+	if block := r.Block(common.Hash{1}); block == nil || block.Txs != 2 || block.Failed != 1 || block.Failures["evm: execution reverted"] != 1 || block.Miner != common.HexToAddress("0x0a") {
+		t.Errorf("block summary mismatch: %+v", block)
+	}
+	if ops := r.SystemOps(common.Hash{1}); len(ops) != 1 || ops[0].Type != "BlockReward" || ops[0].From != nil || ops[0].To != common.HexToAddress("0x0a") || ops[0].Value.Cmp(big.NewInt(3)) != 0 {
+		t.Errorf("system operations mismatch: %v", ops)
+	}
+	if !r.Orphaned(common.Hash{1}) {
+		t.Errorf("block not orphaned")
+	}
+}
+
+func TestTokenTransfers(t *testing.T) {
+	var (
+		a, b, c   = common.HexToAddress("0x0a"), common.HexToAddress("0x0b"), common.HexToAddress("0x0c")
+		erc20     = common.HexToAddress("0x20")
+		erc721    = common.HexToAddress("0x721")
+		word      = func(addr common.Address) []byte { return common.LeftPadBytes(addr.Bytes(), 32) }
+		amount    = func(n int64) []byte { return common.LeftPadBytes(big.NewInt(n).Bytes(), 32) }
+		concat    = func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+		transfer  = concat(common.FromHex("0xa9059cbb"), word(b), amount(5))
+		safeTrans = concat(common.FromHex("0x42842e0e"), word(b), word(c), amount(7))
+	)
+	tx := &Transaction{Frames: []*Frame{
+		{Method: "Call", From: a, To: &erc20, Data: transfer, Index: 0, Parent: -1},
+		{Method: "Call", From: erc20, To: &erc721, Data: safeTrans, Index: 1, Parent: 0, Error: "evm: execution reverted"},
+		{Method: "DelegateCall", From: erc20, To: &erc721, Data: safeTrans, Index: 2, Parent: 0},
+		{Method: "Call", From: erc20, To: &erc721, Data: safeTrans[:40], DataTruncated: true, Index: 3, Parent: 0},
+		{Method: "Call", From: erc721, To: &erc20, Data: transfer, Index: 4, Parent: 1},
+	}}
+	want := []TokenTransfer{
+		{Token: erc20, From: a, To: b, Amount: big.NewInt(5), Frame: 0},
+		{Token: erc721, From: b, To: c, Amount: big.NewInt(7), Frame: 1, Reverted: true},
+		{Token: erc20, From: erc721, To: b, Amount: big.NewInt(5), Frame: 4, Reverted: true},
+	}
+	have := tx.TokenTransfers()
+	if len(have) != len(want) {
+		t.Fatalf("transfer count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if h := *have[i]; h.Token != want[i].Token || h.From != want[i].From || h.To != want[i].To || h.Amount.Cmp(want[i].Amount) != 0 || h.Frame != want[i].Frame || h.Reverted != want[i].Reverted {
+			t.Errorf("transfer %d mismatch: have %+v, want %+v", i, h, want[i])
+		}
+	}
+}
+
+func TestTokenEvents(t *testing.T) {
+	var (
+		a, b     = common.HexToAddress("0x0a"), common.HexToAddress("0x0b")
+		erc20    = common.HexToAddress("0x20")
+		erc721   = common.HexToAddress("0x721")
+		topic    = func(addr common.Address) common.Hash { return common.BytesToHash(addr.Bytes()) }
+		approval = common.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+		logs     = []map[string]interface{}{
+			{"address": erc20, "topics": []common.Hash{topicTransfer, topic(a), topic(b)}, "data": hexutil.Bytes(common.LeftPadBytes([]byte{5}, 32))},
+			{"address": erc721, "topics": []common.Hash{topicTransfer, topic(b), topic(a), common.BigToHash(big.NewInt(7))}, "data": hexutil.Bytes{}},
+			{"address": erc20, "topics": []common.Hash{approval, topic(a), topic(b)}, "data": hexutil.Bytes(common.LeftPadBytes([]byte{5}, 32))},
+			{"address": erc20, "topics": []common.Hash{topicTransfer, topic(a), topic(b)}, "data": hexutil.Bytes{}},
+		}
+	)
+	blob, err := json.Marshal(map[string]interface{}{"method": "Receipt", "logs": logs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := new(Transaction)
+	if err := json.Unmarshal(blob, &tx.Receipt); err != nil {
+		t.Fatalf("failed to decode receipt: %v", err)
+	}
+	want := []TokenTransfer{
+		{Token: erc20, From: a, To: b, Amount: big.NewInt(5), Frame: -1},
+		{Token: erc721, From: b, To: a, Amount: big.NewInt(7), Frame: -1},
+	}
+	have := tx.TokenEvents()
+	if len(have) != len(want) {
+		t.Fatalf("transfer count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if h := *have[i]; h.Token != want[i].Token || h.From != want[i].From || h.To != want[i].To || h.Amount.Cmp(want[i].Amount) != 0 || h.Frame != want[i].Frame || h.Reverted {
+			t.Errorf("transfer %d mismatch: have %+v, want %+v", i, h, want[i])
+		}
+	}
+	if events := new(Transaction).TokenEvents(); events != nil {
+		t.Errorf("events decoded without a receipt: %v", events)
+	}
+}
+
+func TestLoadSignatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "signatures.csv")
+	csv := "selector,signature\n0xa9059cbb,\"transfer(address,uint256)\"\n095ea7b3,approve(address,uint256)\n0xa9059cbb,collision()\n"
+	if err := ioutil.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sigs, err := LoadSignatures(path)
+	if err != nil {
+		t.Fatalf("failed to load signatures: %v", err)
+	}
+	if sig := sigs.Lookup(common.FromHex("0xa9059cbb")); sig != "transfer(address,uint256)" {
+		t.Errorf("transfer signature mismatch: have %q", sig)
+	}
+	if sig := sigs.Lookup(common.FromHex("0x095ea7b3")); sig != "approve(address,uint256)" {
+		t.Errorf("approve signature mismatch: have %q", sig)
+	}
+	if sig := sigs.Lookup(common.FromHex("0x01020304")); sig != "" {
+		t.Errorf("unknown selector resolved to %q", sig)
+	}
+	if err := ioutil.WriteFile(path, []byte(csv+"0xa9,short()\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSignatures(path); err == nil {
+		t.Errorf("invalid selector accepted")
+	}
+}
+
+// Tests that transactions rejected before their execution are read without
+// any frames or receipt.
+func TestReaderRejected(t *testing.T) {
+	records := header + `{"block":3,"blockHash":"0x0300000000000000000000000000000000000000000000000000000000000000","error":"nonce too high","method":"Rejected","tx":"0x0d00000000000000000000000000000000000000000000000000000000000000"}
+{"block":3,"blockHash":"0x0300000000000000000000000000000000000000000000000000000000000000","method":"BlockStatus","orphaned":true}
+`
+	r, err := NewReader(strings.NewReader(records))
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	tx, err := r.Next()
+	if err != nil {
+		t.Fatalf("failed to read transaction: %v", err)
+	}
+	if tx.Hash != (common.Hash{0x0d}) || tx.Rejected != "nonce too high" || len(tx.Frames) != 0 || tx.Receipt != nil {
+		t.Errorf("rejected transaction mismatch: %+v", tx)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("trailing records: %v", err)
+	}
+	if !r.Orphaned(common.Hash{3}) {
+		t.Errorf("invalid block not orphaned")
+	}
+}
+
+// Tests that the outcomes of a differential run are read along with the receipt.
+func TestReaderDivergence(t *testing.T) {
+	records := header + `{"block":3,"blockHash":"0x0300000000000000000000000000000000000000000000000000000000000000","failed":true,"gasUsed":21006,"method":"Receipt","tx":"0x0d00000000000000000000000000000000000000000000000000000000000000"}
+{"altError":"invalid opcode 0xfd","altFailed":true,"altGasUsed":50000,"block":3,"blockHash":"0x0300000000000000000000000000000000000000000000000000000000000000","error":"evm: execution reverted","failed":true,"gasUsed":21006,"method":"Divergence","tx":"0x0d00000000000000000000000000000000000000000000000000000000000000"}
+`
+	r, err := NewReader(strings.NewReader(records))
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	tx, err := r.Next()
+	if err != nil {
+		t.Fatalf("failed to read transaction: %v", err)
+	}
+	want := &Divergence{Failed: true, GasUsed: 21006, Error: "evm: execution reverted", AltFailed: true, AltGasUsed: 50000, AltError: "invalid opcode 0xfd"}
+	if tx.Receipt == nil || !reflect.DeepEqual(tx.Divergence, want) {
+		t.Errorf("divergence mismatch: have %+v, want %+v", tx.Divergence, want)
+	}
+}
+
+func TestReaderRefund(t *testing.T) {
+	records := header + `{"block":3,"blockHash":"0x0300000000000000000000000000000000000000000000000000000000000000","calleeExecuted":true,"from":"0x0100000000000000000000000000000000000000","index":0,"method":"Call","parent":-1,"refund":0,"to":"0x0a00000000000000000000000000000000000000","tx":"0x0d00000000000000000000000000000000000000000000000000000000000000","value":0}
+{"block":3,"blockHash":"0x0300000000000000000000000000000000000000000000000000000000000000","index":0,"method":"Return","refund":15000,"tx":"0x0d00000000000000000000000000000000000000000000000000000000000000"}
+{"applied":13003,"block":3,"blockHash":"0x0300000000000000000000000000000000000000000000000000000000000000","capped":true,"counter":15000,"limit":13003,"method":"Refund","tx":"0x0d00000000000000000000000000000000000000000000000000000000000000"}
+{"block":3,"blockHash":"0x0300000000000000000000000000000000000000000000000000000000000000","failed":false,"gasUsed":13003,"method":"Receipt","tx":"0x0d00000000000000000000000000000000000000000000000000000000000000"}
+`
+	r, err := NewReader(strings.NewReader(records))
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	tx, err := r.Next()
+	if err != nil {
+		t.Fatalf("failed to read transaction: %v", err)
+	}
+	want := &Refund{Counter: 15000, Limit: 13003, Applied: 13003, Capped: true}
+	if !reflect.DeepEqual(tx.Refund, want) {
+		t.Errorf("refund mismatch: have %+v, want %+v", tx.Refund, want)
+	}
+	if frame := tx.Frames[0]; frame.Refund != 0 || frame.RefundEnd != 15000 {
+		t.Errorf("frame refund counters mismatch: have %d-%d, want 0-15000", frame.Refund, frame.RefundEnd)
+	}
+}
+
+// Tests that records kept in memory decode into the same transactions as when
+// read from a record file.
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	dec := json.NewDecoder(strings.NewReader(testRecords[len(header):]))
+	dec.UseNumber()
+	for dec.More() {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("failed to decode record: %v", err)
+		}
+		m.Record(record)
+	}
+	if txs, err := m.Transactions(); err != nil || len(txs) != 0 {
+		t.Fatalf("records exposed before checkpoint: %v, %v", txs, err)
+	}
+	m.Checkpoint(1, common.Hash{1})
+
+	if receipts := m.Records("Receipt"); len(receipts) != 2 {
+		t.Errorf("receipt count mismatch: have %d, want 2", len(receipts))
+	}
+	have, err := m.Transactions()
+	if err != nil {
+		t.Fatalf("failed to decode transactions: %v", err)
+	}
+	r, _ := NewReader(strings.NewReader(testRecords))
+	var want []*Transaction
+	for {
+		tx, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		want = append(want, tx)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("transactions mismatch: have %v, want %v", have, want)
+	}
+	r, err = m.Reader()
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	if r.Iterate(func(*Transaction) error { return nil }) != nil || !r.Orphaned(common.Hash{1}) {
+		t.Errorf("block status not decoded")
+	}
+	m.Reset()
+	if records := m.Records(""); len(records) != 0 {
+		t.Errorf("records left after reset: %d", len(records))
+	}
+}
+
+// Tests that compressed record files, resumed in the middle of a block, are
+// decompressed transparently.
+func TestReaderCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "records")
+	record := func(c *Collector, tx byte) {
+		if err := c.Record(map[string]interface{}{"method": "Receipt", "tx": common.Hash{tx}, "block": tx}); err != nil {
+			t.Fatalf("failed to record %d: %v", tx, err)
+		}
+	}
+	c, err := New(WithPath(path), WithResume(), WithCompression())
+	if err != nil {
+		t.Fatalf("failed to create collector: %v", err)
+	}
+	record(c, 1)
+	c.Checkpoint(1, common.Hash{1})
+	record(c, 2)
+	c.Close()
+
+	if _, err := New(WithPath(path), WithResume()); err == nil {
+		t.Fatalf("resumed without compression")
+	}
+	if c, err = New(WithPath(path), WithResume(), WithCompression()); err != nil {
+		t.Fatalf("failed to resume collector: %v", err)
+	}
+	record(c, 2)
+	c.Checkpoint(2, common.Hash{2})
+	c.Close()
+
+	fd, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	r, err := NewReader(fd)
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	for i := byte(1); i <= 3; i++ {
+		tx, err := r.Next()
+		if i == 3 {
+			if err != io.EOF {
+				t.Fatalf("expected end of records, have %v, %v", tx, err)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read transaction %d: %v", i, err)
+		}
+		if tx.Hash != (common.Hash{i}) || tx.Block != uint64(i) || tx.Receipt == nil {
+			t.Errorf("transaction %d mismatch: hash %x, block %d", i, tx.Hash, tx.Block)
+		}
+	}
+}
+
+func TestReaderQueries(t *testing.T) {
+	// Record the same transaction again in the block replacing the orphaned one
+	canonical := strings.Replace(testRecords[len(header):], `"blockHash":"0x01`, `"blockHash":"0x02`, -1)
+	canonical = strings.Replace(canonical, `"orphaned":true`, `"orphaned":false`, -1)
+	canonical = strings.Replace(canonical, `"block":1`, `"block":2`, -1)
+
+	r, err := NewReader(strings.NewReader(testRecords + canonical))
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	tx, err := r.TxByHash(common.Hash{0x0a})
+	if err != nil {
+		t.Fatalf("failed to query transaction: %v", err)
+	}
+	if tx.BlockHash != (common.Hash{2}) || len(tx.Frames) != 2 {
+		t.Errorf("transaction mismatch: block hash %x, %d frames", tx.BlockHash, len(tx.Frames))
+	}
+	if _, err := r.TxByHash(common.Hash{0x0c}); err != ErrNotFound {
+		t.Errorf("missing transaction error mismatch: have %v, want %v", err, ErrNotFound)
+	}
+	if txs, err := r.TxsByBlockRange(1, 1); err != nil || len(txs) != 0 {
+		t.Errorf("orphaned range mismatch: have %d transactions, %v", len(txs), err)
+	}
+	if txs, err := r.TxsByBlockRange(0, 5); err != nil || len(txs) != 2 || txs[1].Hash != (common.Hash{0x0b}) {
+		t.Errorf("canonical range mismatch: have %v, %v", txs, err)
+	}
+	var frames int
+	if err := r.IterateFrames(func(tx *Transaction, frame *Frame) error {
+		frames++
+		return nil
+	}); err != nil || frames != 6 {
+		t.Errorf("frame count mismatch: have %d, want 6 (%v)", frames, err)
+	}
+}
+
+// Tests that transactions are looked up through the index of the record file,
+// skipping the ones of orphaned blocks and those dropped when resuming.
+func TestReaderIndex(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "experiment")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "records")
+		opts := []Option{WithPath(path), WithResume(), WithIndex(), WithSync()}
+		if compress {
+			opts = append(opts, WithCompression())
+		}
+		record := func(c *Collector, tx byte, block byte) {
+			if err := c.Record(map[string]interface{}{"method": "Receipt", "tx": common.Hash{tx}, "block": block, "blockHash": common.Hash{block}}); err != nil {
+				t.Fatalf("failed to record %d: %v", tx, err)
+			}
+		}
+		c, err := New(opts...)
+		if err != nil {
+			t.Fatalf("failed to create collector: %v", err)
+		}
+		record(c, 1, 1)
+		record(c, 2, 1)
+		c.Checkpoint(1, common.Hash{1})
+		record(c, 3, 2)
+		c.Close()
+
+		// Resume, replacing block 1 with block 3 and dropping the partial block 2
+		if c, err = New(opts...); err != nil {
+			t.Fatalf("failed to resume collector: %v", err)
+		}
+		record(c, 2, 3)
+		c.Record(map[string]interface{}{"method": "BlockStatus", "block": 1, "blockHash": common.Hash{1}, "orphaned": true})
+		c.Checkpoint(2, common.Hash{3})
+		c.Close()
+
+		r, err := Open(path)
+		if err != nil {
+			t.Fatalf("failed to open records: %v", err)
+		}
+		defer r.Close()
+		if r.index == nil {
+			t.Fatalf("index not opened")
+		}
+		if tx, err := r.TxByHash(common.Hash{2}); err != nil || tx.BlockHash != (common.Hash{3}) {
+			t.Errorf("compress %v: reincluded transaction mismatch: have %v, %v", compress, tx, err)
+		}
+		for _, hash := range []common.Hash{{1}, {3}} {
+			if tx, err := r.TxByHash(hash); err != ErrNotFound {
+				t.Errorf("compress %v: transaction %x found: have %v, %v", compress, hash, tx, err)
+			}
+		}
+		// Transactions recorded after the index was loaded are looked up as well
+		if c, err = New(opts...); err != nil {
+			t.Fatalf("failed to resume collector: %v", err)
+		}
+		record(c, 4, 4)
+		c.Checkpoint(3, common.Hash{4})
+		c.Close()
+
+		if tx, err := r.TxByHash(common.Hash{4}); err != nil || tx.BlockHash != (common.Hash{4}) {
+			t.Errorf("compress %v: appended transaction mismatch: have %v, %v", compress, tx, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Chain is the part of the blockchain historical transactions are replayed on.
+type Chain interface {
+	core.ChainContext
+
+	// Config retrieves the chain's fork configuration.
+	Config() *params.ChainConfig
+
+	// GetBlockByHash retrieves a block from the database by hash.
+	GetBlockByHash(hash common.Hash) *types.Block
+
+	// StateAt returns a new mutable state based on a particular point in time.
+	StateAt(root common.Hash) (*state.StateDB, error)
+}
+
+// Overrides are the parameters of a transaction and its block replaced when
+// replaying it counterfactually, left unchanged if nil.
+type Overrides struct {
+	Gas      *hexutil.Uint64 `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Balance  *hexutil.Big    `json:"balance"` // of the sender, as the transaction starts
+	Block    *hexutil.Uint64 `json:"block"`   // number of the block, selecting the fork rules
+}
+
+// Counterfactual is the outcome of a transaction as it was executed and as it
+// would have been with some of its parameters overridden.
+type Counterfactual struct {
+	Original       *Transaction `json:"original"`
+	Counterfactual *Transaction `json:"counterfactual"`
+}
+
+// replayEnv is a historical transaction ready to be replayed: the state holds
+// the changes of the transactions preceding it in its block.
+type replayEnv struct {
+	chain   Chain
+	tx      *types.Transaction
+	msg     types.Message
+	block   *types.Block
+	index   int // position of the transaction in the block
+	statedb *state.StateDB
+	gas     uint64 // gas left in the block
+	usedGas uint64 // gas used by the preceding transactions
+}
+
+// prepareReplay looks up a historical transaction and executes the transactions
+// preceding it in its block on a copy of the state of its parent.
+func prepareReplay(chain Chain, db rawdb.DatabaseReader, hash common.Hash) (*replayEnv, error) {
+	tx, blockHash, _, index := rawdb.ReadTransaction(db, hash)
+	if tx == nil {
+		return nil, fmt.Errorf("transaction %x not found", hash)
+	}
+	block := chain.GetBlockByHash(blockHash)
+	if block == nil {
+		return nil, fmt.Errorf("block %x not found", blockHash)
+	}
+	parent := chain.GetBlockByHash(block.ParentHash())
+	if parent == nil {
+		return nil, fmt.Errorf("parent %x not found", block.ParentHash())
+	}
+	statedb, err := chain.StateAt(parent.Root())
+	if err != nil {
+		return nil, err
+	}
+	var (
+		config  = chain.Config()
+		header  = block.Header()
+		gp      = new(core.GasPool).AddGas(block.GasLimit())
+		usedGas uint64
+	)
+	if config.DAOForkSupport && config.DAOForkBlock != nil && config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	for i, tx := range block.Transactions()[:index] {
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+		if _, _, err := core.ApplyTransaction(config, chain, nil, gp, statedb, header, tx, &usedGas, vm.Config{}); err != nil {
+			return nil, fmt.Errorf("transaction %x failed: %v", tx.Hash(), err)
+		}
+	}
+	msg, err := tx.AsMessage(types.MakeSigner(config, block.Number()))
+	if err != nil {
+		return nil, err
+	}
+	return &replayEnv{chain, tx, msg, block, int(index), statedb, gp.Gas(), usedGas}, nil
+}
+
+// replay executes the transaction as the given message in the given block on a
+// copy of the prepared state, returning its records.
+func (env *replayEnv) replay(header *types.Header, msg types.Message, gas uint64, config vm.RecordConfig) (*Transaction, error) {
+	var (
+		statedb = env.statedb.Copy()
+		gp      = new(core.GasPool).AddGas(gas)
+		usedGas = env.usedGas
+		memory  = NewMemory()
+		cfg     = vm.Config{Recorder: memory, RecordConfig: config}
+	)
+	statedb.Prepare(env.tx.Hash(), env.block.Hash(), env.index)
+	if _, _, err := core.ApplyTransactionMessage(env.chain.Config(), env.chain, nil, gp, statedb, header, env.tx, msg, &usedGas, cfg); err != nil {
+		return nil, err
+	}
+	if err := memory.Checkpoint(env.block.NumberU64(), env.block.Hash()); err != nil {
+		return nil, err
+	}
+	txs, err := memory.Transactions()
+	if err != nil {
+		return nil, err
+	}
+	if len(txs) != 1 {
+		return nil, fmt.Errorf("replay produced %d transactions", len(txs))
+	}
+	// Records are only tagged with their block when processing whole blocks
+	txs[0].Block, txs[0].BlockHash = env.block.NumberU64(), env.block.Hash()
+	return txs[0], nil
+}
+
+// ReplayTx re-executes a historical transaction on a copy of the state it was
+// executed on, returning the records it produces with the given configuration.
+// Nothing is written to any recorder and the chain state is left untouched.
+//
+// The transactions preceding it in its block are executed first, unrecorded,
+// so replaying late transactions of large blocks takes a while.
+func ReplayTx(chain Chain, db rawdb.DatabaseReader, hash common.Hash, config vm.RecordConfig) (*Transaction, error) {
+	env, err := prepareReplay(chain, db, hash)
+	if err != nil {
+		return nil, err
+	}
+	return env.replay(env.block.Header(), env.msg, env.gas, config)
+}
+
+// ReplayCounterfactual replays a historical transaction like ReplayTx, both as
+// it was executed and with the given parameters overridden. A counterfactual
+// execution rejected before running (e.g. the sender can't pay for the gas) is
+// returned as an error.
+//
+// The counterfactual execution isn't constrained by the gas left in the block,
+// only by the gas limit of the transaction.
+func ReplayCounterfactual(chain Chain, db rawdb.DatabaseReader, hash common.Hash, overrides Overrides, config vm.RecordConfig) (*Counterfactual, error) {
+	env, err := prepareReplay(chain, db, hash)
+	if err != nil {
+		return nil, err
+	}
+	original, err := env.replay(env.block.Header(), env.msg, env.gas, config)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		header   = env.block.Header()
+		gas      = env.msg.Gas()
+		gasPrice = env.msg.GasPrice()
+	)
+	if overrides.Gas != nil {
+		gas = uint64(*overrides.Gas)
+	}
+	if overrides.GasPrice != nil {
+		gasPrice = overrides.GasPrice.ToInt()
+	}
+	if overrides.Block != nil {
+		header.Number = new(big.Int).SetUint64(uint64(*overrides.Block))
+	}
+	if overrides.Balance != nil {
+		env.statedb.SetBalance(env.msg.From(), overrides.Balance.ToInt())
+	}
+	msg := types.NewMessage(env.msg.From(), env.msg.To(), env.msg.Nonce(), env.msg.Value(), gas, gasPrice, env.msg.Data(), env.msg.CheckNonce())
+
+	counterfactual, err := env.replay(header, msg, gas, config)
+	if err != nil {
+		return nil, fmt.Errorf("counterfactual execution rejected: %v", err)
+	}
+	return &Counterfactual{original, counterfactual}, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// storer is a contract writing a storage slot, running out of gas if called with
+// the gas of a plain transfer.
+var storer = common.Address{0xaa}
+
+// newReplayChain creates a chain of one block holding two transfers from the
+// funded account and a call of the storer with too little gas.
+func newReplayChain(t *testing.T) (*core.BlockChain, ethdb.Database, []*types.Transaction) {
+	var (
+		key, _ = crypto.GenerateKey()
+		from   = crypto.PubkeyToAddress(key.PublicKey)
+		db     = ethdb.NewMemDatabase()
+		gspec  = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				from:   {Balance: big.NewInt(params.Ether)},
+				storer: {Balance: new(big.Int), Code: common.FromHex("0x600160005500")}, // PUSH1 1 PUSH1 0 SSTORE STOP
+			},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.HomesteadSigner{}
+	)
+	var txs []*types.Transaction
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 1, func(i int, b *core.BlockGen) {
+		for nonce := uint64(0); nonce < 3; nonce++ {
+			to, gas := common.Address{0xbb}, params.TxGas
+			if nonce == 2 {
+				to, gas = storer, 25000
+			}
+			tx, _ := types.SignTx(types.NewTransaction(nonce, to, big.NewInt(1), gas, big.NewInt(1), nil), signer, key)
+			b.AddTx(tx)
+			txs = append(txs, tx)
+		}
+	})
+	chain, err := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	return chain, db, txs
+}
+
+// Tests that a transaction is replayed on the state left by the transactions
+// preceding it in its block, without touching the chain.
+func TestReplayTx(t *testing.T) {
+	chain, db, txs := newReplayChain(t)
+	defer chain.Stop()
+
+	tx, err := ReplayTx(chain, db, txs[1].Hash(), vm.RecordConfig{CallState: true})
+	if err != nil {
+		t.Fatalf("failed to replay transaction: %v", err)
+	}
+	if tx.Hash != txs[1].Hash() || tx.Block != 1 || tx.BlockHash != chain.CurrentBlock().Hash() {
+		t.Errorf("transaction mismatch: have %x in #%d [%x]", tx.Hash, tx.Block, tx.BlockHash)
+	}
+	if tx.Receipt == nil || tx.Receipt.CumulativeGasUsed != 2*params.TxGas {
+		t.Fatalf("receipt mismatch: have %+v, want cumulative gas %d", tx.Receipt, 2*params.TxGas)
+	}
+	if len(tx.Frames) != 1 || tx.Frames[0].FromNonce != 2 || tx.Frames[0].ToBalance.Int64() != 2 {
+		t.Errorf("frames not replayed on the state of the first transaction: %+v", tx.Frames[0])
+	}
+	if _, err := ReplayTx(chain, db, common.Hash{1}, vm.RecordConfig{}); err == nil {
+		t.Errorf("unknown transaction replayed")
+	}
+}
+
+// Tests that a transaction replayed with more gas is recorded both as it failed
+// and as it would have succeeded.
+func TestReplayCounterfactual(t *testing.T) {
+	chain, db, txs := newReplayChain(t)
+	defer chain.Stop()
+
+	gas, price := hexutil.Uint64(50000), (*hexutil.Big)(big.NewInt(2))
+	replay, err := ReplayCounterfactual(chain, db, txs[2].Hash(), Overrides{Gas: &gas, GasPrice: price}, vm.RecordConfig{})
+	if err != nil {
+		t.Fatalf("failed to replay transaction: %v", err)
+	}
+	if original := replay.Original.Receipt; !original.Failed || original.GasUsed != 25000 {
+		t.Errorf("original outcome mismatch: have %+v", original)
+	}
+	if counterfactual := replay.Counterfactual.Receipt; counterfactual.Failed || counterfactual.GasPrice.Int64() != 2 {
+		t.Errorf("counterfactual outcome mismatch: have %+v", counterfactual)
+	}
+	balance := (*hexutil.Big)(big.NewInt(1))
+	if _, err := ReplayCounterfactual(chain, db, txs[2].Hash(), Overrides{Balance: balance}, vm.RecordConfig{}); err == nil {
+		t.Errorf("counterfactual execution without funds not rejected")
+	}
+}
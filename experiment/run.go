@@ -0,0 +1,81 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Run describes a recording run: the settings its records were gathered with
+// and the blocks they cover.
+type Run struct {
+	ID          string              `json:"id"`
+	Config      vm.RecordConfig     `json:"config"`
+	Codec       string              `json:"codec,omitempty"`
+	Indexed     bool                `json:"indexed"`
+	ChainConfig *params.ChainConfig `json:"chainConfig"` // nil if not known to the collector
+	Versions    []string            `json:"versions"`    // client versions which recorded the run, in order
+	Start       *uint64             `json:"start"`       // first recorded block, nil if none
+	End         *uint64             `json:"end"`         // last recorded block, nil if none
+	EndHash     *common.Hash        `json:"endHash"`
+	Clean       bool                `json:"clean"` // whether the last session was shut down cleanly
+}
+
+// run converts the progress of a run into its description.
+func (p *progress) run(checkpointed bool) *Run {
+	run := &Run{
+		ID:          p.RunID,
+		Config:      p.Config,
+		Codec:       p.Codec,
+		Indexed:     p.Indexed,
+		ChainConfig: p.ChainConfig,
+		Versions:    p.Versions,
+		Clean:       p.Clean,
+	}
+	if checkpointed {
+		start, end, hash := p.Start, p.Number, p.Hash
+		run.Start, run.End, run.EndHash = &start, &end, &hash
+	}
+	return run
+}
+
+// Run returns the description of the recording run.
+func (c *Collector) Run() *Run {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.progress.run(c.checkpointed)
+}
+
+// ReadRun returns the description of the run recorded into the given record
+// file, as of its last checkpoint.
+func ReadRun(path string) (*Run, error) {
+	blob, err := ioutil.ReadFile(progressPath(path))
+	if err != nil {
+		return nil, err
+	}
+	var p progress
+	if err := json.Unmarshal(blob, &p); err != nil {
+		return nil, err
+	}
+	return p.run(true), nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Signatures resolves function selectors to human-readable signatures, such as
+// "transfer(address,uint256)".
+type Signatures map[[4]byte]string
+
+// LoadSignatures imports a signature database from a CSV file of selector and
+// signature pairs, e.g. "0xa9059cbb,transfer(address,uint256)", the 0x prefix
+// and quoting the signature being optional. A header row is skipped. If several
+// signatures share a selector, the first one is kept.
+func LoadSignatures(path string) (Signatures, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		sigs = make(Signatures)
+		in   = csv.NewReader(file)
+	)
+	in.FieldsPerRecord = -1
+	for line := 1; ; line++ {
+		row, err := in.Read()
+		if err == io.EOF {
+			return sigs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Signatures with several parameters may not be quoted
+		if len(row) < 2 {
+			return nil, fmt.Errorf("line %d: missing signature", line)
+		}
+		hex := row[0]
+		if !strings.HasPrefix(hex, "0x") {
+			hex = "0x" + hex
+		}
+		selector, err := hexutil.Decode(hex)
+		if err != nil || len(selector) != 4 {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid selector %q", line, row[0])
+		}
+		var key [4]byte
+		copy(key[:], selector)
+		if _, ok := sigs[key]; !ok {
+			sigs[key] = strings.Join(row[1:], ",")
+		}
+	}
+}
+
+// Lookup returns the signature of the given selector, or an empty string if it
+// is unknown.
+func (s Signatures) Lookup(selector []byte) string {
+	if len(selector) != 4 {
+		return ""
+	}
+	var key [4]byte
+	copy(key[:], selector)
+	return s[key]
+}
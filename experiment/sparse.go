@@ -0,0 +1,163 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// BlockBloom is the record kept for a block with failed transactions in sparse
+// mode: a bloom filter of the contracts, functions and exception kinds they
+// failed with, to locate the blocks worth recording in full for a query.
+type BlockBloom struct {
+	Number uint64      `json:"block"`
+	Hash   common.Hash `json:"blockHash"`
+	Failed int         `json:"failed"` // number of failed transactions
+	Bloom  types.Bloom `json:"bloom"`
+}
+
+// sparseKey returns the key of a (contract, selector, kind) tuple in the bloom
+// filters, the components left out being nil or empty.
+func sparseKey(contract *common.Address, selector []byte, kind string) []byte {
+	key := []byte{0}
+	if contract != nil {
+		key[0] |= 1
+		key = append(key, contract.Bytes()...)
+	}
+	if selector != nil {
+		key[0] |= 2
+		key = append(key, selector...)
+	}
+	if kind != "" {
+		key[0] |= 4
+		key = append(key, kind...)
+	}
+	return key
+}
+
+// bloomBits returns the three bits of the bloom filter set for a key, chosen
+// like those of the log blooms of headers.
+func bloomBits(key []byte) [3]uint {
+	var (
+		hash = crypto.Keccak256(key)
+		bits [3]uint
+	)
+	for i := range bits {
+		bits[i] = (uint(hash[2*i])<<8 | uint(hash[2*i+1])) & (types.BloomBitLength - 1)
+	}
+	return bits
+}
+
+// add adds a failed transaction to the bloom filter, under every combination of
+// its contract, selector and exception kind, so that queries may leave any of
+// them out.
+// Components which aren't recorded are left nil or empty.
+func (b *BlockBloom) add(contract *common.Address, selector []byte, kind string) {
+	b.Failed++
+	for _, contract := range []*common.Address{contract, nil} {
+		for _, selector := range [][]byte{selector, nil} {
+			for _, kind := range []string{kind, ""} {
+				if contract == nil && selector == nil && kind == "" {
+					continue
+				}
+				for _, bit := range bloomBits(sparseKey(contract, selector, kind)) {
+					b.Bloom[types.BloomByteLength-1-bit/8] |= 1 << (bit % 8)
+				}
+			}
+		}
+	}
+}
+
+// MayContain reports whether the block may have a transaction which failed
+// calling the given contract and function with the given exception kind (e.g.
+// KindInvalidJump), any of them if left nil or empty. False positives are
+// possible, false negatives are not.
+func (b *BlockBloom) MayContain(contract *common.Address, selector []byte, kind string) bool {
+	if contract == nil && selector == nil && kind == "" {
+		return b.Failed > 0
+	}
+	for _, bit := range bloomBits(sparseKey(contract, selector, kind)) {
+		if b.Bloom[types.BloomByteLength-1-bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// writeBloom adds the bloom filter of the failed transactions of the block to
+// the pending records, unless none failed. Exceptions are inserted by kind
+// rather than error, whose message varies with the faulting pc and opcode. The
+// filter only holds what the records would: exceptions are tracked from
+// anonymized records and their fields dropped from the records are left out,
+// so the block of the filter is anonymized as well.
+func (c *Collector) writeBloom(number uint64, hash common.Hash) error {
+	if len(c.pendingExceptions) == 0 {
+		return nil
+	}
+	bloom := new(BlockBloom)
+	for _, exception := range c.pendingExceptions {
+		fields := c.project(map[string]interface{}{"to": exception.To, "selector": exception.Selector, "error": exception.Error})
+
+		var contract *common.Address
+		if to, ok := fields["to"].(common.Address); ok {
+			contract = &to
+		}
+		selector, _ := fields["selector"].(hexutil.Bytes)
+
+		var kind string
+		if err, ok := fields["error"].(string); ok {
+			kind = ExceptionKind(err)
+		}
+		bloom.add(contract, selector, kind)
+	}
+	record := map[string]interface{}{"method": "BlockBloom", "block": new(big.Int).SetUint64(number), "blockHash": hash, "failed": bloom.Failed, "bloom": bloom.Bloom}
+	if c.anonymizer != nil {
+		record = c.anonymizer.record(record)
+	}
+	return c.enc.Encode(record)
+}
+
+// Locate rewinds the reader and returns the blooms of the blocks of a sparse
+// record file which may have a transaction failed calling the given contract
+// and function with the given exception kind, any of them if left nil or
+// empty. Blocks marked orphaned are skipped.
+func (r *Reader) Locate(contract *common.Address, selector []byte, kind string) ([]*BlockBloom, error) {
+	if err := r.Rewind(); err != nil {
+		return nil, err
+	}
+	r.blooms = nil
+	for {
+		if _, err := r.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	var blooms []*BlockBloom
+	for _, bloom := range r.blooms {
+		if !r.Orphaned(bloom.Hash) && bloom.MayContain(contract, selector, kind) {
+			blooms = append(blooms, bloom)
+		}
+	}
+	return blooms, nil
+}
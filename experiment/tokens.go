@@ -0,0 +1,120 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Selectors of the standard token transfer functions. ERC-20 and ERC-721 share
+// the one of transferFrom(address,address,uint256).
+var (
+	selectorTransfer         = [4]byte{0xa9, 0x05, 0x9c, 0xbb} // transfer(address,uint256)
+	selectorTransferFrom     = [4]byte{0x23, 0xb8, 0x72, 0xdd} // transferFrom(address,address,uint256)
+	selectorSafeTransferFrom = [4]byte{0x42, 0x84, 0x2e, 0x0e} // safeTransferFrom(address,address,uint256)
+	selectorSafeTransferData = [4]byte{0xb8, 0x8d, 0x4f, 0xde} // safeTransferFrom(address,address,uint256,bytes)
+)
+
+// topicTransfer is the topic of the standard Transfer(address,address,uint256)
+// event, emitted by both ERC-20 and ERC-721 tokens. The latter index the token
+// id as well.
+var topicTransfer = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+
+// TokenTransfer is a token movement attempted by a call to a standard token
+// transfer function.
+type TokenTransfer struct {
+	Token    common.Address
+	From     common.Address
+	To       common.Address
+	Amount   *big.Int // amount of ERC-20 tokens or id of the ERC-721 token
+	Frame    int      // index of the frame calling the transfer function, -1 for events
+	Reverted bool     // whether the frame or any of its ancestors failed
+}
+
+// TokenTransfers decodes the calls to standard token transfer functions among
+// the frames of the transaction. Calls whose data was truncated can't be
+// decoded and are skipped, as are delegated ones. Transfers made by other
+// means are only found among the token events, see TokenEvents.
+func (tx *Transaction) TokenTransfers() []*TokenTransfer {
+	var (
+		transfers []*TokenTransfer
+		reverted  = make(map[int]bool)
+	)
+	for _, frame := range tx.Frames {
+		reverted[frame.Index] = frame.Error != "" || reverted[frame.Parent]
+	}
+	for _, frame := range tx.Frames {
+		if frame.Method != "Call" || frame.To == nil || frame.DataTruncated || len(frame.Data) < 4 {
+			continue
+		}
+		var (
+			selector [4]byte
+			args     = frame.Data[4:]
+		)
+		copy(selector[:], frame.Data)
+
+		transfer := &TokenTransfer{Token: *frame.To, Frame: frame.Index, Reverted: reverted[frame.Index]}
+		switch {
+		case selector == selectorTransfer && len(args) >= 64:
+			transfer.From = frame.From
+			transfer.To = common.BytesToAddress(args[12:32])
+			transfer.Amount = new(big.Int).SetBytes(args[32:64])
+		case (selector == selectorTransferFrom || selector == selectorSafeTransferFrom || selector == selectorSafeTransferData) && len(args) >= 96:
+			transfer.From = common.BytesToAddress(args[12:32])
+			transfer.To = common.BytesToAddress(args[44:64])
+			transfer.Amount = new(big.Int).SetBytes(args[64:96])
+		default:
+			continue
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers
+}
+
+// TokenEvents decodes the standard token Transfer events among the logs of the
+// transaction, which tell the transfers actually made whatever function made
+// them. Logs are only recorded if enabled, and events of reverted frames are
+// discarded, so the transfers returned are never reverted.
+func (tx *Transaction) TokenEvents() []*TokenTransfer {
+	if tx.Receipt == nil {
+		return nil
+	}
+	var transfers []*TokenTransfer
+	for _, log := range tx.Receipt.Logs {
+		if len(log.Topics) < 3 || log.Topics[0] != topicTransfer {
+			continue
+		}
+		transfer := &TokenTransfer{
+			Token: log.Address,
+			From:  common.BytesToAddress(log.Topics[1].Bytes()),
+			To:    common.BytesToAddress(log.Topics[2].Bytes()),
+			Frame: -1,
+		}
+		switch {
+		case len(log.Topics) == 3 && len(log.Data) >= 32:
+			transfer.Amount = new(big.Int).SetBytes(log.Data[:32])
+		case len(log.Topics) == 4:
+			transfer.Amount = log.Topics[3].Big()
+		default:
+			continue
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers
+}
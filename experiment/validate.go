@@ -0,0 +1,113 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// frameMethods are the methods of the records opening a call frame.
+var frameMethods = map[string]bool{
+	"Call": true, "Transfer": true, "CallCode": true, "DelegateCall": true, "StaticCall": true, "Create": true,
+}
+
+// quantityFields are the fields of records holding amounts of wei or gas.
+var quantityFields = []string{"value", "fromBalance", "toBalance", "gas", "cost", "gasUsed", "cumulativeGasUsed", "gasPrice"}
+
+// checkQuantity checks that the amount held by a field of a record, if any, is
+// a number and not negative.
+func checkQuantity(record map[string]interface{}, field string) error {
+	switch v := record[field].(type) {
+	case nil, uint64, uint:
+	case *big.Int:
+		if v != nil && v.Sign() < 0 {
+			return fmt.Errorf("negative %s %v", field, v)
+		}
+	case int:
+		if v < 0 {
+			return fmt.Errorf("negative %s %d", field, v)
+		}
+	case int64:
+		if v < 0 {
+			return fmt.Errorf("negative %s %d", field, v)
+		}
+	default:
+		return fmt.Errorf("invalid %s type %T", field, v)
+	}
+	return nil
+}
+
+// validator checks the records of a run against the constraints of the record
+// format. It is reset at every checkpoint, as transactions don't span blocks.
+type validator struct {
+	tx     common.Hash // transaction of the last record
+	frames int         // number of frames recorded for that transaction
+}
+
+// validate checks a single record, in the order it was written.
+func (v *validator) validate(record map[string]interface{}) error {
+	method, _ := record["method"].(string)
+	if method == "" {
+		return errors.New("missing method")
+	}
+	if hash, ok := record["blockHash"]; ok && hash == (common.Hash{}) {
+		return errors.New("empty block hash")
+	}
+	for _, field := range quantityFields {
+		if err := checkQuantity(record, field); err != nil {
+			return err
+		}
+	}
+	tx, ok := record["tx"]
+	if !ok {
+		return nil
+	}
+	hash, _ := tx.(common.Hash)
+	if hash == (common.Hash{}) {
+		return errors.New("empty transaction hash")
+	}
+	if hash != v.tx {
+		v.tx, v.frames = hash, 0
+	}
+	switch {
+	case frameMethods[method]:
+		index, ok := record["index"].(int)
+		if !ok {
+			return fmt.Errorf("invalid frame index type %T", record["index"])
+		}
+		parent, ok := record["parent"].(int)
+		if !ok {
+			return fmt.Errorf("invalid parent type %T of frame %d", record["parent"], index)
+		}
+		if index != v.frames {
+			return fmt.Errorf("frame index %d out of order, want %d", index, v.frames)
+		}
+		if parent < -1 || parent >= index || (index > 0 && parent == -1) {
+			return fmt.Errorf("invalid parent %d of frame %d", parent, index)
+		}
+		v.frames++
+	case method == "Return":
+		if index, _ := record["index"].(int); index < 0 || index >= v.frames {
+			return fmt.Errorf("return of unknown frame %d", index)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package experiment
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// viewsHistory is the number of most recent blocks whose contributions to the
+	// views are kept, so they can be taken back if the blocks are reorged out.
+	viewsHistory = 1024
+
+	// viewsSaveInterval is the least time between two saves of the views, which
+	// are caught up from the record file on resume.
+	viewsSaveInterval = time.Minute
+)
+
+// Views are aggregates of the recorded transactions, maintained incrementally
+// as blocks are checkpointed instead of being recomputed from the record file.
+// Transactions of orphaned blocks are taken out of them.
+type Views struct {
+	Number    uint64                           `json:"number"`    // last aggregated block
+	Offset    int64                            `json:"offset"`    // size of the record file at that block
+	Daily     map[string]*DailyView            `json:"daily"`     // by UTC day of the block timestamps, as 2006-01-02
+	Contracts map[common.Address]*ContractView `json:"contracts"` // by called or created contract
+	GasWasted uint64                           `json:"gasWasted"` // gas used by failed transactions
+}
+
+// DailyView aggregates the transactions of the blocks of a day.
+type DailyView struct {
+	Txs        uint64            `json:"txs"`
+	Failed     uint64            `json:"failed"`
+	GasWasted  uint64            `json:"gasWasted"`
+	Exceptions map[string]uint64 `json:"exceptions"` // failed transactions by the error of their outermost frame
+}
+
+// ContractView aggregates the transactions calling or creating a contract.
+type ContractView struct {
+	Txs       uint64 `json:"txs"`
+	Failed    uint64 `json:"failed"`
+	GasWasted uint64 `json:"gasWasted"`
+}
+
+// RevertRate returns the share of the transactions calling the contract which
+// failed.
+func (v *ContractView) RevertRate() float64 {
+	if v.Txs == 0 {
+		return 0
+	}
+	return float64(v.Failed) / float64(v.Txs)
+}
+
+// viewTx is the outcome of a transaction, as aggregated into the views.
+type viewTx struct {
+	contract common.Address
+	failed   bool
+	gasUsed  uint64
+	err      string // error of the outermost frame, empty if unknown
+}
+
+// blockView holds the transactions of a block aggregated into the views, until
+// the block is too old to be reorged.
+type blockView struct {
+	number   uint64
+	day      string
+	txs      []viewTx
+	orphaned bool // whether the block was marked orphaned
+	applied  bool // whether the transactions are aggregated into the views
+}
+
+// newViews creates empty views.
+func newViews() *Views {
+	return &Views{Daily: make(map[string]*DailyView), Contracts: make(map[common.Address]*ContractView)}
+}
+
+// adjust adds the transactions of a block to the views, or takes them out of
+// them if undo is set.
+func (v *Views) adjust(block *blockView, undo bool) {
+	add := func(n *uint64, delta uint64) {
+		if undo {
+			*n -= delta
+		} else {
+			*n += delta
+		}
+	}
+	for _, tx := range block.txs {
+		day := v.Daily[block.day]
+		if day == nil {
+			day = &DailyView{Exceptions: make(map[string]uint64)}
+			v.Daily[block.day] = day
+		}
+		contract := v.Contracts[tx.contract]
+		if contract == nil {
+			contract = new(ContractView)
+			v.Contracts[tx.contract] = contract
+		}
+		add(&day.Txs, 1)
+		add(&contract.Txs, 1)
+		if tx.failed {
+			add(&day.Failed, 1)
+			add(&day.GasWasted, tx.gasUsed)
+			add(&contract.Failed, 1)
+			add(&contract.GasWasted, tx.gasUsed)
+			add(&v.GasWasted, tx.gasUsed)
+
+			exceptions := day.Exceptions[tx.err]
+			add(&exceptions, 1)
+			if day.Exceptions[tx.err] = exceptions; exceptions == 0 {
+				delete(day.Exceptions, tx.err)
+			}
+		}
+		if day.Txs == 0 {
+			delete(v.Daily, block.day)
+		}
+		if contract.Txs == 0 {
+			delete(v.Contracts, tx.contract)
+		}
+	}
+	block.applied = !undo
+}
+
+// copy returns a deep copy of the views.
+func (v *Views) copy() *Views {
+	cpy := &Views{Number: v.Number, Offset: v.Offset, GasWasted: v.GasWasted, Daily: make(map[string]*DailyView), Contracts: make(map[common.Address]*ContractView)}
+	for key, day := range v.Daily {
+		dayCpy := *day
+		dayCpy.Exceptions = make(map[string]uint64, len(day.Exceptions))
+		for err, n := range day.Exceptions {
+			dayCpy.Exceptions[err] = n
+		}
+		cpy.Daily[key] = &dayCpy
+	}
+	for addr, contract := range v.Contracts {
+		contractCpy := *contract
+		cpy.Contracts[addr] = &contractCpy
+	}
+	return cpy
+}
+
+// viewDay returns the UTC day of a block timestamp.
+func viewDay(timestamp uint64) string {
+	return time.Unix(int64(timestamp), 0).UTC().Format("2006-01-02")
+}
+
+// viewsPath returns the file the views of the run recorded into the given record
+// file are persisted into.
+func viewsPath(path string) string {
+	return path + ".views"
+}
+
+// Views returns a copy of the current views, or nil if they aren't maintained.
+func (c *Collector) Views() *Views {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.views == nil {
+		return nil
+	}
+	return c.views.copy()
+}
+
+// trackView gathers the outcomes of the transactions being recorded into the
+// views of their blocks, and takes orphaned blocks out of the views.
+func (c *Collector) trackView(record map[string]interface{}) {
+	hash, _ := record["blockHash"].(common.Hash)
+	block := c.pendingViews[hash]
+	if block == nil && record["method"] != "BlockStatus" {
+		block = new(blockView)
+		if number, ok := record["block"].(*big.Int); ok {
+			block.number = number.Uint64()
+		}
+		c.pendingViews[hash] = block
+	}
+	switch record["method"] {
+	case "Receipt":
+		tx := c.viewTx
+		tx.failed, _ = record["failed"].(bool)
+		tx.gasUsed, _ = record["gasUsed"].(uint64)
+		if addr, ok := record["contractAddress"].(common.Address); ok && addr != (common.Address{}) {
+			tx.contract = addr
+		}
+		block.txs = append(block.txs, tx)
+		c.viewTx = viewTx{}
+	case "Return":
+		if record["index"] == 0 {
+			c.viewTx.err, _ = record["error"].(string)
+		}
+	case "BlockSummary":
+		if timestamp, ok := record["timestamp"].(*big.Int); ok {
+			block.day = viewDay(timestamp.Uint64())
+		}
+	case "BlockStatus":
+		orphaned, _ := record["orphaned"].(bool)
+		if block == nil {
+			block = c.recentViews[hash]
+		}
+		if block == nil {
+			return // too old or never recorded
+		}
+		block.orphaned = orphaned
+		if block.applied == orphaned && c.recentViews[hash] != nil {
+			c.views.adjust(block, orphaned)
+		}
+	default:
+		if record["parent"] == -1 {
+			c.viewTx = viewTx{}
+			c.viewTx.contract, _ = record["to"].(common.Address)
+			if record["calleeExecuted"] == false {
+				c.viewTx.err, _ = record["error"].(string)
+			}
+		}
+	}
+}
+
+// applyViews aggregates the pending blocks into the views as of the checkpoint,
+// forgetting the contributions of blocks too old to be reorged, and persists
+// the views if they weren't saved for a while.
+func (c *Collector) applyViews(number uint64) error {
+	for hash, block := range c.pendingViews {
+		if !block.orphaned {
+			c.views.adjust(block, false)
+		}
+		c.recentViews[hash] = block
+	}
+	c.pendingViews = make(map[common.Hash]*blockView)
+	for hash, block := range c.recentViews {
+		if block.number+viewsHistory < number {
+			delete(c.recentViews, hash)
+		}
+	}
+	c.views.Number, c.views.Offset = number, c.progress.Offset
+
+	if c.dryRun || time.Since(c.viewsSaved) < viewsSaveInterval {
+		return nil
+	}
+	return c.saveViews()
+}
+
+// saveViews atomically persists the views next to the record file.
+func (c *Collector) saveViews() error {
+	blob, err := json.Marshal(c.views)
+	if err != nil {
+		return err
+	}
+	tmp := viewsPath(c.path) + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, viewsPath(c.path)); err != nil {
+		return err
+	}
+	c.viewsSaved = time.Now()
+	return nil
+}
+
+// loadViews loads the views persisted by the previous session of the run and
+// catches them up with the blocks checkpointed after they were saved.
+func (c *Collector) loadViews() error {
+	c.views = newViews()
+	blob, err := ioutil.ReadFile(viewsPath(c.path))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(blob, c.views); err != nil {
+			return err
+		}
+	}
+	if c.views.Offset > c.progress.Offset {
+		logger.Warn("Transaction data views ahead of the records, rebuilding them", "views", c.views.Number, "records", c.progress.Number)
+		c.views = newViews()
+	}
+	if c.views.Offset == c.progress.Offset {
+		return nil
+	}
+	// Aggregate the transactions recorded since the views were saved
+	r, err := NewReader(io.NewSectionReader(c.file, c.views.Offset, c.progress.Offset-c.views.Offset))
+	if err != nil {
+		return err
+	}
+	var (
+		blocks = make(map[common.Hash]*blockView)
+		order  []common.Hash
+	)
+	for {
+		tx, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		block := blocks[tx.BlockHash]
+		if block == nil {
+			block = &blockView{number: tx.Block}
+			blocks[tx.BlockHash], order = block, append(order, tx.BlockHash)
+		}
+		if tx.Receipt == nil || len(tx.Frames) == 0 {
+			continue
+		}
+		outcome := viewTx{contract: tx.Receipt.ContractAddress, failed: tx.Receipt.Failed, gasUsed: tx.Receipt.GasUsed, err: tx.Frames[0].Error}
+		if to := tx.Frames[0].To; to != nil {
+			outcome.contract = *to
+		}
+		block.txs = append(block.txs, outcome)
+	}
+	for _, hash := range order {
+		block := blocks[hash]
+		if summary := r.Block(hash); summary != nil {
+			block.day = viewDay(summary.Time)
+		}
+		if !r.Orphaned(hash) {
+			c.views.adjust(block, false)
+		}
+	}
+	logger.Info("Caught up transaction data views", "from", c.views.Number, "to", c.progress.Number, "blocks", len(order))
+	c.views.Number, c.views.Offset = c.progress.Number, c.progress.Offset
+	return nil
+}
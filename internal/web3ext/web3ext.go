@@ -23,6 +23,7 @@ var Modules = map[string]string{
 	"clique":     Clique_JS,
 	"debug":      Debug_JS,
 	"eth":        Eth_JS,
+	"experiment": Experiment_JS,
 	"miner":      Miner_JS,
 	"net":        Net_JS,
 	"personal":   Personal_JS,
@@ -605,6 +606,39 @@ web3._extend({
 });
 `
 
+const Experiment_JS = `
+web3._extend({
+	property: 'experiment',
+	methods: [
+		new web3._extend.Method({
+			name: 'replayTx',
+			call: 'experiment_replayTx',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'replayCounterfactual',
+			call: 'experiment_replayCounterfactual',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'hotspots',
+			call: 'experiment_hotspots',
+			params: 1
+		}),
+	],
+	properties: [
+		new web3._extend.Property({
+			name: 'status',
+			getter: 'experiment_status'
+		}),
+		new web3._extend.Property({
+			name: 'views',
+			getter: 'experiment_views'
+		}),
+	]
+});
+`
+
 const TxPool_JS = `
 web3._extend({
 	property: 'txpool',